@@ -3,10 +3,14 @@ module github.com/carbocation/watchurl
 go 1.21.0
 
 require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/andybalholm/cascadia v1.3.3
 	github.com/dustin/go-humanize v1.0.1
 	github.com/joho/godotenv v1.5.1
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sergi/go-diff v1.3.1
 	golang.org/x/net v0.35.0
+	gopkg.in/yaml.v2 v2.4.0
 	modernc.org/sqlite v1.36.0
 )
 
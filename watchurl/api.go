@@ -0,0 +1,156 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIURL is the JSON representation of a monitored URL.
+type APIURL struct {
+	ID          int        `json:"id"`
+	URL         string     `json:"url"`
+	Frequency   int        `json:"frequency"`
+	Channels    []string   `json:"channels"`
+	Tags        []string   `json:"tags"`
+	LastUpdated *time.Time `json:"last_updated"`
+}
+
+// APIURLRequest is the JSON body accepted when adding a URL through the API.
+// Push is shorthand for the pushover channel.
+type APIURLRequest struct {
+	URL       string   `json:"url"`
+	Frequency int      `json:"frequency"`
+	Push      bool     `json:"push"`
+	Channels  []string `json:"channels"`
+	Tags      []string `json:"tags"`
+}
+
+// writeJSON writes v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error encoding JSON response", "error", err)
+	}
+}
+
+// writeJSONError writes an error message as a JSON response.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// apiURLsHandler lists monitored URLs on GET, optionally only those with the
+// tag given by ?tag=, and adds one on POST.
+func apiURLsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		urls, err := listAPIURLs(r.URL.Query().Get("tag"))
+		if err != nil {
+			slog.Error("Error listing URLs for API", "tag", r.URL.Query().Get("tag"), "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		writeJSON(w, http.StatusOK, urls)
+	case http.MethodPost:
+		var req APIURLRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		req.URL = strings.TrimSpace(req.URL)
+		if req.URL == "" {
+			writeJSONError(w, http.StatusBadRequest, "missing url")
+			return
+		}
+		if err := validateMonitoredURL(req.URL); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid url: must be an absolute http or https URL")
+			return
+		}
+		if req.Frequency <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid frequency")
+			return
+		}
+		if err := checkFrequency(time.Duration(req.Frequency) * time.Second); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Push {
+			req.Channels = append(req.Channels, channelPushover)
+		}
+		m := MonitoredURL{
+			URL:       req.URL,
+			Frequency: time.Duration(req.Frequency) * time.Second,
+			Channels:  filterChannels(req.Channels),
+			Tags:      parseTags(strings.Join(req.Tags, ",")),
+		}
+		if err := addMonitoredURL(&m); err != nil {
+			var dup *duplicateURLError
+			if errors.As(err, &dup) {
+				writeJSONError(w, http.StatusConflict, dup.Error())
+				return
+			}
+			slog.Error("Error adding URL through API", "url", m.URL, "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		writeJSON(w, http.StatusCreated, APIURL{ID: m.ID, URL: m.URL, Frequency: req.Frequency, Channels: nonNilStrings(m.Channels), Tags: nonNilStrings(m.Tags)})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// listAPIURLs returns the monitored URLs along with their last snapshot time.
+// If tag is not empty, only URLs with that tag are returned.
+func listAPIURLs(tag string) ([]APIURL, error) {
+	rows, err := db.Query(`
+        SELECT mu.id, mu.url, mu.frequency, mu.channels, mu.tags, s.last_updated
+        FROM monitored_urls mu
+        LEFT JOIN (
+            SELECT url_id, MAX(timestamp) as last_updated
+            FROM url_snapshots
+            WHERE region = ''
+            GROUP BY url_id
+        ) s ON mu.id = s.url_id
+        ORDER BY mu.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := []APIURL{}
+	for rows.Next() {
+		var u APIURL
+		var channels, tags string
+		var lastUpdated sql.NullString
+		if err := rows.Scan(&u.ID, &u.URL, &u.Frequency, &channels, &tags, &lastUpdated); err != nil {
+			return nil, err
+		}
+		u.Tags = nonNilStrings(parseTags(tags))
+		if tag != "" && !hasTag(u.Tags, tag) {
+			continue
+		}
+		u.Channels = nonNilStrings(parseChannels(channels))
+		if lastUpdated.Valid {
+			if t, err := parseStoredTime(lastUpdated.String); err == nil {
+				u.LastUpdated = &t
+			}
+		}
+		urls = append(urls, u)
+	}
+	return urls, rows.Err()
+}
+
+// nonNilStrings returns s, or an empty slice if it is nil, so that URLs
+// without tags or channels are encoded as [] rather than null.
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIAddURLValidatesURL(t *testing.T) {
+	openTestDB(t)
+	// The added URL is monitored straight away, so it points at a local
+	// server.
+	srv := httptest.NewServer(&testSite{body: "<p>Hello</p>"})
+	defer srv.Close()
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{"ftp://x", http.StatusBadRequest},
+		{"example.com", http.StatusBadRequest},
+		{"https://", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+		{"  " + srv.URL + "/  ", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		body := `{"url": "` + tt.url + `", "frequency": 3600}`
+		r := httptest.NewRequest(http.MethodPost, "/api/urls", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		apiURLsHandler(w, r)
+		if w.Code != tt.want {
+			t.Errorf("adding %q: got %d %s, want %d", tt.url, w.Code, w.Body.String(), tt.want)
+		}
+	}
+	var stored string
+	if err := db.QueryRow("SELECT url FROM monitored_urls").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != srv.URL+"/" {
+		t.Errorf("stored %q, want the trimmed URL", stored)
+	}
+}
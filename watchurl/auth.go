@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+)
+
+// requireBasicAuth wraps next with HTTP Basic Auth using the AUTH_USER and
+// AUTH_PASS environment variables. If either is unset, next is returned
+// unwrapped and the UI stays open. /healthz is always open.
+func requireBasicAuth(next http.Handler) http.Handler {
+	user, pass := os.Getenv("AUTH_USER"), os.Getenv("AUTH_PASS")
+	if user == "" || pass == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		u, p, ok := r.BasicAuth()
+		// Compare both fields even if the first differs, so the response
+		// time doesn't reveal which one was wrong.
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(p), []byte(pass)) == 1
+		if !ok || !userOK || !passOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="watchurl", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
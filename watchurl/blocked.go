@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// blockedPageError is returned for a fetched page matching one of its URL's
+// block markers, such as a CAPTCHA or "access denied" page served with a
+// 200 status. The fetch counts as failed, so the page is neither saved nor
+// taken for a change.
+type blockedPageError struct {
+	Marker string
+}
+
+func (e *blockedPageError) Error() string {
+	return fmt.Sprintf("page matches block marker %q", e.Marker)
+}
+
+// checkBlockMarkers returns a *blockedPageError if body, a page fetched for
+// m, matches any of m's block markers.
+func checkBlockMarkers(m MonitoredURL, body string) error {
+	for _, marker := range m.BlockMarkers {
+		re, err := regexp.Compile(marker)
+		if err != nil {
+			m.logger().Warn("Ignoring invalid block marker", "marker", marker, "error", err)
+			continue
+		}
+		if re.MatchString(body) {
+			return &blockedPageError{Marker: marker}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxBulkUploadBytes bounds the size of a bulk-add request, uploaded file
+// included.
+const maxBulkUploadBytes = 1 << 20
+
+// BulkResult is the outcome of one line of a bulk add.
+type BulkResult struct {
+	// Source is "list" for the textarea, or the name of the uploaded file.
+	Source string
+	Line   int
+	URL    string
+	ID     int
+	Error  string
+}
+
+// bulkSource is a list of URLs given to a bulk add.
+type bulkSource struct {
+	name, text string
+}
+
+// BulkView is the data for the bulk-add summary page.
+type BulkView struct {
+	Results []BulkResult
+	Added   int
+	Failed  int
+}
+
+// bulkAddHandler adds every URL listed in the "urls" textarea and the
+// uploaded "file", one per line with an optional ",frequency" suffix, and
+// reports how each line went. The other settings on the form, including the
+// default frequency, apply to all of them.
+func bulkAddHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBulkUploadBytes)
+	if err := r.ParseMultipartForm(maxBulkUploadBytes); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		http.Error(w, "Invalid form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sources := []bulkSource{{"list", r.FormValue("urls")}}
+	if file, header, err := r.FormFile("file"); err == nil {
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			http.Error(w, "Error reading file", http.StatusBadRequest)
+			return
+		}
+		sources = append(sources, bulkSource{header.Filename, string(data)})
+	} else if !errors.Is(err, http.ErrMissingFile) && !errors.Is(err, http.ErrNotMultipart) {
+		http.Error(w, "Invalid file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var view BulkView
+	for _, src := range sources {
+		scanner := bufio.NewScanner(strings.NewReader(src.text))
+		for line := 1; scanner.Scan(); line++ {
+			rawURL, freq, ok := parseBulkLine(scanner.Text())
+			if !ok {
+				continue
+			}
+			res := BulkResult{Source: src.name, Line: line, URL: rawURL}
+			if err := bulkAddURL(r.Form, rawURL, freq, &res); err != nil {
+				res.Error = err.Error()
+				view.Failed++
+			} else {
+				view.Added++
+			}
+			view.Results = append(view.Results, res)
+		}
+	}
+	slog.Info("Bulk add finished", "event", "bulk_add", "added", view.Added, "failed", view.Failed)
+
+	if err := bulkTmpl.Execute(w, view); err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+	}
+}
+
+// parseBulkLine splits a bulk-add line into its URL and frequency, which is
+// empty if the line has none. Blank lines and # comments are skipped.
+//
+// URLs can't contain whitespace, so anything after whitespace, as in
+// "https://example.com/, 3600", is the frequency. Without whitespace a comma
+// may belong to the URL, as in "https://example.com/?ids=1,2", so what
+// follows the last one is only taken for a frequency if it is a number and
+// the rest is a URL without a query string. A line whose rest isn't a URL
+// is kept whole as the URL, to be reported as invalid.
+func parseBulkLine(line string) (rawURL, freq string, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false
+	}
+	if i := strings.LastIndexFunc(line, unicode.IsSpace); i >= 0 {
+		rawURL = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line[:i]), ","))
+		if validateMonitoredURL(rawURL) == nil {
+			return rawURL, line[i+1:], true
+		}
+		return line, "", true
+	}
+	if i := strings.LastIndex(line, ","); i >= 0 && isDigits(line[i+1:]) {
+		rawURL = line[:i]
+		if validateMonitoredURL(rawURL) == nil && !strings.Contains(rawURL, "?") {
+			return rawURL, line[i+1:], true
+		}
+	}
+	return line, "", true
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits.
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// bulkAddURL validates and adds one URL of a bulk add with the shared form
+// settings, recording its id in res.
+func bulkAddURL(form url.Values, rawURL, freq string, res *BulkResult) error {
+	lineForm := url.Values{}
+	for k, v := range form {
+		lineForm[k] = v
+	}
+	lineForm.Set("url", rawURL)
+	if freq != "" {
+		if _, err := strconv.Atoi(freq); err != nil {
+			return formError("Invalid frequency")
+		}
+		lineForm.Set("frequency", freq)
+	}
+
+	m, err := monitoredURLFromForm(lineForm)
+	if err != nil {
+		return err
+	}
+	if err := addMonitoredURL(&m); err != nil {
+		var dup *duplicateURLError
+		if errors.As(err, &dup) {
+			return formError(fmt.Sprintf("Already monitored (id %d)", dup.ID))
+		}
+		slog.Error("Error adding URL", "event", "bulk_add", "url", rawURL, "error", err)
+		return formError("Database error")
+	}
+	res.ID = m.ID
+	return nil
+}
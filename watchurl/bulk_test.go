@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestParseBulkLine(t *testing.T) {
+	tests := []struct {
+		line, url, freq string
+		ok              bool
+	}{
+		{"https://example.com/", "https://example.com/", "", true},
+		{"  https://example.com/  ", "https://example.com/", "", true},
+		{"https://example.com/,3600", "https://example.com/", "3600", true},
+		{"https://example.com/, 3600", "https://example.com/", "3600", true},
+		{"https://example.com/ , 3600", "https://example.com/", "3600", true},
+		{"https://example.com/ 3600", "https://example.com/", "3600", true},
+		{"https://example.com/\t3600", "https://example.com/", "3600", true},
+		// Commas within the URL are kept.
+		{"https://example.com/?ids=1,2", "https://example.com/?ids=1,2", "", true},
+		{"https://example.com/?ids=1,2,3600", "https://example.com/?ids=1,2,3600", "", true},
+		{"https://example.com/?ids=1,2, 3600", "https://example.com/?ids=1,2", "3600", true},
+		{"https://example.com/a,b", "https://example.com/a,b", "", true},
+		{"https://example.com/a,b,60", "https://example.com/a,b", "60", true},
+		// A frequency after whitespace is passed on to be validated.
+		{"https://example.com/, soon", "https://example.com/", "soon", true},
+		// Nothing that isn't a URL is split off.
+		{"example.com,3600", "example.com,3600", "", true},
+		{"not a url, 3600", "not a url, 3600", "", true},
+		{"", "", "", false},
+		{"   ", "", "", false},
+		{"# https://example.com/,3600", "", "", false},
+	}
+	for _, tt := range tests {
+		u, freq, ok := parseBulkLine(tt.line)
+		if u != tt.url || freq != tt.freq || ok != tt.ok {
+			t.Errorf("parseBulkLine(%q) = %q, %q, %v, want %q, %q, %v", tt.line, u, freq, ok, tt.url, tt.freq, tt.ok)
+		}
+	}
+}
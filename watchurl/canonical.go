@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// A canonicalizer reduces content of one format to a canonical form so that
+// differences in formatting alone do not register as changes. Canonical forms
+// are only used for comparison; snapshots keep the original content.
+type canonicalizer func(string) string
+
+// canonicalizers maps media types to the canonicalizer for that format.
+// Types ending in +json or +xml fall back to the JSON and XML entries.
+var canonicalizers = map[string]canonicalizer{
+	"application/json":      canonicalJSON,
+	"application/xml":       canonicalXML,
+	"text/xml":              canonicalXML,
+	"text/html":             canonicalHTML,
+	"application/xhtml+xml": canonicalHTML,
+	"text/css":              canonicalCSS,
+}
+
+// mediaType returns the lowercase media type of a Content-Type header value.
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	}
+	return mt
+}
+
+// canonicalizerFor returns the canonicalizer registered for contentType.
+func canonicalizerFor(contentType string) (canonicalizer, bool) {
+	mt := mediaType(contentType)
+	if c, ok := canonicalizers[mt]; ok {
+		return c, true
+	}
+	switch {
+	case strings.HasSuffix(mt, "+json"):
+		return canonicalizers["application/json"], true
+	case strings.HasSuffix(mt, "+xml"):
+		return canonicalizers["application/xml"], true
+	}
+	return nil, false
+}
+
+// isStructuredType reports whether contentType is a non-HTML format with a
+// registered canonicalizer.
+func isStructuredType(contentType string) bool {
+	if _, ok := canonicalizerFor(contentType); !ok {
+		return false
+	}
+	mt := mediaType(contentType)
+	return mt != "text/html" && mt != "application/xhtml+xml"
+}
+
+// isHTMLType reports whether contentType is HTML. A missing content type is
+// treated as HTML, as it always has been.
+func isHTMLType(contentType string) bool {
+	mt := mediaType(contentType)
+	return mt == "" || mt == "text/html" || mt == "application/xhtml+xml"
+}
+
+// textApplicationTypes are the application/* media types holding text.
+var textApplicationTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/ecmascript": true,
+	"application/x-yaml":     true,
+	"application/yaml":       true,
+	"application/toml":       true,
+	"application/x-sh":       true,
+	"application/rss+xml":    true,
+	"application/atom+xml":   true,
+}
+
+// isBinaryType reports whether contentType is clearly not text, such as an
+// image or a PDF.
+func isBinaryType(contentType string) bool {
+	mt := mediaType(contentType)
+	major, minor, _ := strings.Cut(mt, "/")
+	switch major {
+	case "image", "audio", "video", "font":
+		return mt != "image/svg+xml"
+	case "application":
+		return !textApplicationTypes[mt] && !strings.HasSuffix(minor, "+json") && !strings.HasSuffix(minor, "+xml")
+	}
+	return false
+}
+
+// contentEqual reports whether a and b are the same content once both have
+// been canonicalized for contentType. Content without a registered
+// canonicalizer is compared byte for byte.
+func contentEqual(contentType, a, b string) bool {
+	if a == b {
+		return true
+	}
+	c, ok := canonicalizerFor(contentType)
+	if !ok {
+		return false
+	}
+	return c(a) == c(b)
+}
+
+// urlContentEqual is contentEqual under the comparison settings of m.
+func urlContentEqual(m MonitoredURL, contentType, a, b string) bool {
+	if m.NormalizeWhitespace {
+		a, b = normalizeWhitespace(a), normalizeWhitespace(b)
+	}
+	return contentEqual(contentType, a, b)
+}
+
+// comparisonHash returns the SHA-256 of content in the form urlContentEqual
+// compares, so that two contents of contentType have equal hashes exactly
+// when urlContentEqual reports them equal under m's settings.
+func comparisonHash(m MonitoredURL, contentType, content string) string {
+	if m.NormalizeWhitespace {
+		content = normalizeWhitespace(content)
+	}
+	if c, ok := canonicalizerFor(contentType); ok {
+		content = c(content)
+	}
+	return contentHash(content)
+}
+
+// normalizeWhitespace collapses runs of whitespace within each line to a
+// single space, trims every line, and drops blank lines, so that content
+// differing only in indentation or line spacing compares equal.
+func normalizeWhitespace(s string) string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			lines = append(lines, strings.Join(fields, " "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// canonicalJSON re-encodes JSON with sorted object keys and no insignificant
+// whitespace. Invalid JSON is returned unchanged.
+func canonicalJSON(s string) string {
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return s
+	}
+	return string(b)
+}
+
+// canonicalXML serializes XML with resolved namespace names, attributes
+// sorted, namespace declarations and comments dropped, and whitespace-only
+// text removed. Invalid XML is returned unchanged.
+func canonicalXML(s string) string {
+	dec := xml.NewDecoder(strings.NewReader(s))
+	dec.Strict = false
+	var buf strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return s
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			buf.WriteString("<" + xmlName(t.Name))
+			var attrs []string
+			for _, a := range t.Attr {
+				if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+					continue
+				}
+				attrs = append(attrs, xmlName(a.Name)+"="+escapeXMLAttr(a.Value))
+			}
+			sort.Strings(attrs)
+			for _, a := range attrs {
+				buf.WriteString(" " + a)
+			}
+			buf.WriteString(">")
+		case xml.EndElement:
+			buf.WriteString("</" + xmlName(t.Name) + ">")
+		case xml.CharData:
+			if text := strings.TrimSpace(string(t)); text != "" {
+				xml.EscapeText(&buf, []byte(text))
+			}
+		}
+	}
+	return buf.String()
+}
+
+// xmlName formats a resolved XML name as {namespace}local.
+func xmlName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return "{" + n.Space + "}" + n.Local
+}
+
+func escapeXMLAttr(v string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(v))
+	return `"` + buf.String() + `"`
+}
+
+// canonicalHTML re-renders HTML with each element's attributes sorted by name,
+// so that markup differing only in attribute order compares equal.
+func canonicalHTML(s string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(s), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return s
+	}
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		sortAttributes(n)
+		if err := html.Render(&buf, n); err != nil {
+			return s
+		}
+	}
+	return buf.String()
+}
+
+// sortAttributes sorts the attributes of n and all of its descendants.
+func sortAttributes(n *html.Node) {
+	if n.Type == html.ElementNode {
+		sort.SliceStable(n.Attr, func(i, j int) bool {
+			if n.Attr[i].Namespace != n.Attr[j].Namespace {
+				return n.Attr[i].Namespace < n.Attr[j].Namespace
+			}
+			return n.Attr[i].Key < n.Attr[j].Key
+		})
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sortAttributes(c)
+	}
+}
+
+var (
+	cssCommentRe    = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssWhitespaceRe = regexp.MustCompile(`\s+`)
+	cssPunctRe      = regexp.MustCompile(`\s*([{}:;,>])\s*`)
+)
+
+// canonicalCSS strips comments, collapses whitespace, and sorts top-level
+// rules so that reformatting or reordering a stylesheet compares equal.
+func canonicalCSS(s string) string {
+	s = cssCommentRe.ReplaceAllString(s, "")
+	s = cssWhitespaceRe.ReplaceAllString(s, " ")
+	s = cssPunctRe.ReplaceAllString(s, "$1")
+
+	// Split into top-level rules, keeping nested blocks (e.g. @media) intact.
+	var rules []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+			}
+			if depth == 0 {
+				rules = append(rules, strings.TrimSpace(s[start:i+1]))
+				start = i + 1
+			}
+		case ';':
+			// Top-level statements such as @import end with a semicolon.
+			if depth == 0 {
+				rules = append(rules, strings.TrimSpace(s[start:i+1]))
+				start = i + 1
+			}
+		}
+	}
+	if rest := strings.TrimSpace(s[start:]); rest != "" {
+		rules = append(rules, rest)
+	}
+	sort.Strings(rules)
+	return strings.Join(rules, "\n")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// maxSummaryBytes bounds the size of the snapshots diffed to summarize a
+// URL's latest change on the index page. Larger changes are summarized as
+// just "changed", so that rendering the index stays fast.
+const maxSummaryBytes = 64 << 10
+
+// changeSummary is a cached summary of the change between two snapshots,
+// identified by their content hashes.
+type changeSummary struct {
+	olderHash, newerHash string
+	text                 string
+}
+
+var (
+	summaryMu sync.Mutex
+	// summaries holds the latest change summary of each URL, by URL id.
+	summaries = make(map[int]changeSummary)
+)
+
+// latestChangeSummary describes the change between the two most recent
+// primary snapshots of a URL, e.g. "+120 / -30 chars". It returns "" if the
+// URL has fewer than two snapshots. Summaries are cached until a newer
+// snapshot is taken.
+func latestChangeSummary(urlID int) string {
+	rows, err := db.Query("SELECT id, content_hash FROM url_snapshots WHERE url_id = ? AND region = '' ORDER BY timestamp DESC LIMIT 2", urlID)
+	if err != nil {
+		slog.Error("Error loading snapshots", "url_id", urlID, "error", err)
+		return ""
+	}
+	var ids []int
+	var hashes []string
+	for rows.Next() {
+		var id int
+		var hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+	if len(ids) < 2 {
+		return ""
+	}
+
+	summaryMu.Lock()
+	cached, ok := summaries[urlID]
+	summaryMu.Unlock()
+	if ok && cached.newerHash == hashes[0] && cached.olderHash == hashes[1] {
+		return cached.text
+	}
+
+	text := summarizeChange(ids[1], ids[0])
+	summaryMu.Lock()
+	summaries[urlID] = changeSummary{olderHash: hashes[1], newerHash: hashes[0], text: text}
+	summaryMu.Unlock()
+	return text
+}
+
+// summarizeChange diffs the snapshot olderID against newerID, unless either
+// is larger than maxSummaryBytes.
+func summarizeChange(olderID, newerID int) string {
+	older, err := loadSnapshotContent(olderID)
+	if err != nil {
+		slog.Error("Error reading snapshot", "snapshot_id", olderID, "error", err)
+		return "changed"
+	}
+	newer, err := loadSnapshotContent(newerID)
+	if err != nil {
+		slog.Error("Error reading snapshot", "snapshot_id", newerID, "error", err)
+		return "changed"
+	}
+	if len(older) > maxSummaryBytes || len(newer) > maxSummaryBytes {
+		return "changed"
+	}
+	stats := computeDiffStats(older, newer)
+	stats.Context = ""
+	return stats.String()
+}
+
+// forgetChangeSummary drops the cached summary of a deleted URL.
+func forgetChangeSummary(urlID int) {
+	summaryMu.Lock()
+	delete(summaries, urlID)
+	summaryMu.Unlock()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+)
+
+// Notification channels a URL can send to, as stored in
+// monitored_urls.channels.
+const (
+	channelPushover = "pushover"
+	channelEmail    = "email"
+	channelTelegram = "telegram"
+	channelWebhook  = "webhook"
+	channelDiscord  = "discord"
+	channelNtfy     = "ntfy"
+	channelSlack    = "slack"
+	channelMatrix   = "matrix"
+	channelDesktop  = "desktop"
+)
+
+// notificationChannels lists every channel in display order.
+var notificationChannels = []string{channelPushover, channelEmail, channelTelegram, channelWebhook, channelDiscord, channelNtfy, channelSlack, channelMatrix, channelDesktop}
+
+// parseChannels parses a comma-separated list of channels, dropping
+// unknown and repeated names. The result is in notificationChannels order.
+func parseChannels(s string) []string {
+	return filterChannels(strings.Split(s, ","))
+}
+
+// filterChannels returns the known channels among names, in
+// notificationChannels order.
+func filterChannels(names []string) []string {
+	var channels []string
+	for _, c := range notificationChannels {
+		for _, n := range names {
+			if strings.ToLower(strings.TrimSpace(n)) == c {
+				channels = append(channels, c)
+				break
+			}
+		}
+	}
+	return channels
+}
+
+// formatChannels joins channels in the form stored in
+// monitored_urls.channels.
+func formatChannels(channels []string) string {
+	return strings.Join(channels, ",")
+}
+
+// hasChannel reports whether channels contains c.
+func hasChannel(channels []string, c string) bool {
+	for _, ch := range channels {
+		if ch == c {
+			return true
+		}
+	}
+	return false
+}
+
+// enabledChannels returns the channels a URL's notifications go to. It is
+// read from the database on every notification so that edits take effect
+// without restarting the URL's monitor.
+func enabledChannels(urlID int) []string {
+	var channels string
+	err := db.QueryRow("SELECT channels FROM monitored_urls WHERE id = ?", urlID).Scan(&channels)
+	if err != nil {
+		slog.Error("Error reading notification channels; sending to all", "url_id", urlID, "error", err)
+		return notificationChannels // default to sending if in doubt
+	}
+	return parseChannels(channels)
+}
+
+// ChannelChoice is a notification channel and whether a URL uses it, for
+// rendering checkboxes.
+type ChannelChoice struct {
+	Name    string
+	Enabled bool
+}
+
+// channelChoices returns a ChannelChoice for every channel.
+func channelChoices(enabled []string) []ChannelChoice {
+	choices := make([]ChannelChoice, len(notificationChannels))
+	for i, c := range notificationChannels {
+		choices[i] = ChannelChoice{Name: c, Enabled: hasChannel(enabled, c)}
+	}
+	return choices
+}
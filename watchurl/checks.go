@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// maxCheckAge, if positive, is how long the record of each check is kept.
+var maxCheckAge = 30 * 24 * time.Hour
+
+// checkActivityLimit is how many of a URL's most recent checks the history
+// page summarizes.
+const checkActivityLimit = 1000
+
+// Outcomes of a check, as grouped on the history page.
+const (
+	checkChanged   = "changed"
+	checkUnchanged = "unchanged"
+	checkFailed    = "failed"
+)
+
+// CheckRun is a run of consecutive checks of a URL with the same outcome.
+type CheckRun struct {
+	Outcome string
+	Count   int
+	// First and Last are when the first and last checks of the run
+	// happened.
+	First, Last time.Time
+	// Error is that of the last check of a failed run.
+	Error string
+}
+
+// Summary describes the run in a line for the history page.
+func (r CheckRun) Summary() string {
+	when := r.Last.Local().Format(time.RFC1123)
+	if r.Count > 1 {
+		when = fmt.Sprintf("%s to %s", r.First.Local().Format(time.RFC1123), when)
+	}
+	switch r.Outcome {
+	case checkChanged:
+		return fmt.Sprintf("%s: %s", when, plural(r.Count, "change", "changes"))
+	case checkFailed:
+		return fmt.Sprintf("%s: %s, last with %s", when, plural(r.Count, "failed check", "failed checks"), r.Error)
+	default:
+		return fmt.Sprintf("%s: %s, no change", when, plural(r.Count, "check", "checks"))
+	}
+}
+
+// CheckActivity summarizes a URL's recent checks, which show that it was
+// being monitored even while it didn't change.
+type CheckActivity struct {
+	Checks, Changes, Failures int
+	// Since is when the first of the checks happened.
+	Since time.Time
+	// Runs groups the checks, newest first.
+	Runs []CheckRun
+}
+
+// Summary totals the checks in a line for the history page.
+func (a CheckActivity) Summary() string {
+	s := fmt.Sprintf("Checked %s since %s: %s", plural(a.Checks, "time", "times"), humanize.Time(a.Since), plural(a.Changes, "change", "changes"))
+	if a.Failures > 0 {
+		s += ", " + plural(a.Failures, "failure", "failures")
+	}
+	return s
+}
+
+// plural formats n followed by the singular or plural noun, as fits n.
+func plural(n int, singular, pluralNoun string) string {
+	if n == 1 {
+		return "1 " + singular
+	}
+	return fmt.Sprintf("%d %s", n, pluralNoun)
+}
+
+// loadCheckActivity reads and groups the most recent checks of urlID.
+func loadCheckActivity(urlID int) (CheckActivity, error) {
+	var a CheckActivity
+	rows, err := db.Query("SELECT timestamp, changed, error FROM url_checks WHERE url_id = ? ORDER BY id DESC LIMIT ?", urlID, checkActivityLimit)
+	if err != nil {
+		return a, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ts time.Time
+		var changed bool
+		var errText string
+		if err := rows.Scan(&ts, &changed, &errText); err != nil {
+			return a, err
+		}
+		outcome := checkUnchanged
+		switch {
+		case errText != "":
+			outcome = checkFailed
+			a.Failures++
+		case changed:
+			outcome = checkChanged
+			a.Changes++
+		}
+		a.Checks++
+		a.Since = ts
+		// Rows come newest first, so each extends its run backwards.
+		if n := len(a.Runs); n > 0 && a.Runs[n-1].Outcome == outcome {
+			a.Runs[n-1].Count++
+			a.Runs[n-1].First = ts
+			continue
+		}
+		a.Runs = append(a.Runs, CheckRun{Outcome: outcome, Count: 1, First: ts, Last: ts, Error: errText})
+	}
+	return a, rows.Err()
+}
+
+// pruneChecks deletes the record of checks older than maxCheckAge, returning
+// how many were deleted.
+func pruneChecks(now time.Time) (int64, error) {
+	if maxCheckAge <= 0 {
+		return 0, nil
+	}
+	res, err := writeDB.Exec("DELETE FROM url_checks WHERE timestamp < ?", dbTime(now.Add(-maxCheckAge)))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
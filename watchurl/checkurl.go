@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Exit statuses of -check-url, following diff(1).
+const (
+	checkURLUnchanged = 0
+	checkURLChanged   = 1
+	checkURLFailed    = 2
+)
+
+// checkURLOnce fetches rawURL, compares it with the latest snapshot stored
+// for it, and writes the differences to out as lines of "-" and "+"; all of
+// the content is new if there is no snapshot to compare with. A URL that is
+// monitored is fetched and extracted with its settings; any other with the
+// defaults. Nothing is stored. It reports whether the content changed.
+func checkURLOnce(ctx context.Context, rawURL string, out io.Writer) (bool, error) {
+	m, err := scanMonitoredURL(db.QueryRow("SELECT "+monitoredURLColumns+" FROM monitored_urls WHERE url = ? ORDER BY selector != '', id LIMIT 1", rawURL))
+	if err == sql.ErrNoRows {
+		m = MonitoredURL{URL: rawURL, Profile: defaultProfileName, ExtractMode: extractModeBody}
+	} else if err != nil {
+		return false, err
+	}
+
+	res, err := fetchContent(ctx, m)
+	if err != nil {
+		return false, err
+	}
+	if m.ID == 0 {
+		slog.Warn("URL is not monitored; nothing to compare with", "url", rawURL)
+		writeLineDiff(out, []diffmatchpatch.Diff{{Type: diffmatchpatch.DiffInsert, Text: res.Content}})
+		return true, nil
+	}
+
+	var last latestSnapshot
+	if err := last.refresh(m, res.ContentType); err != nil {
+		return false, fmt.Errorf("reading last snapshot: %w", err)
+	}
+	if last.id == 0 {
+		m.logger().Warn("URL has no snapshot yet; nothing to compare with")
+		writeLineDiff(out, []diffmatchpatch.Diff{{Type: diffmatchpatch.DiffInsert, Text: res.Content}})
+		return true, nil
+	}
+	if comparisonHash(m, res.ContentType, res.Content) == last.hash {
+		return false, nil
+	}
+	old, err := last.content()
+	if err != nil {
+		return false, fmt.Errorf("reading last snapshot: %w", err)
+	}
+	writeLineDiff(out, diffContents(diffModeLine, old, res.Content))
+	return true, nil
+}
+
+// writeLineDiff writes the deleted and inserted lines of a line diff to
+// out, prefixed with "-" and "+". Unchanged lines are left out.
+func writeLineDiff(out io.Writer, diffs []diffmatchpatch.Diff) {
+	for _, d := range diffs {
+		prefix := ""
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			prefix = "-"
+		case diffmatchpatch.DiffInsert:
+			prefix = "+"
+		default:
+			continue
+		}
+		for _, line := range splitLines(d.Text) {
+			fmt.Fprintln(out, prefix+strings.TrimSuffix(line, "\n"))
+		}
+	}
+}
+
+// runCheckURL runs -check-url and returns the process exit status.
+func runCheckURL(rawURL string, out io.Writer) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	changed, err := checkURLOnce(ctx, rawURL, out)
+	if err != nil {
+		slog.Error("Error checking URL", "url", rawURL, "error", err)
+		return checkURLFailed
+	}
+	if changed {
+		return checkURLChanged
+	}
+	return checkURLUnchanged
+}
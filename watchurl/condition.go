@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Condition states stored in monitored_urls.condition_state.
+const (
+	conditionUnknown = -1
+	conditionFailing = 0
+	conditionPassing = 1
+)
+
+// A Condition is a success predicate evaluated against a fetched response.
+// Conditions are written as one of:
+//
+//	regex:<pattern>          the response matches the regular expression
+//	json:<path>              the JSON field at path exists and is not false/null
+//	json:<path> == <value>   the JSON field equals value
+//	json:<path> != <value>   the JSON field does not equal value
+//
+// JSON paths are dotted field names with optional [n] array indexes, e.g.
+// "data.checks[0].status". Values are parsed as JSON literals when possible
+// and compared as strings otherwise.
+type Condition struct {
+	re    *regexp.Regexp
+	path  string
+	op    string
+	value interface{}
+}
+
+// parseCondition parses the textual form of a condition.
+func parseCondition(s string) (*Condition, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "regex:"):
+		re, err := regexp.Compile(strings.TrimPrefix(s, "regex:"))
+		if err != nil {
+			return nil, err
+		}
+		return &Condition{re: re}, nil
+	case strings.HasPrefix(s, "json:"):
+		expr := strings.TrimSpace(strings.TrimPrefix(s, "json:"))
+		c := &Condition{path: expr}
+		for _, op := range []string{"==", "!="} {
+			if path, value, ok := strings.Cut(expr, op); ok {
+				c.path = strings.TrimSpace(path)
+				c.op = op
+				c.value = parseConditionValue(strings.TrimSpace(value))
+				break
+			}
+		}
+		if c.path == "" {
+			return nil, errors.New("missing JSON path")
+		}
+		if _, err := splitJSONPath(c.path); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return nil, errors.New(`condition must start with "regex:" or "json:"`)
+}
+
+// parseConditionValue interprets a comparison operand as a JSON literal,
+// falling back to a bare string.
+func parseConditionValue(s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err == nil {
+		return v
+	}
+	return s
+}
+
+// Eval reports whether the condition holds for the response body.
+func (c *Condition) Eval(body string) (bool, error) {
+	if c.re != nil {
+		return c.re.MatchString(body), nil
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		return false, fmt.Errorf("response is not JSON: %w", err)
+	}
+	v, found := lookupJSONPath(doc, c.path)
+	switch c.op {
+	case "==":
+		return found && reflect.DeepEqual(v, c.value), nil
+	case "!=":
+		return !found || !reflect.DeepEqual(v, c.value), nil
+	default:
+		return found && v != nil && v != false, nil
+	}
+}
+
+// splitJSONPath splits a path like "a.b[2].c" into the keys "a", "b" and
+// "c" and the index 2.
+func splitJSONPath(path string) ([]interface{}, error) {
+	var parts []interface{}
+	for _, segment := range strings.Split(strings.TrimPrefix(path, "$."), ".") {
+		name := segment
+		var indexes []int
+		if i := strings.IndexByte(segment, '['); i >= 0 {
+			name = segment[:i]
+			rest := segment[i:]
+			for rest != "" {
+				end := strings.IndexByte(rest, ']')
+				if rest[0] != '[' || end < 0 {
+					return nil, fmt.Errorf("invalid JSON path segment %q", segment)
+				}
+				n, err := strconv.Atoi(rest[1:end])
+				if err != nil {
+					return nil, fmt.Errorf("invalid index in JSON path segment %q", segment)
+				}
+				indexes = append(indexes, n)
+				rest = rest[end+1:]
+			}
+		}
+		if name != "" {
+			parts = append(parts, name)
+		}
+		for _, n := range indexes {
+			parts = append(parts, n)
+		}
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("empty JSON path")
+	}
+	return parts, nil
+}
+
+// lookupJSONPath returns the value at path within a decoded JSON document.
+func lookupJSONPath(doc interface{}, path string) (interface{}, bool) {
+	parts, err := splitJSONPath(path)
+	if err != nil {
+		return nil, false
+	}
+	v := doc
+	for _, part := range parts {
+		switch p := part.(type) {
+		case string:
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = obj[p]; !ok {
+				return nil, false
+			}
+		case int:
+			arr, ok := v.([]interface{})
+			if !ok || p < 0 || p >= len(arr) {
+				return nil, false
+			}
+			v = arr[p]
+		}
+	}
+	return v, true
+}
+
+// checkCondition evaluates the URL's condition against a fetched body and
+// sends a notification when the result differs from the last recorded one.
+// The first evaluation only records the state.
+func checkCondition(m MonitoredURL, body string) {
+	cond, err := parseCondition(m.Condition)
+	if err != nil {
+		m.logger().Warn("Ignoring invalid condition", "event", "condition", "condition", m.Condition, "error", err)
+		return
+	}
+	passing, err := cond.Eval(body)
+	if err != nil {
+		m.logger().Warn("Error evaluating condition", "event", "condition", "error", err)
+	}
+	state := conditionFailing
+	if passing {
+		state = conditionPassing
+	}
+
+	var previous int
+	if err := db.QueryRow("SELECT condition_state FROM monitored_urls WHERE id = ?", m.ID).Scan(&previous); err != nil {
+		m.logger().Error("Error reading condition state", "error", err)
+		return
+	}
+	if previous == state {
+		return
+	}
+
+	_, err = writeDB.Exec("UPDATE monitored_urls SET condition_state = ? WHERE id = ?", state, m.ID)
+	if err != nil {
+		m.logger().Error("Error saving condition state", "error", err)
+	}
+
+	if previous == conditionUnknown {
+		return
+	}
+	status := "failing"
+	if passing {
+		status = "passing"
+	}
+	m.logger().Info("Condition changed", "event", "condition", "status", status)
+	message := fmt.Sprintf("Condition %q on %s is now %s (%s)", m.Condition, m.URL, status, time.Now().Format(time.RFC1123))
+	sendNotification(m.ID, enabledChannels(m.ID), loadPushoverOptions(m.ID), "URL Condition Changed", message, m.URL)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// validators are the ETag and Last-Modified headers of a response, sent back
+// as If-None-Match and If-Modified-Since so that the server can answer 304
+// Not Modified instead of resending an unchanged page.
+type validators struct {
+	ETag         string
+	LastModified string
+}
+
+func (v validators) empty() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+// usesConditionalRequests reports whether checks of m may be skipped on 304
+// Not Modified. A followed link or region comparisons need the page itself
+// each time, so those URLs are always fetched in full, as are form posts,
+// whose validators needn't describe the response to the next post.
+func usesConditionalRequests(m MonitoredURL) bool {
+	return m.FollowSelector == "" && len(m.Regions) == 0 && m.Method != http.MethodPost
+}
+
+// loadValidators returns the validators stored for urlID, provided they were
+// saved for the URL's latest snapshot; otherwise they are empty, and the
+// next fetch is unconditional.
+func loadValidators(urlID int) (validators, error) {
+	var v validators
+	err := db.QueryRow(`SELECT etag, last_modified FROM url_validators
+        WHERE url_id = ? AND snapshot_id = (
+            SELECT id FROM url_snapshots WHERE url_id = ? AND region = '' ORDER BY timestamp DESC LIMIT 1
+        )`, urlID, urlID).Scan(&v.ETag, &v.LastModified)
+	if err == sql.ErrNoRows {
+		return validators{}, nil
+	}
+	return v, err
+}
+
+// saveValidators stores the validators of a response whose content matches
+// snapshot snapshotID of urlID.
+func saveValidators(urlID int, snapshotID int64, v validators) {
+	var err error
+	if v.empty() {
+		err = forgetValidators(urlID)
+	} else {
+		_, err = writeDB.Exec("INSERT OR REPLACE INTO url_validators (url_id, snapshot_id, etag, last_modified) VALUES (?, ?, ?, ?)", urlID, snapshotID, v.ETag, v.LastModified)
+	}
+	if err != nil {
+		slog.Error("Error saving validators", "url_id", urlID, "error", err)
+	}
+}
+
+// forgetValidators drops the validators of urlID, so that its next fetch is
+// unconditional.
+func forgetValidators(urlID int) error {
+	_, err := writeDB.Exec("DELETE FROM url_validators WHERE url_id = ?", urlID)
+	return err
+}
+
+// fetchContentIfModified is fetchContent, sending v as conditional request
+// headers. If the server answers 304 Not Modified, the result has only
+// NotModified set.
+func fetchContentIfModified(ctx context.Context, m MonitoredURL, v validators) (FetchResult, error) {
+	if v.empty() {
+		return fetchContent(ctx, m)
+	}
+	headers := make(map[string]string, len(m.Headers)+2)
+	for name, value := range m.Headers {
+		headers[name] = value
+	}
+	if v.ETag != "" {
+		headers["If-None-Match"] = v.ETag
+	}
+	if v.LastModified != "" {
+		headers["If-Modified-Since"] = v.LastModified
+	}
+	m.Headers = headers
+	res, err := fetchContent(ctx, m)
+	var se *statusError
+	if errors.As(err, &se) && se.StatusCode == http.StatusNotModified {
+		return FetchResult{NotModified: true, StatusCode: se.StatusCode}, nil
+	}
+	return res, err
+}
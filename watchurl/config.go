@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configEnvPrefix starts the names of the environment variables that set
+// flags: WATCHURL_MAX_SNAPSHOT_AGE sets -max-snapshot-age, for example.
+const configEnvPrefix = "WATCHURL_"
+
+// flagEnvName returns the environment variable that sets the named flag.
+func flagEnvName(name string) string {
+	return configEnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyConfig sets the flags that weren't given on the command line from
+// the environment and then from the YAML file at path, if it isn't empty,
+// so that flags override environment variables, which override the file,
+// which overrides the built-in defaults. The file maps flag names to
+// values, e.g. "max-snapshot-age: 720h". It must be called after
+// flag.Parse, and returns an error naming the file, variable or setting at
+// fault.
+func applyConfig(path string) error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	given["config"] = true
+
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] {
+			return
+		}
+		env := flagEnvName(f.Name)
+		if value, ok := os.LookupEnv(env); ok {
+			if err = flag.Set(f.Name, value); err != nil {
+				err = fmt.Errorf("invalid %s %q: %v", env, value, err)
+			}
+			given[f.Name] = true
+		}
+	})
+	if err != nil || path == "" {
+		return err
+	}
+
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "config" || flag.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		if given[name] {
+			continue
+		}
+		if err := flag.Set(name, values[name]); err != nil {
+			return fmt.Errorf("%s: invalid %s %q: %v", path, name, values[name], err)
+		}
+	}
+	return nil
+}
+
+// readConfigFile reads the YAML config file at path into a map from
+// setting names to their values as they would be written on the command
+// line.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %v", err)
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	values := make(map[string]string, len(raw))
+	for name, v := range raw {
+		switch v := v.(type) {
+		case string, bool, int, int64, uint64, float64:
+			values[name] = fmt.Sprint(v)
+		case nil:
+			return nil, fmt.Errorf("%s: setting %q has no value", path, name)
+		default:
+			return nil, fmt.Errorf("%s: setting %q must be a single value", path, name)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// notifyCooldown is how long further change notifications for a URL are
+// held back after one is sent. Zero sends every change.
+var notifyCooldown time.Duration
+
+// cooldown tracks a URL's notification cooldown: until when notifications
+// are held back and how many changes were held back so far.
+type cooldown struct {
+	until      time.Time
+	suppressed int
+	timer      *time.Timer
+}
+
+var (
+	cooldownMu sync.Mutex
+	cooldowns  = make(map[int]*cooldown)
+)
+
+// notifyChange sends a change notification for snapshot snapshotID, unless
+// the URL is cooling down from an earlier one. Held-back changes are
+// counted and reported together in one notification when the cooldown ends.
+func notifyChange(m MonitoredURL, snapshotID int64) {
+	now := time.Now()
+	if notifyCooldown > 0 {
+		cooldownMu.Lock()
+		c := cooldowns[m.ID]
+		if c != nil && now.Before(c.until) {
+			c.suppressed++
+			if c.timer == nil {
+				c.timer = time.AfterFunc(c.until.Sub(now), func() { endCooldown(m.ID) })
+			}
+			cooldownMu.Unlock()
+			m.logger().Info("Holding back change notification", "event", "notify_cooldown", "until", c.until)
+			return
+		}
+		cooldowns[m.ID] = &cooldown{until: now.Add(notifyCooldown)}
+		cooldownMu.Unlock()
+	}
+	sendChangeNotification(enabledChannels(m.ID), m.URL, m.ID, now, snapshotID)
+}
+
+// endCooldown sends the summary of the changes held back during a URL's
+// cooldown, which starts a new cooldown.
+func endCooldown(urlID int) {
+	cooldownMu.Lock()
+	c := cooldowns[urlID]
+	if c == nil {
+		cooldownMu.Unlock()
+		return
+	}
+	n := c.suppressed
+	cooldowns[urlID] = &cooldown{until: time.Now().Add(notifyCooldown)}
+	cooldownMu.Unlock()
+
+	m, err := loadMonitoredURL(urlID)
+	if err != nil {
+		// The URL was deleted while cooling down.
+		forgetCooldown(urlID)
+		return
+	}
+	changes := "change"
+	if n != 1 {
+		changes = "changes"
+	}
+	message := fmt.Sprintf("%d more %s detected on %s in the last %v", n, changes, m.URL, notifyCooldown)
+	sendNotification(urlID, enabledChannels(urlID), loadPushoverOptions(urlID), notificationTitle, message, m.URL)
+}
+
+// forgetCooldown drops the cooldown of a deleted URL, discarding any
+// held-back changes.
+func forgetCooldown(urlID int) {
+	cooldownMu.Lock()
+	if c := cooldowns[urlID]; c != nil && c.timer != nil {
+		c.timer.Stop()
+	}
+	delete(cooldowns, urlID)
+	cooldownMu.Unlock()
+}
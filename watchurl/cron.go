@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// cronSchedule is a standard five-field cron expression (minute, hour, day
+// of month, month, day of week), evaluated in a fixed location.
+type cronSchedule struct {
+	sched cron.Schedule
+	loc   *time.Location
+}
+
+// parseCron parses a cron expression such as "0 9 * * 1-5" or "@daily", as
+// cron.ParseStandard does, to be evaluated in local time. Expressions that
+// never match are rejected.
+func parseCron(expr string) (*cronSchedule, error) {
+	return parseCronIn(expr, time.Local)
+}
+
+// parseCronIn is parseCron evaluating the expression in loc.
+func parseCronIn(expr string, loc *time.Location) (*cronSchedule, error) {
+	sched, err := cron.ParseStandard(strings.TrimSpace(expr))
+	if err != nil {
+		return nil, err
+	}
+	s := &cronSchedule{sched: sched, loc: loc}
+	if s.next(time.Now()).IsZero() {
+		return nil, errors.New("the schedule never matches")
+	}
+	return s, nil
+}
+
+// next returns the first time after t that s matches, or the zero time if
+// there is none within five years.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	return s.sched.Next(t.In(s.loc))
+}
+
+// cronSamples bounds how many firings interval looks at.
+const cronSamples = 1000
+
+// interval returns the shortest gap between consecutive times s fires in
+// the year after from, looking at no more than cronSamples of them, so that
+// a schedule firing every minute for an hour a week counts as firing every
+// minute. It stands in for the frequency of scheduled URLs, e.g. to bound
+// how long a check may take. It returns 0 if s fires at most once in five
+// years.
+func (s *cronSchedule) interval(from time.Time) time.Duration {
+	prev := s.next(from)
+	if prev.IsZero() {
+		return 0
+	}
+	end := prev.Add(366 * 24 * time.Hour)
+	var shortest time.Duration
+	for i := 0; i < cronSamples; i++ {
+		t := s.next(prev)
+		if t.IsZero() {
+			break
+		}
+		if d := t.Sub(prev); shortest == 0 || d < shortest {
+			shortest = d
+		}
+		if t.After(end) {
+			break
+		}
+		prev = t
+	}
+	return shortest
+}
+
+// cronSchedule returns the parsed Schedule of m, or nil if it has none.
+func (m MonitoredURL) cronSchedule() *cronSchedule {
+	if m.Schedule == "" {
+		return nil
+	}
+	sched, err := parseCron(m.Schedule)
+	if err != nil {
+		m.logger().Warn("Ignoring invalid schedule", "schedule", m.Schedule, "error", err)
+		return nil
+	}
+	return sched
+}
+
+// minFrequency and maxFrequency, set with -min-frequency and
+// -max-frequency, bound the interval between checks a URL can be given, so
+// that a slip such as a frequency of 1 can't hammer a site. Zero means no
+// bound.
+var (
+	minFrequency = 30 * time.Second
+	maxFrequency = 366 * 24 * time.Hour
+)
+
+// checkFrequency returns a formError if checks every freq would be more or
+// less frequent than minFrequency and maxFrequency allow.
+func checkFrequency(freq time.Duration) error {
+	if minFrequency > 0 && freq < minFrequency {
+		return formError(fmt.Sprintf("Frequency must be at least %d seconds (see -min-frequency)", minFrequency/time.Second))
+	}
+	if maxFrequency > 0 && freq > maxFrequency {
+		return formError(fmt.Sprintf("Frequency must be at most %d seconds (see -max-frequency)", maxFrequency/time.Second))
+	}
+	return nil
+}
+
+// parseFrequency parses the frequency given for a URL: either a number of
+// seconds or a cron expression, which is returned as the schedule along with
+// the shortest gap between its firings. Either must be allowed by
+// checkFrequency.
+func parseFrequency(s string) (freq time.Duration, schedule string, err error) {
+	s = strings.TrimSpace(s)
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if secs <= 0 || secs > int64(math.MaxInt64/time.Second) {
+			return 0, "", formError("Invalid frequency")
+		}
+		freq = time.Duration(secs) * time.Second
+		return freq, "", checkFrequency(freq)
+	}
+	sched, err := parseCron(s)
+	if err != nil {
+		return 0, "", formError("Invalid frequency: give seconds or a cron expression (" + err.Error() + ")")
+	}
+	freq = sched.interval(time.Now())
+	if freq == 0 {
+		return 0, "", formError("Invalid frequency: the schedule fires at most once")
+	}
+	return freq, s, checkFrequency(freq)
+}
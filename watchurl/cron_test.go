@@ -0,0 +1,173 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// cronTime parses a time given as "2006-01-02 15:04" in UTC.
+func cronTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	v, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time { return cronTime(t, s) }
+	// 2024-01-31 is a Wednesday.
+	tests := []struct {
+		expr, from, want string
+	}{
+		{"* * * * *", "2024-01-31 10:00", "2024-01-31 10:01"},
+		{"30 * * * *", "2024-01-31 10:30", "2024-01-31 11:30"},
+		// Ranges.
+		{"0 9 * * 1-5", "2024-01-31 09:00", "2024-02-01 09:00"},
+		{"0 9 * * 1-5", "2024-02-02 09:00", "2024-02-05 09:00"},
+		{"0 9-11 * * *", "2024-01-31 11:00", "2024-02-01 09:00"},
+		// Steps.
+		{"*/15 * * * *", "2024-01-31 10:07", "2024-01-31 10:15"},
+		{"*/15 * * * *", "2024-01-31 10:45", "2024-01-31 11:00"},
+		{"0 8-18/4 * * *", "2024-01-31 12:00", "2024-01-31 16:00"},
+		{"0 8-18/4 * * *", "2024-01-31 16:00", "2024-02-01 08:00"},
+		// Lists.
+		{"0,20,40 * * * *", "2024-01-31 10:20", "2024-01-31 10:40"},
+		{"0 6,18 * * *", "2024-01-31 07:00", "2024-01-31 18:00"},
+		// Names.
+		{"0 0 * jun mon", "2024-01-31 00:00", "2024-06-03 00:00"},
+		{"0 12 * * sat,sun", "2024-01-31 00:00", "2024-02-03 12:00"},
+		// With both day fields restricted, a day matching either matches:
+		// the 15th, or any Friday.
+		{"0 0 15 * 5", "2024-01-31 00:00", "2024-02-02 00:00"},
+		{"0 0 15 * 5", "2024-02-10 00:00", "2024-02-15 00:00"},
+		// With one restricted, only it counts.
+		{"0 0 15 * *", "2024-01-31 00:00", "2024-02-15 00:00"},
+		{"0 0 * * 5", "2024-01-31 00:00", "2024-02-02 00:00"},
+		// Month and year rollover.
+		{"0 0 1 * *", "2024-01-31 12:00", "2024-02-01 00:00"},
+		{"0 0 31 * *", "2024-01-31 12:00", "2024-03-31 00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		{"59 23 31 12 *", "2024-12-31 23:59", "2025-12-31 23:59"},
+		// Descriptors.
+		{"@hourly", "2024-01-31 10:15", "2024-01-31 11:00"},
+		{"@daily", "2024-01-31 10:15", "2024-02-01 00:00"},
+		{"@weekly", "2024-01-31 10:15", "2024-02-04 00:00"},
+		{"@monthly", "2024-01-31 10:15", "2024-02-01 00:00"},
+	}
+	for _, tt := range tests {
+		s, err := parseCronIn(tt.expr, time.UTC)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := s.next(at(tt.from)); !got.Equal(at(tt.want)) {
+			t.Errorf("%q after %s: got %s, want %s", tt.expr, tt.from, got.Format("2006-01-02 15:04 Mon"), tt.want)
+		}
+	}
+}
+
+func TestParseCronRejects(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"x * * * *",
+		// February 30th never comes.
+		"0 0 30 2 *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded", expr)
+		}
+	}
+}
+
+func TestCronNextInLocation(t *testing.T) {
+	// 9am in UTC-5 is 14:00 UTC, whichever zone the times are given in.
+	s, err := parseCronIn("0 9 * * *", time.FixedZone("UTC-5", -5*60*60))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.next(cronTime(t, "2024-01-31 12:00")), cronTime(t, "2024-01-31 14:00"); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got.UTC(), want)
+	}
+}
+
+func TestCronInterval(t *testing.T) {
+	tests := []struct {
+		expr, from string
+		want       time.Duration
+	}{
+		{"* * * * *", "2024-01-31 10:00", time.Minute},
+		{"@every 1s", "2024-01-31 10:00", time.Second},
+		{"@every 45s", "2024-01-31 10:00", 45 * time.Second},
+		{"@hourly", "2024-01-31 10:00", time.Hour},
+		{"0 9 * * *", "2024-01-31 10:00", 24 * time.Hour},
+		// The same whatever day it is asked on.
+		{"0 9 * * 1-5", "2024-02-02 10:00", 24 * time.Hour},
+		{"0 9 * * 1-5", "2024-02-05 10:00", 24 * time.Hour},
+		// Every minute of an hour a week, asked near the end of that hour
+		// (2024-01-29 is a Monday).
+		{"* 9 * * 1", "2024-01-29 09:58", time.Minute},
+		{"0 9,17 * * *", "2024-01-31 10:00", 8 * time.Hour},
+		{"@weekly", "2024-01-31 10:00", 7 * 24 * time.Hour},
+		// February is the shortest month.
+		{"0 0 1 * *", "2024-03-15 00:00", 28 * 24 * time.Hour},
+		{"0 0 1 1 *", "2024-01-31 00:00", 365 * 24 * time.Hour},
+		{"0 0 29 2 *", "2024-03-01 00:00", (3*365 + 366) * 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		s, err := parseCronIn(tt.expr, time.UTC)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := s.interval(cronTime(t, tt.from)); got != tt.want {
+			t.Errorf("%q from %s: interval %v, want %v", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestParseFrequency(t *testing.T) {
+	tests := []struct {
+		in       string
+		freq     time.Duration
+		schedule string
+		ok       bool
+	}{
+		{"3600", time.Hour, "", true},
+		{" 30 ", 30 * time.Second, "", true},
+		{"10", 0, "", false},
+		{"0", 0, "", false},
+		{"-5", 0, "", false},
+		{"99999999999999999999", 0, "", false},
+		{"@hourly", time.Hour, "@hourly", true},
+		{"*/5 * * * *", 5 * time.Minute, "*/5 * * * *", true},
+		{"* 9 * * 1", time.Minute, "* 9 * * 1", true},
+		{"@every 45s", 45 * time.Second, "@every 45s", true},
+		// Schedules are held to -min-frequency too.
+		{"@every 1s", 0, "", false},
+		{"@every 29s", 0, "", false},
+		// And to -max-frequency.
+		{"0 0 29 2 *", 0, "", false},
+		{"soon", 0, "", false},
+	}
+	for _, tt := range tests {
+		freq, schedule, err := parseFrequency(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseFrequency(%q) error = %v, want ok = %v", tt.in, err, tt.ok)
+			continue
+		}
+		if tt.ok && (freq != tt.freq || schedule != tt.schedule) {
+			t.Errorf("parseFrequency(%q) = %v, %q, want %v, %q", tt.in, freq, schedule, tt.freq, tt.schedule)
+		}
+	}
+}
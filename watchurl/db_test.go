@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// errorRecorder is a slog handler that keeps the messages of error records,
+// for code that logs its database errors rather than returning them.
+type errorRecorder struct {
+	mu     sync.Mutex
+	errors []string
+}
+
+func (h *errorRecorder) Enabled(_ context.Context, l slog.Level) bool { return l >= slog.LevelError }
+
+func (h *errorRecorder) Handle(_ context.Context, r slog.Record) error {
+	msg := r.Message
+	r.Attrs(func(a slog.Attr) bool {
+		msg += " " + a.String()
+		return true
+	})
+	h.mu.Lock()
+	h.errors = append(h.errors, msg)
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *errorRecorder) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *errorRecorder) WithGroup(string) slog.Handler      { return h }
+
+// recordErrors captures error records logged for the rest of the test.
+func recordErrors(t *testing.T) *errorRecorder {
+	h := &errorRecorder{}
+	old := slog.Default()
+	slog.SetDefault(slog.New(h))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return h
+}
+
+// TestConcurrentWritesDontLock has many monitors write at once, as at boot.
+// Run with -race, which slows writers enough for SQLite's busy handler to
+// give up on some of them unless the writes are queued in the process.
+func TestConcurrentWritesDontLock(t *testing.T) {
+	openTestDB(t)
+	logged := recordErrors(t)
+
+	const writers = 50
+	var urls []MonitoredURL
+	for i := 0; i < writers; i++ {
+		urls = append(urls, addTestURL(t, MonitoredURL{URL: fmt.Sprintf("https://example.com/%d", i), Paused: true}))
+	}
+	large := strings.Repeat("<p>large page</p>", 20000)
+
+	errs := make(chan error, writers*20)
+	var wg sync.WaitGroup
+	for i, m := range urls {
+		wg.Add(1)
+		go func(i int, m MonitoredURL) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				updateLastCheck(m.ID)
+				recordCheck(m.ID, j%2 == 0, 200, nil)
+				content := fmt.Sprintf("<p>%d.%d</p>", i, j)
+				if i%10 == 0 {
+					content += large
+				}
+				if _, err := saveSnapshot(NewSnapshot{URLID: m.ID, Content: content, ContentType: "text/html"}); err != nil {
+					errs <- err
+				}
+			}
+			extra := MonitoredURL{URL: fmt.Sprintf("https://example.org/%d", i), Paused: true, Frequency: time.Hour}
+			if err := insertMonitoredURL(writeDB, &extra); err != nil {
+				errs <- err
+			}
+		}(i, m)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("write failed: %v", err)
+	}
+	for _, msg := range logged.errors {
+		t.Errorf("logged: %s", msg)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM url_snapshots").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != writers*10 {
+		t.Errorf("%d snapshots saved, want %d", n, writers*10)
+	}
+}
+
+func TestDatabaseDSNSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "monitor.db")
+	dsn, err := databaseDSN(path, 1500*time.Millisecond, "WAL", "NORMAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for pragma, want := range map[string]string{
+		"busy_timeout": "1500",
+		"journal_mode": "wal",
+		// NORMAL
+		"synchronous": "1",
+	} {
+		var got string
+		if err := conn.QueryRow("PRAGMA " + pragma).Scan(&got); err != nil {
+			t.Fatalf("PRAGMA %s: %v", pragma, err)
+		}
+		if strings.ToLower(got) != want {
+			t.Errorf("PRAGMA %s = %s, want %s", pragma, got, want)
+		}
+	}
+
+	for _, bad := range [][2]string{{"BOGUS", "NORMAL"}, {"WAL", "SOMETIMES"}} {
+		if _, err := databaseDSN(path, time.Second, bad[0], bad[1]); err == nil {
+			t.Errorf("databaseDSN accepted journal mode %q, synchronous %q", bad[0], bad[1])
+		}
+	}
+	if _, err := databaseDSN(path, -time.Second, "WAL", "NORMAL"); err == nil {
+		t.Error("databaseDSN accepted a negative busy timeout")
+	}
+}
+
+// TestBusyTimeoutAcrossConnections has two separate single-connection
+// pools, as two processes queueing their writes as watchurl does would,
+// write to the same file at once. Without the busy timeout one of them
+// fails with SQLITE_BUSY.
+func TestBusyTimeoutAcrossConnections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "monitor.db")
+	dsn, err := databaseDSN(path, 5*time.Second, "WAL", "NORMAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pools []*sql.DB
+	for i := 0; i < 2; i++ {
+		p, err := sql.Open("sqlite", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p.Close()
+		p.SetMaxOpenConns(1)
+		pools = append(pools, p)
+	}
+	if _, err := pools[0].Exec("CREATE TABLE t (n INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 400)
+	for _, p := range pools {
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(p *sql.DB) {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					tx, err := p.Begin()
+					if err != nil {
+						errs <- err
+						return
+					}
+					if _, err := tx.Exec("INSERT INTO t (n) VALUES (?)", i); err != nil {
+						tx.Rollback()
+						errs <- err
+						return
+					}
+					// Hold the write lock long enough for the other
+					// pool to run into it.
+					time.Sleep(time.Millisecond)
+					if err := tx.Commit(); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(p)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("write failed: %v", err)
+	}
+	var n int
+	if err := pools[1].QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2*4*50 {
+		t.Errorf("%d rows written, want %d", n, 2*4*50)
+	}
+}
+
+// TestWritesWaitForAnotherProcess holds the write lock from a separate
+// connection, as the sqlite3 shell would, while watchurl writes.
+func TestWritesWaitForAnotherProcess(t *testing.T) {
+	openTestDB(t)
+	logged := recordErrors(t)
+	m := addTestURL(t, MonitoredURL{URL: "https://example.com/", Paused: true})
+	if n := writeDB.Stats().MaxOpenConnections; n != 1 {
+		t.Errorf("writes use up to %d connections, want 1", n)
+	}
+
+	var seq int
+	var name, path string
+	if err := db.QueryRow("PRAGMA database_list").Scan(&seq, &name, &path); err != nil {
+		t.Fatal(err)
+	}
+	dsn, err := databaseDSN(path, 5*time.Second, "WAL", "NORMAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	tx, err := other.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("UPDATE monitored_urls SET last_error = 'held' WHERE id = ?", m.ID); err != nil {
+		t.Fatal(err)
+	}
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		tx.Commit()
+		close(released)
+	}()
+
+	start := time.Now()
+	recordCheck(m.ID, false, 200, nil)
+	if waited := time.Since(start); waited < 100*time.Millisecond {
+		t.Errorf("recordCheck returned after %v, before the other connection released its lock", waited)
+	}
+	<-released
+	for _, msg := range logged.errors {
+		t.Errorf("logged: %s", msg)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM url_checks WHERE url_id = ?", m.ID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("%d checks recorded, want 1", n)
+	}
+}
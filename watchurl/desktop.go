@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// desktopNotify, set with -desktop-notify, turns on the desktop channel,
+// which shows notifications on the machine watchurl runs on.
+//
+// The channel is meant for Linux desktops: it shells out to notify-send
+// rather than talking to a notification service itself, so it only works
+// where libnotify is installed and a notification daemon is running. The
+// BSDs work the same way and macOS falls back to osascript, but Windows is
+// not supported.
+var desktopNotify bool
+
+// desktopNotifyTimeout bounds how long the notifier command may take, so
+// that a hanging notification daemon can't hold up a check.
+const desktopNotifyTimeout = 10 * time.Second
+
+// sendDesktopNotification shows a desktop notification with the given
+// title and body using the platform's notifier: notify-send (libnotify) on
+// Linux and the BSDs, and osascript on macOS. On a headless machine the
+// notifier fails and the error is returned like any other channel's.
+func sendDesktopNotification(title, message string) error {
+	if !desktopNotify {
+		return errors.New("desktop notifications are off; start with -desktop-notify to show them")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), desktopNotifyTimeout)
+	defer cancel()
+	cmd, err := desktopNotifyCommand(ctx, title, message)
+	if err != nil {
+		return err
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// desktopNotifyCommand returns the command that shows a notification on
+// this platform.
+func desktopNotifyCommand(ctx context.Context, title, message string) (*exec.Cmd, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		script := "display notification " + appleScriptString(message) + " with title " + appleScriptString(title)
+		return exec.CommandContext(ctx, "osascript", "-e", script), nil
+	case "windows":
+		return nil, errors.New("desktop notifications are not supported on Windows")
+	}
+	path, err := exec.LookPath("notify-send")
+	if err != nil {
+		return nil, errors.New("notify-send not found; install libnotify")
+	}
+	return exec.CommandContext(ctx, path, "--app-name=watchurl", "--", title, message), nil
+}
+
+// appleScriptString quotes s as an AppleScript string literal.
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSendDesktopNotificationNeedsFlag(t *testing.T) {
+	err := sendDesktopNotification("title", "message")
+	if err == nil || !strings.Contains(err.Error(), "-desktop-notify") {
+		t.Errorf("got %v, want an error naming -desktop-notify", err)
+	}
+}
+
+func TestDesktopNotifyCommand(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("notify-send is used on Linux")
+	}
+	_, lookErr := exec.LookPath("notify-send")
+	cmd, err := desktopNotifyCommand(context.Background(), "-title", "message")
+	if lookErr != nil {
+		if err == nil || !strings.Contains(err.Error(), "libnotify") {
+			t.Errorf("without notify-send got %v, want an error saying to install libnotify", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The title is kept from being taken for an option.
+	want := []string{"notify-send", "--app-name=watchurl", "--", "-title", "message"}
+	got := append([]string{filepath.Base(cmd.Args[0])}, cmd.Args[1:]...)
+	if strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+		t.Errorf("command is %q, want %q", got, want)
+	}
+}
+
+func TestAppleScriptString(t *testing.T) {
+	for in, want := range map[string]string{
+		`plain`:          `"plain"`,
+		`say "hi"`:       `"say \"hi\""`,
+		`back\slash`:     `"back\\slash"`,
+		`\" end script"`: `"\\\" end script\""`,
+	} {
+		if got := appleScriptString(in); got != want {
+			t.Errorf("appleScriptString(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Diff modes accepted by diffHandler's mode parameter.
+const (
+	diffModeChar = "char"
+	diffModeWord = "word"
+	diffModeLine = "line"
+)
+
+// diffModes lists the diff modes in the order they are offered.
+var diffModes = []string{diffModeChar, diffModeWord, diffModeLine}
+
+// isDiffMode reports whether mode is one of diffModes.
+func isDiffMode(mode string) bool {
+	for _, m := range diffModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// diffViewSplit is the diffHandler view parameter selecting the
+// side-by-side rendering; any other value gives the inline one.
+const diffViewSplit = "split"
+
+// DiffView is the data of the diff page. Path and Params address the page
+// being shown, apart from the mode, view and source parameters its links
+// and form choose.
+type DiffView struct {
+	Heading string
+	Path    string
+	Params  map[string]string
+	Mode    string
+	Modes   []string
+	Split   bool
+	Raw     bool
+	// Live is set for a diff against a fetch just made, which has no raw
+	// page to compare with.
+	Live     bool
+	DiffHTML template.HTML
+}
+
+// Link returns the address of the page with the given mode, view and source.
+func (v DiffView) Link(mode string, split, raw bool) string {
+	q := url.Values{}
+	for name, value := range v.Params {
+		q.Set(name, value)
+	}
+	q.Set("mode", mode)
+	if split {
+		q.Set("view", diffViewSplit)
+	}
+	if raw {
+		q.Set("source", "raw")
+	}
+	return v.Path + "?" + q.Encode()
+}
+
+// renderDiff renders diffs inline, or side by side if split is set.
+func renderDiff(diffs []diffmatchpatch.Diff, split bool) string {
+	if split {
+		return diffSplitHTML(diffs)
+	}
+	return diffmatchpatch.New().DiffPrettyHtml(diffs)
+}
+
+// diffSplitHTML renders diffs as a two-column table: the old content with
+// deletions marked on the left, and the new content with insertions marked
+// on the right. Text is escaped the way DiffPrettyHtml escapes it.
+func diffSplitHTML(diffs []diffmatchpatch.Diff) string {
+	var left, right strings.Builder
+	for _, d := range diffs {
+		if d.Text == "" {
+			continue
+		}
+		text := strings.ReplaceAll(html.EscapeString(d.Text), "\n", "&para;<br>")
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			left.WriteString("<del>" + text + "</del>")
+		case diffmatchpatch.DiffInsert:
+			right.WriteString("<ins>" + text + "</ins>")
+		default:
+			left.WriteString("<span>" + text + "</span>")
+			right.WriteString("<span>" + text + "</span>")
+		}
+	}
+	return `<table class="split"><tr><td>` + left.String() + `</td><td>` + right.String() + `</td></tr></table>`
+}
+
+var wordRe = regexp.MustCompile(`\s+|\S+`)
+
+// diffContents diffs old against new at the granularity given by mode.
+func diffContents(mode, old, new string) []diffmatchpatch.Diff {
+	dmp := diffmatchpatch.New()
+	var diffs []diffmatchpatch.Diff
+	switch mode {
+	case diffModeWord:
+		diffs = diffTokens(dmp, old, new, func(s string) []string { return wordRe.FindAllString(s, -1) })
+	case diffModeLine:
+		diffs = diffTokens(dmp, old, new, splitLines)
+	default:
+		diffs = dmp.DiffMain(old, new, true)
+	}
+	dmp.DiffCleanupSemantic(diffs)
+	return diffs
+}
+
+// splitLines splits s into lines, each keeping its trailing newline.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffTokens diffs old against new treating each token produced by split as
+// an indivisible unit. This is the DiffLinesToChars technique generalized
+// to any tokenization: every distinct token is encoded as one rune, the rune
+// strings are diffed, and the result is decoded back into tokens.
+func diffTokens(dmp *diffmatchpatch.DiffMatchPatch, old, new string, split func(string) []string) []diffmatchpatch.Diff {
+	var tokens []string
+	index := map[string]rune{}
+	encode := func(s string) []rune {
+		var out []rune
+		for _, t := range split(s) {
+			r, ok := index[t]
+			if !ok {
+				r = tokenRune(len(tokens))
+				tokens = append(tokens, t)
+				index[t] = r
+			}
+			out = append(out, r)
+		}
+		return out
+	}
+	a, b := encode(old), encode(new)
+
+	diffs := dmp.DiffMainRunes(a, b, false)
+	for i := range diffs {
+		var text strings.Builder
+		for _, r := range diffs[i].Text {
+			text.WriteString(tokens[runeToken(r)])
+		}
+		diffs[i].Text = text.String()
+	}
+	return diffs
+}
+
+// surrogateMin and surrogateCount describe the UTF-16 surrogate range,
+// which holds no valid runes and so is skipped when encoding tokens.
+const (
+	surrogateMin   = 0xD800
+	surrogateCount = 0x800
+)
+
+// tokenRune maps a token index to a valid rune.
+func tokenRune(i int) rune {
+	r := rune(i)
+	if r >= surrogateMin {
+		r += surrogateCount
+	}
+	return r
+}
+
+// runeToken is the inverse of tokenRune.
+func runeToken(r rune) int {
+	if r >= surrogateMin+surrogateCount {
+		r -= surrogateCount
+	}
+	return int(r)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withDiffSlots sets the diff limit to n for the rest of the test.
+func withDiffSlots(t *testing.T, n int) {
+	old := diffSlots
+	diffSlots = make(chan struct{}, n)
+	t.Cleanup(func() { diffSlots = old })
+}
+
+func TestDiffSlotLimitIsEnforced(t *testing.T) {
+	const limit = 3
+	withDiffSlots(t, limit)
+	var running, peak int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !acquireDiffSlot(context.Background()) {
+				t.Error("no diff slot within diffQueueTimeout")
+				return
+			}
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			releaseDiffSlot()
+		}()
+	}
+	wg.Wait()
+	if peak > limit {
+		t.Errorf("%d diffs ran at once, want at most %d", peak, limit)
+	}
+}
+
+func TestDiffHandlerReturns503WhenSaturated(t *testing.T) {
+	openTestDB(t)
+	withDiffSlots(t, 1)
+	m := addTestURL(t, MonitoredURL{URL: "https://example.com/", Paused: true})
+	var ids []int
+	for _, content := range []string{"<p>one</p>", "<p>two</p>"} {
+		id, err := saveSnapshot(NewSnapshot{URLID: m.ID, Content: content, ContentType: "text/html"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, int(id))
+	}
+	target := "/diff?id1=" + strconv.Itoa(ids[0]) + "&id2=" + strconv.Itoa(ids[1])
+
+	// Take the only slot, as a long diff in progress would.
+	if !acquireDiffSlot(context.Background()) {
+		t.Fatal("couldn't take the diff slot")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	w := httptest.NewRecorder()
+	diffHandler(w, httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("with no free slot: got %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header on the 503")
+	}
+
+	releaseDiffSlot()
+	w = httptest.NewRecorder()
+	diffHandler(w, httptest.NewRequest(http.MethodGet, target, nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("with the slot free: got %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestPrecomputeDiff(t *testing.T) {
+	openTestDB(t)
+	m := addTestURL(t, MonitoredURL{URL: "https://example.com/", Paused: true})
+	var ids []int
+	for _, content := range []string{"<p>one</p>", "<p>two</p>", "<p>three</p>"} {
+		id, err := saveSnapshot(NewSnapshot{URLID: m.ID, Content: content, ContentType: "text/html"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, int(id))
+	}
+	cachedDiffs := func() int {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM snapshot_diffs").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	precomputeDiff(context.Background(), ids[0], ids[1])
+	if n := cachedDiffs(); n != 1 {
+		t.Fatalf("%d diffs cached, want 1", n)
+	}
+
+	// A stopped monitor's diff is dropped rather than stored, which could
+	// be after the database is closed.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	precomputeDiff(ctx, ids[1], ids[2])
+	if n := cachedDiffs(); n != 1 {
+		t.Errorf("%d diffs cached after a cancelled precompute, want 1", n)
+	}
+}
+
+// largeSnapshotPair saves two versions of a large page, differing in a few
+// places, and returns their ids.
+func largeSnapshotPair(t testing.TB, m MonitoredURL) (int, int) {
+	t.Helper()
+	var b strings.Builder
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&b, "<p>Item %d: some text that stays the same between versions</p>\n", i)
+	}
+	older := b.String()
+	newer := strings.NewReplacer("Item 100:", "Item one hundred:", "Item 2500:", "Item 2500 (updated):", "Item 4999:", "Last item:").Replace(older)
+	var ids []int
+	for _, content := range []string{older, newer} {
+		id, err := saveSnapshot(NewSnapshot{URLID: m.ID, Content: content, ContentType: "text/html"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, int(id))
+	}
+	return ids[0], ids[1]
+}
+
+func getDiff(t testing.TB, id1, id2 int) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	diffHandler(w, httptest.NewRequest(http.MethodGet, "/diff?id1="+itoa(id1)+"&id2="+itoa(id2), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /diff: got %d %q", w.Code, w.Body.String())
+	}
+	return w.Body.String()
+}
+
+func TestDiffHandlerUsesCache(t *testing.T) {
+	openTestDB(t)
+	m := addTestURL(t, MonitoredURL{URL: "https://example.com/", Paused: true})
+	id1, id2 := largeSnapshotPair(t, m)
+	cachedFor := func(id int) int {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM snapshot_diffs WHERE id1 = ? OR id2 = ?", id, id).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	first := getDiff(t, id1, id2)
+	if !strings.Contains(first, "one hundred") {
+		t.Fatal("diff doesn't show the change")
+	}
+	if n := cachedFor(id2); n != 1 {
+		t.Fatalf("%d diffs cached after the first request, want 1", n)
+	}
+
+	// Mark the cached rendering to tell it apart from a fresh one.
+	if _, err := writeDB.Exec("UPDATE snapshot_diffs SET html = '<p>from the cache</p>', last_used = ? WHERE id1 = ? AND id2 = ?", dbTime(time.Now().Add(-time.Hour)), id1, id2); err != nil {
+		t.Fatal(err)
+	}
+	if second := getDiff(t, id1, id2); !strings.Contains(second, "from the cache") {
+		t.Error("second request wasn't served from snapshot_diffs")
+	}
+	var lastUsed time.Time
+	if err := db.QueryRow("SELECT last_used FROM snapshot_diffs WHERE id1 = ? AND id2 = ?", id1, id2).Scan(&lastUsed); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(lastUsed) > time.Minute {
+		t.Errorf("serving the cached diff left last_used at %v", lastUsed)
+	}
+
+	// Deleting either snapshot drops the diffs involving it.
+	if err := deleteSnapshot(id2); err != nil {
+		t.Fatal(err)
+	}
+	if n := cachedFor(id2); n != 0 {
+		t.Errorf("%d cached diffs left for a deleted snapshot", n)
+	}
+	id3, id4 := largeSnapshotPair(t, m)
+	getDiff(t, id3, id4)
+	if err := deleteMonitoredURL(m.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n := cachedFor(id4); n != 0 {
+		t.Errorf("%d cached diffs left for a deleted URL", n)
+	}
+}
+
+// BenchmarkDiffHandler compares serving /diff of a large snapshot pair from
+// scratch with serving it from the cache.
+func BenchmarkDiffHandler(b *testing.B) {
+	openTestDB(b)
+	id1, id2 := largeSnapshotPair(b, addTestURL(b, MonitoredURL{URL: "https://example.com/", Paused: true}))
+	run := func(b *testing.B, cacheBytes int64) {
+		old := diffCacheBytes
+		diffCacheBytes = cacheBytes
+		b.Cleanup(func() { diffCacheBytes = old })
+		getDiff(b, id1, id2)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			getDiff(b, id1, id2)
+		}
+	}
+	b.Run("uncached", func(b *testing.B) { run(b, 0) })
+	b.Run("cached", func(b *testing.B) { run(b, diffCacheBytes) })
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// diffCacheBytes bounds the total size of rendered diffs kept in the
+// snapshot_diffs table. Zero disables the cache.
+var diffCacheBytes int64 = 64 << 20
+
+// cachedDiff returns the rendered diff for (id1, id2, mode) if it is cached.
+func cachedDiff(id1, id2 int, mode string) (string, bool) {
+	if diffCacheBytes <= 0 {
+		return "", false
+	}
+	var out string
+	err := db.QueryRow("SELECT html FROM snapshot_diffs WHERE id1 = ? AND id2 = ? AND mode = ?", id1, id2, mode).Scan(&out)
+	if err != nil {
+		return "", false
+	}
+	_, err = writeDB.Exec("UPDATE snapshot_diffs SET last_used = ? WHERE id1 = ? AND id2 = ? AND mode = ?", dbTime(time.Now()), id1, id2, mode)
+	if err != nil {
+		slog.Error("Error touching cached diff", "id1", id1, "id2", id2, "error", err)
+	}
+	return out, true
+}
+
+// storeDiff caches a rendered diff, then evicts the least recently used
+// entries until the cache fits within diffCacheBytes.
+func storeDiff(id1, id2 int, mode, out string) {
+	if diffCacheBytes <= 0 || int64(len(out)) > diffCacheBytes {
+		return
+	}
+	tx, err := writeDB.Begin()
+	if err != nil {
+		slog.Error("Error caching diff", "id1", id1, "id2", id2, "error", err)
+		return
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("INSERT OR REPLACE INTO snapshot_diffs (id1, id2, mode, html, size, last_used) VALUES (?, ?, ?, ?, ?, ?)",
+		id1, id2, mode, out, len(out), dbTime(time.Now()))
+	if err != nil {
+		slog.Error("Error caching diff", "id1", id1, "id2", id2, "error", err)
+		return
+	}
+
+	var total int64
+	if err := tx.QueryRow("SELECT COALESCE(SUM(size), 0) FROM snapshot_diffs").Scan(&total); err != nil {
+		slog.Error("Error sizing diff cache", "error", err)
+		return
+	}
+	for total > diffCacheBytes {
+		var evictID1, evictID2 int
+		var evictMode string
+		var size int64
+		err := tx.QueryRow("SELECT id1, id2, mode, size FROM snapshot_diffs ORDER BY last_used LIMIT 1").Scan(&evictID1, &evictID2, &evictMode, &size)
+		if err != nil {
+			slog.Error("Error evicting from diff cache", "error", err)
+			return
+		}
+		if _, err := tx.Exec("DELETE FROM snapshot_diffs WHERE id1 = ? AND id2 = ? AND mode = ?", evictID1, evictID2, evictMode); err != nil {
+			slog.Error("Error evicting from diff cache", "error", err)
+			return
+		}
+		total -= size
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error caching diff", "id1", id1, "id2", id2, "error", err)
+	}
+}
+
+// invalidateURLDiffs drops cached diffs involving any snapshot of a URL. It
+// must be called before the snapshots themselves are deleted, in the same
+// transaction.
+func invalidateURLDiffs(tx *sql.Tx, urlID int) error {
+	_, err := tx.Exec(`DELETE FROM snapshot_diffs
+        WHERE id1 IN (SELECT id FROM url_snapshots WHERE url_id = ?)
+           OR id2 IN (SELECT id FROM url_snapshots WHERE url_id = ?)`, urlID, urlID)
+	return err
+}
+
+// precomputeDiff renders and caches the default diff of snapshots id1 and
+// id2, the one the history links a new snapshot to, so that it is ready by
+// the time someone follows a change notification to it. It does nothing if
+// the diff is already cached or no diff slot frees up in time, and gives up
+// without storing anything once ctx is done, as it is when the URL's
+// monitor stops.
+func precomputeDiff(ctx context.Context, id1, id2 int) {
+	if diffCacheBytes <= 0 {
+		return
+	}
+	var cached int
+	err := db.QueryRow("SELECT 1 FROM snapshot_diffs WHERE id1 = ? AND id2 = ? AND mode = ?", id1, id2, diffModeChar).Scan(&cached)
+	if err == nil {
+		return
+	} else if err != sql.ErrNoRows {
+		slog.Error("Error checking the diff cache", "id1", id1, "id2", id2, "error", err)
+		return
+	}
+	content1, err := loadSnapshotContent(id1)
+	if err != nil {
+		slog.Error("Error precomputing diff", "id1", id1, "id2", id2, "error", err)
+		return
+	}
+	content2, err := loadSnapshotContent(id2)
+	if err != nil {
+		slog.Error("Error precomputing diff", "id1", id1, "id2", id2, "error", err)
+		return
+	}
+	if !acquireDiffSlot(ctx) {
+		return
+	}
+	out := renderDiff(diffContents(diffModeChar, content1, content2), false)
+	releaseDiffSlot()
+	if ctx.Err() != nil {
+		return
+	}
+	storeDiff(id1, id2, diffModeChar, out)
+}
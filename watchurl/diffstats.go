@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"golang.org/x/net/html"
+)
+
+// DiffStats summarizes the size of the change between two snapshots.
+type DiffStats struct {
+	Inserted int
+	Deleted  int
+	// Context describes the element around the first change, such as
+	// "section#prices", if one could be found.
+	Context string
+}
+
+// String formats the stats as e.g. "+120 / -15 chars in <section#prices>".
+func (s DiffStats) String() string {
+	out := fmt.Sprintf("+%d / -%d chars", s.Inserted, s.Deleted)
+	if s.Context != "" {
+		out += " in <" + s.Context + ">"
+	}
+	return out
+}
+
+// computeDiffStats diffs old against new and counts the inserted and deleted
+// characters.
+func computeDiffStats(old, new string) DiffStats {
+	diffs := diffContents(diffModeChar, old, new)
+
+	var stats DiffStats
+	offset, firstChange := 0, -1
+	for _, d := range diffs {
+		n := len([]rune(d.Text))
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			stats.Inserted += n
+		case diffmatchpatch.DiffDelete:
+			stats.Deleted += n
+		}
+		if d.Type != diffmatchpatch.DiffEqual && firstChange < 0 {
+			firstChange = offset
+		}
+		if d.Type != diffmatchpatch.DiffDelete {
+			offset += len(d.Text)
+		}
+	}
+	if firstChange >= 0 {
+		stats.Context = changeContext(new, firstChange)
+	}
+	return stats
+}
+
+// changeContext returns a short selector-like description of the innermost
+// element with an id or class that is open at byte offset in the HTML
+// content, falling back to the innermost open element.
+func changeContext(content string, offset int) string {
+	type openTag struct{ name, id, class string }
+	var stack []openTag
+	z := html.NewTokenizer(strings.NewReader(content))
+	pos := 0
+	for pos < offset {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		pos += len(z.Raw())
+		switch tt {
+		case html.StartTagToken:
+			tok := z.Token()
+			if voidElements[tok.Data] {
+				continue
+			}
+			t := openTag{name: tok.Data}
+			for _, a := range tok.Attr {
+				switch a.Key {
+				case "id":
+					t.id = a.Val
+				case "class":
+					t.class = strings.Fields(a.Val + " ")[0]
+				}
+			}
+			stack = append(stack, t)
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].name == string(name) {
+					stack = stack[:i]
+					break
+				}
+			}
+		}
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		switch {
+		case stack[i].id != "":
+			return stack[i].name + "#" + stack[i].id
+		case stack[i].class != "":
+			return stack[i].name + "." + stack[i].class
+		}
+	}
+	if len(stack) > 0 {
+		return stack[len(stack)-1].name
+	}
+	return ""
+}
+
+// voidElements never have closing tags and so never enclose a change.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true,
+	"img": true, "input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
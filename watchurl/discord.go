@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// DiscordEmbed is the subset of Discord's embed object used for
+// notifications.
+type DiscordEmbed struct {
+	Title       string     `json:"title"`
+	URL         string     `json:"url,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Timestamp   *time.Time `json:"timestamp,omitempty"`
+}
+
+// DiscordPayload is the JSON body POSTed to DISCORD_WEBHOOK_URL.
+type DiscordPayload struct {
+	Embeds []DiscordEmbed `json:"embeds"`
+}
+
+// sendDiscordNotification posts a change notification to the Discord
+// webhook. If BASE_URL is set to the address of this server's web UI, the
+// embed links to the diff that introduced snapshot snapshotID.
+func sendDiscordNotification(monitoredURL, message string, urlID int, changeTime time.Time, snapshotID int64) error {
+	description := message
+	if base := os.Getenv("BASE_URL"); base != "" {
+		description += fmt.Sprintf("\n\n[View diff](%s%s)", strings.TrimRight(base, "/"), snapshotDiffPath(urlID, snapshotID))
+	}
+	return sendDiscordEmbed(DiscordEmbed{
+		Title:       notificationTitle,
+		URL:         monitoredURL,
+		Description: description,
+		Timestamp:   &changeTime,
+	})
+}
+
+// sendDiscordMessage posts a message with the given title to the Discord
+// webhook, linking to monitoredURL.
+func sendDiscordMessage(title, message, monitoredURL string) error {
+	return sendDiscordEmbed(DiscordEmbed{Title: title, URL: monitoredURL, Description: message})
+}
+
+// sendDiscordEmbed POSTs embed to DISCORD_WEBHOOK_URL. Discord answers 429
+// when the webhook is rate limited; the message is then dropped and the
+// suggested wait returned in the error.
+func sendDiscordEmbed(embed DiscordEmbed) error {
+	webhookURL := os.Getenv("DISCORD_WEBHOOK_URL")
+	if webhookURL == "" {
+		return errors.New("missing Discord webhook URL")
+	}
+
+	body, err := json.Marshal(DiscordPayload{Embeds: []DiscordEmbed{embed}})
+	if err != nil {
+		return fmt.Errorf("encoding Discord payload: %w", err)
+	}
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		var limit struct {
+			RetryAfter float64 `json:"retry_after"`
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 1024)).Decode(&limit); err != nil {
+			return errors.New("Discord rate limited the notification")
+		}
+		return fmt.Errorf("Discord rate limited the notification; retry after %.1fs", limit.RetryAfter)
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Discord returned %s: %s", resp.Status, msg)
+	}
+	return nil
+}
+
+// snapshotDiffPath returns the path of the diff page comparing snapshot
+// snapshotID of a URL with the one before it, or of the URL's history if
+// it is the first.
+func snapshotDiffPath(urlID int, snapshotID int64) string {
+	prevID, ok := previousSnapshotID(urlID, snapshotID)
+	if !ok {
+		return fmt.Sprintf("/history?id=%d", urlID)
+	}
+	return fmt.Sprintf("/diff?id1=%d&id2=%d", prevID, snapshotID)
+}
+
+// previousSnapshotID returns the id of the snapshot of a URL taken before
+// snapshotID from the same region, if there is one.
+func previousSnapshotID(urlID int, snapshotID int64) (int64, bool) {
+	var prevID int64
+	err := db.QueryRow(`SELECT id FROM url_snapshots
+        WHERE url_id = ? AND region = (SELECT region FROM url_snapshots WHERE id = ?) AND id < ?
+        ORDER BY id DESC LIMIT 1`, urlID, snapshotID, snapshotID).Scan(&prevID)
+	return prevID, err == nil
+}
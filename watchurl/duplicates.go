@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// duplicateURLError reports that a URL is already monitored with the same
+// selector, which would fetch it twice and split its history.
+type duplicateURLError struct {
+	ID int
+}
+
+func (e *duplicateURLError) Error() string {
+	return fmt.Sprintf("already monitored as URL id %d", e.ID)
+}
+
+// checkDuplicateURL returns a *duplicateURLError if url is monitored with
+// selector by a URL other than exceptID, as seen through q.
+func checkDuplicateURL(q queryExecer, url, selector string, exceptID int) error {
+	var id int
+	err := q.QueryRow("SELECT id FROM monitored_urls WHERE url = ? AND selector = ? AND id != ?", url, selector, exceptID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return &duplicateURLError{ID: id}
+}
+
+// isUniqueViolation reports whether err is SQLite rejecting a write that
+// breaks a UNIQUE constraint.
+func isUniqueViolation(err error) bool {
+	var serr *sqlite.Error
+	return errors.As(err, &serr) && serr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+// ensureUniqueURLs makes the database reject a URL monitored twice with the
+// same selector. Databases that already hold such duplicates are left
+// without the index, and warned about, until they are removed.
+func ensureUniqueURLs() error {
+	var dups int
+	err := db.QueryRow("SELECT COUNT(*) FROM (SELECT 1 FROM monitored_urls GROUP BY url, selector HAVING COUNT(*) > 1)").Scan(&dups)
+	if err != nil {
+		return err
+	}
+	if dups > 0 {
+		slog.Warn("Not rejecting duplicate URLs: some are already monitored more than once with the same selector; delete the extra copies to fix this", "duplicates", dups)
+		return nil
+	}
+	_, err = writeDB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS monitored_urls_url_selector ON monitored_urls (url, selector)")
+	return err
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+func sendEmailNotification(monitoredURL, message string) error {
+	return sendEmailMessage(notificationTitle, message+"\n\n"+monitoredURL)
+}
+
+// sendEmailMessage sends a plaintext email with the given subject and body to
+// the comma-separated SMTP_TO recipients.
+func sendEmailMessage(subject, body string) error {
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	user := os.Getenv("SMTP_USER")
+	pass := os.Getenv("SMTP_PASS")
+	to := os.Getenv("SMTP_TO")
+
+	if host == "" || to == "" {
+		return errors.New("missing SMTP host or recipient")
+	}
+	if port == "" {
+		port = "587"
+	}
+
+	var recipients []string
+	for _, addr := range strings.Split(to, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			recipients = append(recipients, addr)
+		}
+	}
+	from := user
+	if from == "" {
+		from = "watchurl@" + host
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		from, strings.Join(recipients, ", "), subject, time.Now().Format(time.RFC1123Z), body)
+
+	var auth smtp.Auth
+	if user != "" {
+		auth = smtp.PlainAuth("", user, pass, host)
+	}
+	if err := smtp.SendMail(net.JoinHostPort(host, port), auth, from, recipients, []byte(msg)); err != nil {
+		return err
+	}
+	slog.Debug("Email notification sent", "recipients", strings.Join(recipients, ", "))
+	return nil
+}
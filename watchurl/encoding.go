@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// acceptEncoding lists the content codings decodeBody understands.
+const acceptEncoding = "gzip, deflate, br"
+
+// decodedBody reads a decompressed response body and closes the original.
+type decodedBody struct {
+	io.Reader
+	body io.Closer
+}
+
+func (d decodedBody) Close() error {
+	return d.body.Close()
+}
+
+// decodeBody replaces the body of resp with its decoded content according
+// to its Content-Encoding, and removes the header. The returned error is
+// for codings that can't be decoded; resp.Body is then closed.
+func decodeBody(resp *http.Response) error {
+	header := resp.Header.Get("Content-Encoding")
+	if header == "" {
+		return nil
+	}
+	// Codings are listed in the order they were applied, so they are
+	// undone from the last.
+	codings := strings.Split(header, ",")
+	var r io.Reader = resp.Body
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		switch coding := strings.ToLower(strings.TrimSpace(codings[i])); coding {
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(r)
+		case "deflate":
+			r, err = newDeflateReader(r)
+		case "br":
+			r = brotli.NewReader(r)
+		case "identity", "":
+		default:
+			err = fmt.Errorf("unsupported Content-Encoding %q", coding)
+		}
+		if err != nil {
+			resp.Body.Close()
+			return fmt.Errorf("decoding response: %w", err)
+		}
+	}
+	resp.Body = decodedBody{Reader: r, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	return nil
+}
+
+// newDeflateReader decodes a "deflate" body. That should be zlib-wrapped,
+// but some servers send raw DEFLATE data, so both are accepted.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func gzipBytes(s string) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write([]byte(s))
+	w.Close()
+	return b.Bytes()
+}
+
+func zlibBytes(s string) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write([]byte(s))
+	w.Close()
+	return b.Bytes()
+}
+
+func brotliBytes(s string) []byte {
+	var b bytes.Buffer
+	w := brotli.NewWriter(&b)
+	w.Write([]byte(s))
+	w.Close()
+	return b.Bytes()
+}
+
+func TestFetchURLDecodesResponses(t *testing.T) {
+	const page = "<html><body><p>Hello, compressed world</p></body></html>"
+	tests := []struct {
+		encoding string
+		body     []byte
+	}{
+		{"", []byte(page)},
+		{"gzip", gzipBytes(page)},
+		{"deflate", zlibBytes(page)},
+		{"br", brotliBytes(page)},
+		{"gzip, br", brotliBytes(string(gzipBytes(page)))},
+	}
+	for _, tt := range tests {
+		var accepted string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			accepted = r.Header.Get("Accept-Encoding")
+			if tt.encoding != "" {
+				w.Header().Set("Content-Encoding", tt.encoding)
+			}
+			w.Header().Set("Content-Type", "text/html")
+			w.Write(tt.body)
+		}))
+		resp, err := fetchURL(context.Background(), FetchProfile{}, srv.URL)
+		if err != nil {
+			srv.Close()
+			t.Fatalf("%q: fetchURL: %v", tt.encoding, err)
+		}
+		got, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%q: reading body: %v", tt.encoding, err)
+		}
+		if string(got) != page {
+			t.Errorf("%q: body = %q, want %q", tt.encoding, got, page)
+		}
+		if resp.Header.Get("Content-Encoding") != "" {
+			t.Errorf("%q: Content-Encoding left as %q", tt.encoding, resp.Header.Get("Content-Encoding"))
+		}
+		for _, coding := range []string{"gzip", "deflate", "br"} {
+			if !strings.Contains(accepted, coding) {
+				t.Errorf("Accept-Encoding %q doesn't offer %s", accepted, coding)
+			}
+		}
+	}
+}
+
+func TestDecodeBodyRejectsUnknownCoding(t *testing.T) {
+	resp := &http.Response{
+		Header: http.Header{"Content-Encoding": {"zstd"}},
+		Body:   io.NopCloser(strings.NewReader("x")),
+	}
+	if err := decodeBody(resp); err == nil || !strings.Contains(err.Error(), "zstd") {
+		t.Errorf("decodeBody = %v, want an unsupported coding error", err)
+	}
+}
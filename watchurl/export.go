@@ -0,0 +1,381 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportVersion is the version of the export file format.
+const exportVersion = 1
+
+// ExportFile is the JSON document written by -export and read by -import.
+type ExportFile struct {
+	Version    int         `json:"version"`
+	ExportedAt time.Time   `json:"exported_at"`
+	URLs       []ExportURL `json:"urls"`
+}
+
+// ExportURL is a monitored URL with its settings and, optionally, snapshots.
+// Durations are in seconds. IDs are not exported; imported URLs get new ones.
+type ExportURL struct {
+	URL       string   `json:"url"`
+	Frequency int      `json:"frequency"`
+	Channels  []string `json:"channels"`
+	// PushEnabled is read from older exports, which predate channels.
+	PushEnabled         bool              `json:"push_enabled,omitempty"`
+	Paused              bool              `json:"paused,omitempty"`
+	ExtractMode         string            `json:"extract_mode,omitempty"`
+	Offset              int               `json:"offset,omitempty"`
+	StartAt             string            `json:"start_at,omitempty"`
+	FollowSelector      string            `json:"follow_selector,omitempty"`
+	Selector            string            `json:"selector,omitempty"`
+	StartMarker         string            `json:"start_marker,omitempty"`
+	EndMarker           string            `json:"end_marker,omitempty"`
+	JSONPath            string            `json:"json_path,omitempty"`
+	NormalizeWhitespace bool              `json:"normalize_whitespace,omitempty"`
+	MinChange           int               `json:"min_change,omitempty"`
+	Profile             string            `json:"profile,omitempty"`
+	Headers             map[string]string `json:"headers,omitempty"`
+	Condition           string            `json:"condition,omitempty"`
+	ConfirmCount        int               `json:"confirm_count,omitempty"`
+	ConfirmDelay        int               `json:"confirm_delay,omitempty"`
+	Regions             string            `json:"regions,omitempty"`
+	Tags                []string          `json:"tags,omitempty"`
+	NoRedirects         bool              `json:"no_redirects,omitempty"`
+	Masks               []string          `json:"masks,omitempty"`
+	BlockMarkers        []string          `json:"block_markers,omitempty"`
+	Screenshot          bool              `json:"screenshot,omitempty"`
+	UserAgent           string            `json:"user_agent,omitempty"`
+	Schedule            string            `json:"schedule,omitempty"`
+	StoreRaw            bool              `json:"store_raw,omitempty"`
+	WatchTitle          bool              `json:"watch_title,omitempty"`
+	Method              string            `json:"method,omitempty"`
+	RequestBody         string            `json:"request_body,omitempty"`
+	BodyType            string            `json:"body_type,omitempty"`
+	PushoverPriority    int               `json:"pushover_priority,omitempty"`
+	PushoverSound       string            `json:"pushover_sound,omitempty"`
+	PushoverRetry       int               `json:"pushover_retry,omitempty"`
+	PushoverExpire      int               `json:"pushover_expire,omitempty"`
+	InsecureSkipVerify  bool              `json:"insecure_skip_verify,omitempty"`
+	ClientCert          string            `json:"client_cert,omitempty"`
+	ClientKey           string            `json:"client_key,omitempty"`
+	Snapshots           []ExportSnapshot  `json:"snapshots,omitempty"`
+}
+
+// ExportSnapshot is one stored snapshot of an exported URL.
+type ExportSnapshot struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Content     string    `json:"content"`
+	Manual      bool      `json:"manual,omitempty"`
+	Region      string    `json:"region,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Raw         string    `json:"raw,omitempty"`
+	// StatusCode, ContentLength and FetchDurationMS describe the HTTP
+	// response; they are zero if it was not recorded.
+	StatusCode      int   `json:"status_code,omitempty"`
+	ContentLength   int64 `json:"content_length,omitempty"`
+	FetchDurationMS int64 `json:"fetch_duration_ms,omitempty"`
+	// FinalURL is the address the content came from after redirects.
+	FinalURL string `json:"final_url,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Initial  bool   `json:"initial,omitempty"`
+}
+
+// exportData writes every monitored URL, and their snapshots if
+// withSnapshots is set, to path.
+func exportData(path string, withSnapshots bool) error {
+	rows, err := db.Query("SELECT " + monitoredURLColumns + " FROM monitored_urls ORDER BY id")
+	if err != nil {
+		return err
+	}
+	var urls []MonitoredURL
+	for rows.Next() {
+		m, err := scanMonitoredURL(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		urls = append(urls, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	out := ExportFile{Version: exportVersion, ExportedAt: time.Now(), URLs: []ExportURL{}}
+	snapshotCount := 0
+	for _, m := range urls {
+		e := ExportURL{
+			URL:                 m.URL,
+			Frequency:           int(m.Frequency / time.Second),
+			Channels:            m.Channels,
+			Paused:              m.Paused,
+			ExtractMode:         m.ExtractMode,
+			Offset:              int(m.Offset / time.Second),
+			StartAt:             m.StartAt,
+			FollowSelector:      m.FollowSelector,
+			Selector:            m.Selector,
+			StartMarker:         m.StartMarker,
+			EndMarker:           m.EndMarker,
+			JSONPath:            m.JSONPath,
+			NormalizeWhitespace: m.NormalizeWhitespace,
+			MinChange:           m.MinChange,
+			Profile:             m.Profile,
+			Headers:             m.Headers,
+			Condition:           m.Condition,
+			ConfirmCount:        m.ConfirmCount,
+			ConfirmDelay:        int(m.ConfirmDelay / time.Second),
+			Regions:             formatRegions(m.Regions),
+			Tags:                m.Tags,
+			NoRedirects:         m.NoRedirects,
+			Masks:               m.Masks,
+			BlockMarkers:        m.BlockMarkers,
+			Screenshot:          m.Screenshot,
+			UserAgent:           m.UserAgent,
+			Schedule:            m.Schedule,
+			StoreRaw:            m.StoreRaw,
+			WatchTitle:          m.WatchTitle,
+			Method:              m.Method,
+			RequestBody:         m.RequestBody,
+			BodyType:            m.BodyType,
+			PushoverPriority:    m.Pushover.Priority,
+			PushoverSound:       m.Pushover.Sound,
+			PushoverRetry:       m.Pushover.Retry,
+			PushoverExpire:      m.Pushover.Expire,
+			InsecureSkipVerify:  m.InsecureSkipVerify,
+			ClientCert:          m.ClientCert,
+			ClientKey:           m.ClientKey,
+		}
+		if withSnapshots {
+			if e.Snapshots, err = exportSnapshotsFor(m.ID); err != nil {
+				return fmt.Errorf("exporting snapshots of URL id %d: %w", m.ID, err)
+			}
+			snapshotCount += len(e.Snapshots)
+		}
+		out.URLs = append(out.URLs, e)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	slog.Info("Exported URLs", "event", "export", "urls", len(out.URLs), "snapshots", snapshotCount, "path", path)
+	return nil
+}
+
+// exportSnapshotsFor returns the snapshots of a URL, oldest first.
+func exportSnapshotsFor(urlID int) ([]ExportSnapshot, error) {
+	rows, err := db.Query("SELECT timestamp, content, content_path, compressed, manual, region, content_type, raw, status_code, content_length, fetch_duration_ms, final_url, title, initial FROM url_snapshots WHERE url_id = ? ORDER BY timestamp, id", urlID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var snaps []ExportSnapshot
+	for rows.Next() {
+		var s ExportSnapshot
+		var content, contentPath, raw sql.NullString
+		var compressed bool
+		if err := rows.Scan(&s.Timestamp, &content, &contentPath, &compressed, &s.Manual, &s.Region, &s.ContentType, &raw, &s.StatusCode, &s.ContentLength, &s.FetchDurationMS, &s.FinalURL, &s.Title, &s.Initial); err != nil {
+			return nil, err
+		}
+		if s.Content, err = snapshotContent(content, contentPath, compressed); err != nil {
+			return nil, err
+		}
+		s.Raw = raw.String
+		snaps = append(snaps, s)
+	}
+	return snaps, rows.Err()
+}
+
+// importData adds the URLs in an export file, with their snapshots, under
+// new ids. URLs that are already monitored (by address) are skipped.
+func importData(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var in ExportFile
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	if in.Version != exportVersion {
+		return fmt.Errorf("unsupported export version %d", in.Version)
+	}
+
+	// Everything is imported in one transaction, so that a failure leaves
+	// the database as it was. Snapshot files written along the way are
+	// removed again in that case.
+	tx, err := writeDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var prepared []preparedSnapshot
+	committed := false
+	defer func() {
+		if !committed {
+			for _, p := range prepared {
+				p.discard()
+			}
+		}
+	}()
+
+	imported, skipped, snapshotCount := 0, 0, 0
+	for _, e := range in.URLs {
+		e.URL = strings.TrimSpace(e.URL)
+		if err := validateMonitoredURL(e.URL); err != nil {
+			slog.Warn("Skipping import: not an absolute http or https URL", "url", e.URL)
+			skipped++
+			continue
+		}
+		var exists int
+		err := tx.QueryRow("SELECT 1 FROM monitored_urls WHERE url = ?", e.URL).Scan(&exists)
+		if err == nil {
+			slog.Warn("Skipping import: already monitored", "url", e.URL)
+			skipped++
+			continue
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+		if e.Frequency <= 0 {
+			slog.Warn("Skipping import: invalid frequency", "url", e.URL, "frequency", e.Frequency)
+			skipped++
+			continue
+		}
+		if err := checkFrequency(time.Duration(e.Frequency) * time.Second); err != nil {
+			slog.Warn("Skipping import", "url", e.URL, "error", err)
+			skipped++
+			continue
+		}
+
+		if e.Schedule != "" {
+			if _, err := parseCron(e.Schedule); err != nil {
+				slog.Warn("Ignoring invalid schedule", "url", e.URL, "error", err)
+				e.Schedule = ""
+			}
+		}
+		if startAt, err := parseStartAt(e.StartAt); err != nil {
+			slog.Warn("Ignoring invalid start time", "url", e.URL, "start_at", e.StartAt)
+			e.StartAt = ""
+		} else {
+			e.StartAt = startAt
+		}
+		if e.PushEnabled {
+			e.Channels = append(e.Channels, channelPushover)
+		}
+		regions, err := parseRegions(e.Regions)
+		if err != nil {
+			slog.Warn("Ignoring invalid regions", "url", e.URL, "error", err)
+		}
+		masks, err := parseMasks(formatMasks(e.Masks))
+		if err != nil {
+			slog.Warn("Ignoring invalid masks", "url", e.URL, "error", err)
+		}
+		blockMarkers, err := parseMasks(formatMasks(e.BlockMarkers))
+		if err != nil {
+			slog.Warn("Ignoring invalid block markers", "url", e.URL, "error", err)
+		}
+		method, body, bodyType, err := parseRequest(e.Method, e.RequestBody, e.BodyType)
+		if err != nil {
+			slog.Warn("Skipping import", "url", e.URL, "error", err)
+			skipped++
+			continue
+		}
+		pushover, err := parsePushoverOptions(strconv.Itoa(e.PushoverPriority), e.PushoverSound, strconv.Itoa(e.PushoverRetry), strconv.Itoa(e.PushoverExpire))
+		if err != nil {
+			slog.Warn("Ignoring invalid Pushover options", "url", e.URL, "error", err)
+			pushover = PushoverOptions{}
+		}
+		m := MonitoredURL{
+			URL:                 e.URL,
+			Frequency:           time.Duration(e.Frequency) * time.Second,
+			Channels:            filterChannels(e.Channels),
+			Paused:              e.Paused,
+			ExtractMode:         e.ExtractMode,
+			Offset:              time.Duration(e.Offset) * time.Second,
+			StartAt:             e.StartAt,
+			FollowSelector:      e.FollowSelector,
+			Selector:            e.Selector,
+			StartMarker:         e.StartMarker,
+			EndMarker:           e.EndMarker,
+			JSONPath:            e.JSONPath,
+			NormalizeWhitespace: e.NormalizeWhitespace,
+			MinChange:           e.MinChange,
+			Profile:             e.Profile,
+			Headers:             e.Headers,
+			Condition:           e.Condition,
+			ConfirmCount:        e.ConfirmCount,
+			ConfirmDelay:        time.Duration(e.ConfirmDelay) * time.Second,
+			Regions:             regions,
+			Tags:                parseTags(strings.Join(e.Tags, ",")),
+			NoRedirects:         e.NoRedirects,
+			Masks:               masks,
+			BlockMarkers:        blockMarkers,
+			Screenshot:          e.Screenshot,
+			UserAgent:           e.UserAgent,
+			Schedule:            e.Schedule,
+			StoreRaw:            e.StoreRaw,
+			WatchTitle:          e.WatchTitle,
+			Method:              method,
+			RequestBody:         body,
+			BodyType:            bodyType,
+			Pushover:            pushover,
+			// Certificate files may not exist yet where the export is
+			// imported, so their paths are kept as they are.
+			InsecureSkipVerify: e.InsecureSkipVerify,
+			ClientCert:         e.ClientCert,
+			ClientKey:          e.ClientKey,
+		}
+		if err := insertMonitoredURL(tx, &m); err != nil {
+			return fmt.Errorf("importing %s: %w; nothing was imported", e.URL, err)
+		}
+		for _, s := range e.Snapshots {
+			p, err := prepareSnapshot(NewSnapshot{
+				URLID:         m.ID,
+				Content:       s.Content,
+				Manual:        s.Manual,
+				Region:        s.Region,
+				Raw:           s.Raw,
+				KeepRaw:       m.StoreRaw,
+				ContentType:   s.ContentType,
+				Timestamp:     s.Timestamp,
+				StatusCode:    s.StatusCode,
+				ContentLength: s.ContentLength,
+				FetchDuration: time.Duration(s.FetchDurationMS) * time.Millisecond,
+				FinalURL:      s.FinalURL,
+				Title:         s.Title,
+				Initial:       s.Initial,
+			})
+			if err == nil {
+				prepared = append(prepared, p)
+				_, err = p.insert(tx)
+			}
+			if err != nil {
+				return fmt.Errorf("importing snapshots of %s: %w; nothing was imported", e.URL, err)
+			}
+			snapshotCount++
+		}
+		imported++
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%w; nothing was imported", err)
+	}
+	committed = true
+	slog.Info("Imported URLs", "event", "import", "urls", imported, "snapshots", snapshotCount, "skipped", skipped, "path", path)
+	return nil
+}
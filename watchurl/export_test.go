@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportSkipsInvalidURLs(t *testing.T) {
+	openTestDB(t)
+	in := ExportFile{Version: exportVersion, URLs: []ExportURL{
+		{URL: "ftp://x", Frequency: 3600},
+		{URL: "example.com", Frequency: 3600},
+		{URL: " https://example.com/ok ", Frequency: 3600, Paused: true},
+	}}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "urls.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := importData(path); err != nil {
+		t.Fatalf("importData: %v", err)
+	}
+	rows, err := db.Query("SELECT url FROM monitored_urls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var urls []string
+	for rows.Next() {
+		var u string
+		rows.Scan(&u)
+		urls = append(urls, u)
+	}
+	if len(urls) != 1 || urls[0] != "https://example.com/ok" {
+		t.Errorf("imported %q, want only the valid URL, trimmed", urls)
+	}
+}
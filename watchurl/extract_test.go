@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestExtractBodySortsAttributes(t *testing.T) {
+	a := extractBody(`<html><body><a href="/x" class="c" id="i">x</a><img src="s.png" alt="a"></body></html>`)
+	b := extractBody(`<html><body><a id="i" class="c" href="/x">x</a><img alt="a" src="s.png"></body></html>`)
+	if a != b {
+		t.Errorf("attribute order changed the extracted body:\n%s\n%s", a, b)
+	}
+	want := `<a class="c" href="/x" id="i">x</a><img alt="a" src="s.png"/>`
+	if a != want {
+		t.Errorf("extractBody = %q, want %q", a, want)
+	}
+}
+
+func TestExtractBodyKeepsLinkChanges(t *testing.T) {
+	a := extractBody(`<html><body><a href="/old" class="c">x</a></body></html>`)
+	b := extractBody(`<html><body><a class="c" href="/new">x</a></body></html>`)
+	if a == b {
+		t.Errorf("a changed href extracted identically: %q", a)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// findLink returns the absolute URL of the first element in page matched by
+// selector. The element's href (or, failing that, src) attribute is resolved
+// against base so that relative links work.
+func findLink(page string, base *url.URL, selector string) (string, error) {
+	sel, err := cascadia.Compile(selector)
+	if err != nil {
+		return "", err
+	}
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		return "", err
+	}
+
+	for _, n := range cascadia.QueryAll(doc, sel) {
+		var href string
+		for _, a := range n.Attr {
+			if a.Key == "href" || (a.Key == "src" && href == "") {
+				href = strings.TrimSpace(a.Val)
+			}
+		}
+		if href == "" {
+			continue
+		}
+		ref, err := url.Parse(href)
+		if err != nil {
+			continue
+		}
+		if base == nil {
+			return ref.String(), nil
+		}
+		return base.ResolveReference(ref).String(), nil
+	}
+	return "", errors.New("no matching link found")
+}
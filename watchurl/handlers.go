@@ -1,51 +1,148 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/andybalholm/cascadia"
 	"github.com/dustin/go-humanize"
-	"github.com/sergi/go-diff/diffmatchpatch"
 )
 
+// indexSortKeys are the orders the index page can be sorted in, as given by
+// its sort parameter, and indexSortColumns what each sorts by. Only these
+// expressions are ever put into the query.
+var (
+	indexSortKeys    = []string{"url", "updated", "frequency", "failures"}
+	indexSortColumns = map[string]string{
+		"url":       "mu.url",
+		"updated":   "s.last_updated",
+		"frequency": "mu.frequency",
+		"failures":  "mu.consecutive_failures",
+	}
+	indexSortLabels = map[string]string{
+		"url":       "URL",
+		"updated":   "Last updated",
+		"frequency": "Frequency",
+		"failures":  "Failures",
+	}
+)
+
+// IndexSort is a link that sorts the index page.
+type IndexSort struct {
+	Label string
+	Link  string
+	// Current is set on the link for the order in effect, which reverses
+	// it, and Desc if that order is descending.
+	Current bool
+	Desc    bool
+}
+
+// indexOrder returns the ORDER BY clause for the index page's sort and dir
+// parameters, and the sort links to show with it. An unknown sort keeps the
+// order the URLs were added in.
+func indexOrder(query url.Values) (string, []IndexSort, bool) {
+	key := query.Get("sort")
+	desc := query.Get("dir") == "desc"
+	column, sorted := indexSortColumns[key]
+	links := make([]IndexSort, len(indexSortKeys))
+	for i, k := range indexSortKeys {
+		v := url.Values{}
+		if tag := query.Get("tag"); tag != "" {
+			v.Set("tag", tag)
+		}
+		v.Set("sort", k)
+		current := sorted && k == key
+		if current && !desc {
+			v.Set("dir", "desc")
+		}
+		links[i] = IndexSort{Label: indexSortLabels[k], Link: "/?" + v.Encode(), Current: current, Desc: current && desc}
+	}
+	if !sorted {
+		return "ORDER BY mu.id", links, false
+	}
+	dir := "ASC"
+	if desc {
+		dir = "DESC"
+	}
+	return "ORDER BY " + column + " " + dir + ", mu.id", links, true
+}
+
 // indexHandler renders the index page using the index template.
 func indexHandler(w http.ResponseWriter, r *http.Request) {
+	order, sorts, sorted := indexOrder(r.URL.Query())
 	rows, err := db.Query(`
-        SELECT mu.id, mu.url, mu.frequency, s.last_updated, mu.push_enabled
+        SELECT mu.id, mu.url, mu.frequency, s.last_updated, mu.channels,
+               mu.extract_mode, mu.offset_seconds, mu.follow_selector, mu.profile,
+               mu.condition, mu.condition_state, mu.confirm_count, mu.confirm_delay,
+               mu.regions, mu.selector, mu.normalize_whitespace, mu.min_change, mu.paused, mu.auto_paused, mu.headers, mu.json_path, mu.tags, mu.no_redirects, mu.masks, mu.screenshot, mu.user_agent,
+               mu.consecutive_failures, mu.last_error, lc.last_check, mu.schedule, mu.store_raw,
+               COALESCE(st.snapshots, 0), COALESCE(st.size, 0), mu.watch_title, mu.method, mu.request_body, mu.body_type, mu.start_at, mu.block_markers, mu.start_marker, mu.end_marker, mu.pushover_priority, mu.pushover_sound, mu.pushover_retry, mu.pushover_expire, mu.insecure_skip_verify, mu.client_cert, mu.client_key,
+               COALESCE((SELECT title FROM url_snapshots WHERE url_id = mu.id AND region = '' ORDER BY timestamp DESC LIMIT 1), '')
         FROM monitored_urls mu
+        LEFT JOIN url_last_check lc ON mu.id = lc.url_id
+        LEFT JOIN (
+            SELECT url_id, SUM(region = '') AS snapshots,
+                   SUM(COALESCE(LENGTH(CAST(content AS BLOB)), 0) + COALESCE(LENGTH(CAST(raw AS BLOB)), 0)) AS size
+            FROM url_snapshots
+            GROUP BY url_id
+        ) st ON mu.id = st.url_id
         LEFT JOIN (
             SELECT url_id, MAX(timestamp) as last_updated
             FROM url_snapshots
+            WHERE region = ''
             GROUP BY url_id
-        ) s ON mu.id = s.url_id`)
+        ) s ON mu.id = s.url_id
+        ` + order)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
 
+	tag := r.URL.Query().Get("tag")
 	var urls []MonitoredURLView
 	for rows.Next() {
 		var u MonitoredURLView
 		var lastUpdatedStr sql.NullString
-		var freqSeconds, pushInt int
-		err := rows.Scan(&u.ID, &u.URL, &freqSeconds, &lastUpdatedStr, &pushInt)
+		var lastCheck sql.NullTime
+		var freqSeconds, normalizeInt, pausedInt, noRedirectsInt, screenshotInt, storeRawInt, watchTitleInt int
+		var channels, headers, tags string
+		err := rows.Scan(&u.ID, &u.URL, &freqSeconds, &lastUpdatedStr, &channels, &u.ExtractMode, &u.Offset, &u.FollowSelector, &u.Profile, &u.Condition, &u.ConditionState, &u.ConfirmCount, &u.ConfirmDelay, &u.Regions, &u.Selector, &normalizeInt, &u.MinChange, &pausedInt, &u.AutoPaused, &headers, &u.JSONPath, &tags, &noRedirectsInt, &u.Masks, &screenshotInt, &u.UserAgent, &u.ConsecutiveFailures, &u.LastError, &lastCheck, &u.Schedule, &storeRawInt, &u.SnapshotCount, &u.SnapshotBytes, &watchTitleInt, &u.Method, &u.RequestBody, &u.BodyType, &u.StartAt, &u.BlockMarkers, &u.StartMarker, &u.EndMarker, &u.Pushover.Priority, &u.Pushover.Sound, &u.Pushover.Retry, &u.Pushover.Expire, &u.InsecureSkipVerify, &u.ClientCert, &u.ClientKey, &u.Title)
 		if err != nil {
-			log.Printf("Error scanning row: %v", err)
+			slog.Error("Error scanning row", "error", err)
+			continue
+		}
+		u.Tags = parseTags(tags)
+		if tag != "" && !hasTag(u.Tags, tag) {
 			continue
 		}
 		u.Frequency = freqSeconds
-		u.PushEnabled = pushInt != 0
+		u.Channels = parseChannels(channels)
+		u.NormalizeWhitespace = normalizeInt != 0
+		u.Paused = pausedInt != 0
+		u.NoRedirects = noRedirectsInt != 0
+		u.Screenshot = screenshotInt != 0
+		u.StoreRaw = storeRawInt != 0
+		u.WatchTitle = watchTitleInt != 0
+		if h, err := decodeHeaders(headers); err != nil {
+			slog.Warn("Ignoring invalid headers", "url_id", u.ID, "error", err)
+		} else {
+			u.Headers = formatHeaderLines(h)
+		}
 		if lastUpdatedStr.Valid {
-			// Split the string at the " m=" portion to remove the monotonic clock info.
-			cleanTimeStr := strings.Split(lastUpdatedStr.String, " m=")[0]
-			// Use a layout that matches the cleaned string.
-			parsed, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", cleanTimeStr)
+			parsed, err := parseStoredTime(lastUpdatedStr.String)
 			if err != nil {
 				u.LastUpdated = lastUpdatedStr.String
 			} else {
@@ -54,52 +151,428 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 		} else {
 			u.LastUpdated = "Never"
 		}
+		u.LastCheck, u.NextCheck = checkTimes(lastCheck, freqSeconds, u.Schedule, u.Paused)
 		urls = append(urls, u)
 	}
+	rows.Close()
+	for i := range urls {
+		urls[i].LastChange = latestChangeSummary(urls[i].ID)
+	}
+
+	metrics, err := loadMetrics()
+	if err != nil {
+		slog.Error("Error computing metrics", "error", err)
+	}
 
-	if err := indexTmpl.Execute(w, urls); err != nil {
+	profiles, err := listFetchProfiles()
+	if err != nil {
+		slog.Error("Error listing fetch profiles", "error", err)
+	}
+
+	iv := IndexView{
+		URLs:     urls,
+		Tag:      tag,
+		Sorts:    sorts,
+		Sorted:   sorted,
+		ReadOnly: readOnly,
+		Channels: notificationChannels,
+		Profiles: profiles,
+		Metrics:  metrics,
+	}
+	if err := indexTmpl.Execute(w, iv); err != nil {
 		http.Error(w, "Template error", http.StatusInternalServerError)
 	}
 }
 
+// checkTimes describes when a URL was last checked and, unless it is paused,
+// when it is next due after its frequency or on its schedule.
+func checkTimes(lastCheck sql.NullTime, freqSeconds int, schedule string, paused bool) (last, next string) {
+	next = "paused"
+	if !lastCheck.Valid {
+		if !paused {
+			next = "soon"
+		}
+		return "Never", next
+	}
+	if !paused {
+		due := lastCheck.Time.Add(time.Duration(freqSeconds) * time.Second)
+		if schedule != "" {
+			if sched, err := parseCron(schedule); err == nil {
+				due = sched.next(lastCheck.Time)
+			}
+		}
+		if due.Before(time.Now()) {
+			next = "overdue, due " + humanize.Time(due)
+		} else {
+			next = humanize.Time(due)
+		}
+	}
+	return humanize.Time(lastCheck.Time), next
+}
+
 // addURLHandler adds a new URL to monitor and starts a goroutine for it.
 func addURLHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-	urlStr := r.FormValue("url")
-	freqStr := r.FormValue("frequency")
-	freq, err := strconv.Atoi(freqStr)
-	if err != nil || freq <= 0 {
-		http.Error(w, "Invalid frequency", http.StatusBadRequest)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
 		return
 	}
+	m, err := monitoredURLFromForm(r.Form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := addMonitoredURL(&m); err != nil {
+		var dup *duplicateURLError
+		if errors.As(err, &dup) {
+			http.Error(w, fmt.Sprintf("This URL is already monitored (id %d); edit it to change its frequency", dup.ID), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// formError is a problem with submitted form values, worded for the user.
+type formError string
+
+func (e formError) Error() string { return string(e) }
+
+// validateMonitoredURL checks that s is an absolute http or https URL.
+func validateMonitoredURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return formError("Invalid URL")
+	}
+	return nil
+}
+
+// parseStartAt validates a start time of day as entered, e.g. "9:30", and
+// returns it as stored, "09:30". An empty start time is no start time.
+func parseStartAt(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return "", formError("Invalid start time")
+	}
+	return t.Format("15:04"), nil
+}
+
+// monitoredURLFromForm validates the settings of a URL to add, as submitted
+// with the add form, and returns them. Errors are formErrors.
+func monitoredURLFromForm(form url.Values) (MonitoredURL, error) {
+	urlStr := strings.TrimSpace(form.Get("url"))
+	if err := validateMonitoredURL(urlStr); err != nil {
+		return MonitoredURL{}, err
+	}
+	freq, schedule, err := parseFrequency(form.Get("frequency"))
+	if err != nil {
+		return MonitoredURL{}, err
+	}
+
+	offset := 0
+	if offsetStr := form.Get("offset"); offsetStr != "" {
+		offset, err = strconv.Atoi(offsetStr)
+		// Scheduled checks have no phase to shift.
+		if err != nil || offset < 0 || time.Duration(offset)*time.Second >= freq || (schedule != "" && offset > 0) {
+			return MonitoredURL{}, formError("Invalid offset")
+		}
+	}
+	startAt, err := parseStartAt(form.Get("start_at"))
+	if err != nil {
+		return MonitoredURL{}, err
+	}
+	if startAt != "" && (schedule != "" || offset > 0) {
+		return MonitoredURL{}, formError("A start time can't be combined with a schedule or an offset")
+	}
+
+	confirmCount, confirmDelay := 0, 0
+	if v := form.Get("confirm_count"); v != "" {
+		confirmCount, err = strconv.Atoi(v)
+		if err != nil || confirmCount < 0 {
+			return MonitoredURL{}, formError("Invalid confirmation count")
+		}
+	}
+	if v := form.Get("confirm_delay"); v != "" {
+		confirmDelay, err = strconv.Atoi(v)
+		if err != nil || confirmDelay < 0 {
+			return MonitoredURL{}, formError("Invalid confirmation delay")
+		}
+	}
+
+	minChange := 0
+	if v := form.Get("min_change"); v != "" {
+		minChange, err = strconv.Atoi(v)
+		if err != nil || minChange < 0 {
+			return MonitoredURL{}, formError("Invalid change threshold")
+		}
+	}
+
+	followSelector := strings.TrimSpace(form.Get("follow"))
+	if followSelector != "" {
+		if _, err := cascadia.Compile(followSelector); err != nil {
+			return MonitoredURL{}, formError("Invalid follow selector: " + err.Error())
+		}
+	}
+
+	selector := strings.TrimSpace(form.Get("selector"))
+	if selector != "" {
+		if _, err := cascadia.Compile(selector); err != nil {
+			return MonitoredURL{}, formError("Invalid selector: " + err.Error())
+		}
+	}
+
+	startMarker := strings.TrimSpace(form.Get("start_marker"))
+	endMarker := strings.TrimSpace(form.Get("end_marker"))
+
+	masks, err := parseMasks(form.Get("masks"))
+	if err != nil {
+		return MonitoredURL{}, formError("Invalid mask: " + err.Error())
+	}
+	blockMarkers, err := parseMasks(form.Get("block_markers"))
+	if err != nil {
+		return MonitoredURL{}, formError("Invalid block marker: " + err.Error())
+	}
+
+	jsonPath := strings.TrimSpace(form.Get("json_path"))
+	if jsonPath != "" {
+		if _, err := splitJSONPath(jsonPath); err != nil {
+			return MonitoredURL{}, formError("Invalid JSON path: " + err.Error())
+		}
+	}
+
+	regions, err := parseRegions(form.Get("regions"))
+	if err != nil {
+		return MonitoredURL{}, formError("Invalid regions: " + err.Error())
+	}
+
+	condition := strings.TrimSpace(form.Get("condition"))
+	if condition != "" {
+		if _, err := parseCondition(condition); err != nil {
+			return MonitoredURL{}, formError("Invalid condition: " + err.Error())
+		}
+	}
+
+	profile := form.Get("profile")
+	if profile == "" {
+		profile = defaultProfileName
+	}
+	var exists int
+	if err := db.QueryRow("SELECT 1 FROM fetch_profiles WHERE name = ?", profile).Scan(&exists); err != nil {
+		return MonitoredURL{}, formError("Unknown fetch profile")
+	}
+
+	mode := form.Get("mode")
+	switch mode {
+	case "":
+		mode = extractModeBody
+	case extractModeBody, extractModeText, extractModeRaw, extractModeJSONLD:
+	default:
+		return MonitoredURL{}, formError("Invalid extraction mode")
+	}
+
+	method, body, bodyType, err := parseRequest(form.Get("method"), form.Get("request_body"), form.Get("body_type"))
+	if err != nil {
+		return MonitoredURL{}, formError("Invalid request: " + err.Error())
+	}
 
-	// Read the push notifications setting.
-	pushVal := 0
-	if r.FormValue("push") != "" {
-		pushVal = 1
+	pushover, err := parsePushoverOptions(form.Get("pushover_priority"), form.Get("pushover_sound"), form.Get("pushover_retry"), form.Get("pushover_expire"))
+	if err != nil {
+		return MonitoredURL{}, formError("Invalid Pushover options: " + err.Error())
 	}
 
-	// Serialize this write using the same mutex.
-	mu.Lock()
-	res, err := db.Exec("INSERT INTO monitored_urls (url, frequency, push_enabled) VALUES (?, ?, ?)", urlStr, freq, pushVal)
-	mu.Unlock()
+	clientCert, clientKey, err := parseClientCertificate(form.Get("client_cert"), form.Get("client_key"))
+	if err != nil {
+		return MonitoredURL{}, formError("Invalid client certificate: " + err.Error())
+	}
+
+	return MonitoredURL{
+		URL:                 urlStr,
+		Frequency:           freq,
+		Schedule:            schedule,
+		Channels:            filterChannels(form["channels"]),
+		ExtractMode:         mode,
+		Offset:              time.Duration(offset) * time.Second,
+		StartAt:             startAt,
+		FollowSelector:      followSelector,
+		Profile:             profile,
+		Condition:           condition,
+		ConfirmCount:        confirmCount,
+		ConfirmDelay:        time.Duration(confirmDelay) * time.Second,
+		Regions:             regions,
+		Selector:            selector,
+		StartMarker:         startMarker,
+		EndMarker:           endMarker,
+		NormalizeWhitespace: form.Get("normalize_whitespace") != "",
+		MinChange:           minChange,
+		Headers:             parseHeaderLines(form.Get("headers")),
+		JSONPath:            jsonPath,
+		Tags:                parseTags(form.Get("tags")),
+		NoRedirects:         form.Get("no_redirects") != "",
+		Masks:               masks,
+		BlockMarkers:        blockMarkers,
+		Screenshot:          form.Get("screenshot") != "",
+		StoreRaw:            form.Get("store_raw") != "",
+		WatchTitle:          form.Get("watch_title") != "",
+		Method:              method,
+		RequestBody:         body,
+		BodyType:            bodyType,
+		Pushover:            pushover,
+		InsecureSkipVerify:  form.Get("insecure") != "",
+		ClientCert:          clientCert,
+		ClientKey:           clientKey,
+		UserAgent:           strings.TrimSpace(form.Get("user_agent")),
+	}, nil
+}
+
+// editURLHandler changes the address, frequency and, if given, the request
+// method, body and headers, TLS settings, tags, notification channels,
+// Pushover options, masks and block markers of a monitored URL and restarts its monitoring, keeping its
+// snapshots and last check time.
+func editURLHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	urlStr := strings.TrimSpace(r.FormValue("url"))
+	if err := validateMonitoredURL(urlStr); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	freq, schedule, err := parseFrequency(r.FormValue("frequency"))
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m, err := loadMonitoredURL(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "URL not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	// Scheduled checks have no phase to shift, as when adding a URL.
+	if schedule != "" && m.Offset > 0 {
+		http.Error(w, "A URL with an offset can't be checked on a schedule", http.StatusBadRequest)
+		return
+	}
+	if schedule == "" && m.Offset >= freq {
+		http.Error(w, "Frequency must be longer than the URL's offset", http.StatusBadRequest)
+		return
+	}
+	if schedule != "" && m.StartAt != "" {
+		http.Error(w, "A URL with a start time can't be checked on a schedule", http.StatusBadRequest)
+		return
+	}
+	var dup *duplicateURLError
+	if err := checkDuplicateURL(db, urlStr, m.Selector, id); errors.As(err, &dup) {
+		http.Error(w, fmt.Sprintf("This URL is already monitored (id %d)", dup.ID), http.StatusConflict)
+		return
+	} else if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	id, err := res.LastInsertId()
-	if err == nil {
-		m := MonitoredURL{
-			ID:          int(id),
-			URL:         urlStr,
-			Frequency:   time.Duration(freq) * time.Second,
-			PushEnabled: pushVal == 1,
+	headers := m.Headers
+	if _, ok := r.Form["headers"]; ok {
+		headers = parseHeaderLines(r.FormValue("headers"))
+	}
+	encodedHeaders, err := encodeHeaders(headers)
+	if err != nil {
+		http.Error(w, "Invalid headers", http.StatusBadRequest)
+		return
+	}
+	tags := m.Tags
+	if _, ok := r.Form["tags"]; ok {
+		tags = parseTags(r.FormValue("tags"))
+	}
+	masks := m.Masks
+	if _, ok := r.Form["masks"]; ok {
+		if masks, err = parseMasks(r.FormValue("masks")); err != nil {
+			http.Error(w, "Invalid mask: "+err.Error(), http.StatusBadRequest)
+			return
 		}
-		go monitorURL(m)
+	}
+	blockMarkers := m.BlockMarkers
+	if _, ok := r.Form["block_markers"]; ok {
+		if blockMarkers, err = parseMasks(r.FormValue("block_markers")); err != nil {
+			http.Error(w, "Invalid block marker: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	method, body, bodyType := m.Method, m.RequestBody, m.BodyType
+	if _, ok := r.Form["method"]; ok {
+		if method, body, bodyType, err = parseRequest(r.FormValue("method"), r.FormValue("request_body"), r.FormValue("body_type")); err != nil {
+			http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	insecure, clientCert, clientKey := m.InsecureSkipVerify, m.ClientCert, m.ClientKey
+	if r.FormValue("set_tls") != "" {
+		insecure = r.FormValue("insecure") != ""
+		if clientCert, clientKey, err = parseClientCertificate(r.FormValue("client_cert"), r.FormValue("client_key")); err != nil {
+			http.Error(w, "Invalid client certificate: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	pushover := m.Pushover
+	if _, ok := r.Form["pushover_priority"]; ok {
+		if pushover, err = parsePushoverOptions(r.FormValue("pushover_priority"), r.FormValue("pushover_sound"), r.FormValue("pushover_retry"), r.FormValue("pushover_expire")); err != nil {
+			http.Error(w, "Invalid Pushover options: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	// Unchecked checkboxes aren't submitted, so the form marks that it
+	// carries the channels.
+	channels := m.Channels
+	if r.FormValue("set_channels") != "" {
+		channels = filterChannels(r.Form["channels"])
+	}
+
+	_, err = writeDB.Exec("UPDATE monitored_urls SET url = ?, frequency = ?, schedule = ?, headers = ?, tags = ?, channels = ?, masks = ?, block_markers = ?, method = ?, request_body = ?, body_type = ?, pushover_priority = ?, pushover_sound = ?, pushover_retry = ?, pushover_expire = ?, insecure_skip_verify = ?, client_cert = ?, client_key = ? WHERE id = ?",
+		urlStr, int(freq/time.Second), schedule, encodedHeaders, formatTags(tags), formatChannels(channels), formatMasks(masks), formatMasks(blockMarkers), method, body, bodyType, pushover.Priority, pushover.Sound, pushover.Retry, pushover.Expire, boolToInt(insecure), clientCert, clientKey, id)
+	if isUniqueViolation(err) {
+		http.Error(w, "This URL is already monitored", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// The new settings may change what the page looks like, so its next
+	// fetch mustn't be skipped as not modified.
+	if err := forgetValidators(id); err != nil {
+		slog.Error("Error clearing validators", "url_id", id, "error", err)
+	}
+
+	m.URL = urlStr
+	m.Frequency = freq
+	m.Schedule = schedule
+	m.Headers = headers
+	m.Tags = tags
+	m.Channels = channels
+	m.Masks = masks
+	m.BlockMarkers = blockMarkers
+	m.Method, m.RequestBody, m.BodyType = method, body, bodyType
+	m.Pushover = pushover
+	m.InsecureSkipVerify, m.ClientCert, m.ClientKey = insecure, clientCert, clientKey
+	if !m.Paused {
+		m.logger().Info("Restarting monitoring", "event", "restart", "frequency", m.Frequency, "schedule", m.Schedule)
+		startMonitor(m)
 	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
@@ -113,18 +586,24 @@ func deleteURLHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mu.Lock()
-	_, err = db.Exec("DELETE FROM monitored_urls WHERE id = ?", id)
-	if err != nil {
-		mu.Unlock()
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
+	// Stop monitoring first so that no snapshot is saved mid-delete.
+	stopMonitor(id)
+	files, err := snapshotFiles(id)
+	if err == nil {
+		err = deleteMonitoredURL(id)
 	}
-	_, err = db.Exec("DELETE FROM url_snapshots WHERE url_id = ?", id)
-	mu.Unlock()
 	if err != nil {
-		log.Printf("Error deleting snapshots for URL id %d: %v", id, err)
+		slog.Error("Error deleting URL", "url_id", id, "error", err)
+		// Nothing was deleted, so carry on monitoring.
+		if m, err := loadMonitoredURL(id); err == nil && !m.Paused {
+			startMonitor(m)
+		}
+		http.Error(w, "Could not delete the URL; nothing was changed", http.StatusInternalServerError)
+		return
 	}
+	removeSnapshotFiles(files)
+	forgetChangeSummary(id)
+	forgetCooldown(id)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
@@ -137,15 +616,33 @@ func historyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var urlStr string
-	err = db.QueryRow("SELECT url FROM monitored_urls WHERE id = ?", id).Scan(&urlStr)
+	m, err := loadMonitoredURL(id)
 	if err != nil {
 		http.Error(w, "URL not found", http.StatusNotFound)
 		return
 	}
 
+	page, pageSize := 1, defaultHistoryPageSize
+	if v := r.URL.Query().Get("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			http.Error(w, "Invalid page", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("pageSize"); v != "" {
+		if pageSize, err = strconv.Atoi(v); err != nil || pageSize < 1 || pageSize > maxHistoryPageSize {
+			http.Error(w, "Invalid page size", http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Updated query to fetch id, timestamp, and content.
-	rows, err := db.Query("SELECT id, timestamp, content FROM url_snapshots WHERE url_id = ? ORDER BY timestamp DESC", id)
+	// Each region has its own history; the primary fetch uses the empty region.
+	// One snapshot beyond the page is read so that the last snapshot on the
+	// page can link to its diff with the first snapshot of the next page.
+	region := r.URL.Query().Get("region")
+	rows, err := db.Query("SELECT id, timestamp, content, content_path, compressed, manual, status_code, content_length, fetch_duration_ms, final_url, screenshot IS NOT NULL, content_type, raw IS NOT NULL, title, initial FROM url_snapshots WHERE url_id = ? AND region = ? ORDER BY timestamp DESC LIMIT ? OFFSET ?",
+		id, region, pageSize+1, (page-1)*pageSize)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
@@ -156,11 +653,18 @@ func historyHandler(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var snap Snapshot
 		var ts time.Time
-		var content string // use a temporary string variable
-		if err := rows.Scan(&snap.ID, &ts, &content); err != nil {
+		var contentCol, contentPath sql.NullString
+		var compressed bool
+		var durationMS int64
+		if err := rows.Scan(&snap.ID, &ts, &contentCol, &contentPath, &compressed, &snap.Manual, &snap.StatusCode, &snap.ContentLength, &durationMS, &snap.FinalURL, &snap.HasScreenshot, &snap.ContentType, &snap.HasRaw, &snap.Title, &snap.Initial); err != nil {
 			continue
 		}
-		snap.Timestamp = ts.Format(time.RFC1123)
+		snap.FetchDuration = time.Duration(durationMS) * time.Millisecond
+		content, err := snapshotContent(contentCol, contentPath, compressed)
+		if err != nil {
+			slog.Error("Error reading snapshot", "snapshot_id", snap.ID, "error", err)
+		}
+		snap.Timestamp = ts.Local().Format(time.RFC1123)
 		// Mark the content as trusted HTML.
 		snap.Content = template.HTML(content)
 		snapshots = append(snapshots, snap)
@@ -169,20 +673,158 @@ func historyHandler(w http.ResponseWriter, r *http.Request) {
 	// Build DiffSnapshot list: each snapshot (except the last) gets a link to diff with the next snapshot.
 	var diffSnaps []DiffSnapshot
 	for i, snap := range snapshots {
-		ds := DiffSnapshot{Snapshot: snap}
+		ds := DiffSnapshot{Snapshot: snap, Index: (page-1)*pageSize + i}
 		if i < len(snapshots)-1 {
 			ds.NextID = snapshots[i+1].ID
+			ds.TitleChanged = snap.Title != snapshots[i+1].Title
 		}
 		diffSnaps = append(diffSnaps, ds)
 	}
 
-	w.Header().Set("Content-Type", "text/html")
+	// The compact view replaces snapshot content with one-line summaries.
+	view := r.URL.Query().Get("view")
+	compact := view == "compact"
+	if compact {
+		for i := range diffSnaps {
+			if diffSnaps[i].Snapshot.Initial {
+				diffSnaps[i].Summary = "initial capture"
+			} else if i+1 < len(diffSnaps) {
+				older := string(diffSnaps[i+1].Snapshot.Content)
+				diffSnaps[i].Summary = computeDiffStats(older, string(diffSnaps[i].Snapshot.Content)).String()
+			} else {
+				diffSnaps[i].Summary = "first snapshot"
+			}
+		}
+	}
+
 	hv := HistoryView{
-		URL:       urlStr,
-		Snapshots: diffSnaps,
+		ID:       id,
+		URL:      m.URL,
+		Region:   region,
+		Regions:  m.Regions,
+		Page:     page,
+		ReadOnly: readOnly,
 	}
-	if err := historyTmpl.Execute(w, hv); err != nil {
-		log.Printf("Template execution error: %v", err)
+	if len(diffSnaps) > pageSize {
+		diffSnaps = diffSnaps[:pageSize]
+		hv.NextURL = historyURL(id, region, view, page+1, pageSize)
+	}
+	if page > 1 {
+		hv.PrevURL = historyURL(id, region, view, page-1, pageSize)
+	}
+	hv.Snapshots = diffSnaps
+	if region == "" && page == 1 {
+		if a, err := loadCheckActivity(id); err != nil {
+			slog.Error("Error reading checks", "url_id", id, "error", err)
+		} else if a.Checks > 0 {
+			hv.Activity = &a
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	tmpl := historyTmpl
+	if compact {
+		tmpl = historyCompactTmpl
+	}
+	if err := tmpl.Execute(w, hv); err != nil {
+		slog.Error("Template execution error", "path", r.URL.Path, "error", err)
+	}
+}
+
+const (
+	// defaultHistoryPageSize is the number of snapshots shown per history
+	// page unless pageSize says otherwise.
+	defaultHistoryPageSize = 25
+	maxHistoryPageSize     = 500
+)
+
+// historyURL returns the path of a history page.
+func historyURL(id int, region, view string, page, pageSize int) string {
+	q := url.Values{}
+	q.Set("id", strconv.Itoa(id))
+	if region != "" {
+		q.Set("region", region)
+	}
+	if view != "" {
+		q.Set("view", view)
+	}
+	q.Set("page", strconv.Itoa(page))
+	if pageSize != defaultHistoryPageSize {
+		q.Set("pageSize", strconv.Itoa(pageSize))
+	}
+	return "/history?" + q.Encode()
+}
+
+// compareHandler diffs two snapshots selected on the history page by
+// redirecting to diffHandler with the older snapshot first.
+func compareHandler(w http.ResponseWriter, r *http.Request) {
+	idStrs := r.URL.Query()["ids"]
+	if len(idStrs) != 2 {
+		http.Error(w, "Select exactly two snapshots to compare", http.StatusBadRequest)
+		return
+	}
+	var ids [2]int
+	var urlIDs [2]int
+	var timestamps [2]string
+	for i, idStr := range idStrs {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid id", http.StatusBadRequest)
+			return
+		}
+		ids[i] = id
+		err = db.QueryRow("SELECT url_id, timestamp FROM url_snapshots WHERE id = ?", id).Scan(&urlIDs[i], &timestamps[i])
+		if err != nil {
+			http.Error(w, "Snapshot not found", http.StatusNotFound)
+			return
+		}
+	}
+	if ids[0] == ids[1] {
+		http.Error(w, "Select two different snapshots to compare", http.StatusBadRequest)
+		return
+	}
+	if urlIDs[0] != urlIDs[1] {
+		http.Error(w, "Snapshots belong to different URLs", http.StatusBadRequest)
+		return
+	}
+
+	if timestamps[1] < timestamps[0] || (timestamps[1] == timestamps[0] && ids[1] < ids[0]) {
+		ids[0], ids[1] = ids[1], ids[0]
+	}
+	http.Redirect(w, r, "/diff?id1="+strconv.Itoa(ids[0])+"&id2="+strconv.Itoa(ids[1]), http.StatusSeeOther)
+}
+
+// diffSlots limits how many diffs are computed at once; diffing is the most
+// CPU- and memory-intensive operation the server performs. It is sized from
+// the -max-concurrent-diffs flag in main.
+var diffSlots chan struct{}
+
+// diffQueueTimeout is how long a diff request waits for a free slot before
+// the server gives up with 503 Service Unavailable.
+const diffQueueTimeout = 10 * time.Second
+
+// acquireDiffSlot blocks until a diff slot is free, ctx is done, or
+// diffQueueTimeout passes. It reports whether a slot was acquired; the
+// caller must then call releaseDiffSlot.
+func acquireDiffSlot(ctx context.Context) bool {
+	if diffSlots == nil {
+		return true
+	}
+	timer := time.NewTimer(diffQueueTimeout)
+	defer timer.Stop()
+	select {
+	case diffSlots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func releaseDiffSlot() {
+	if diffSlots != nil {
+		<-diffSlots
 	}
 }
 
@@ -201,41 +843,117 @@ func diffHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var content1, content2 string
-	err = db.QueryRow("SELECT content FROM url_snapshots WHERE id = ?", id1).Scan(&content1)
-	if err != nil {
-		http.Error(w, "Snapshot id1 not found", http.StatusNotFound)
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = diffModeChar
+	} else if !isDiffMode(mode) {
+		http.Error(w, "Invalid mode", http.StatusBadRequest)
 		return
 	}
-	err = db.QueryRow("SELECT content FROM url_snapshots WHERE id = ?", id2).Scan(&content2)
-	if err != nil {
-		http.Error(w, "Snapshot id2 not found", http.StatusNotFound)
-		return
+	view := r.URL.Query().Get("view")
+	// With source=raw the stored response bodies are compared instead of
+	// the extracted content.
+	raw := r.URL.Query().Get("source") == "raw"
+	// All renderings share the cache, told apart by the key's mode.
+	cacheMode := mode
+	if view == diffViewSplit {
+		cacheMode += "/" + diffViewSplit
+	}
+	if raw {
+		cacheMode += "/raw"
 	}
+	diffHTML, ok := cachedDiff(id1, id2, cacheMode)
+	if !ok {
+		load := loadSnapshotContent
+		if raw {
+			load = loadSnapshotRaw
+		}
+		content1, err := load(id1)
+		if err == errNoRaw {
+			http.Error(w, "Snapshot id1 was stored without its raw page", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Snapshot id1 not found", http.StatusNotFound)
+			return
+		}
+		content2, err := load(id2)
+		if err == errNoRaw {
+			http.Error(w, "Snapshot id2 was stored without its raw page", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Snapshot id2 not found", http.StatusNotFound)
+			return
+		}
 
-	dmp := diffmatchpatch.New()
-	diffs := dmp.DiffMain(content1, content2, true)
-	dmp.DiffCleanupSemantic(diffs)
-	diffHTML := dmp.DiffPrettyHtml(diffs)
+		if !acquireDiffSlot(r.Context()) {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Too many diffs in progress; try again shortly", http.StatusServiceUnavailable)
+			return
+		}
+		diffHTML = renderDiff(diffContents(mode, content1, content2), view == diffViewSplit)
+		releaseDiffSlot()
+		storeDiff(id1, id2, cacheMode, diffHTML)
+	}
 
 	// Convert the diffHTML string to template.HTML so it won't be escaped.
-	data := struct {
-		ID1      int
-		ID2      int
-		DiffHTML template.HTML
-	}{
-		ID1:      id1,
-		ID2:      id2,
+	data := DiffView{
+		Heading:  fmt.Sprintf("Diff between snapshot %d and %d", id1, id2),
+		Path:     "/diff",
+		Params:   map[string]string{"id1": strconv.Itoa(id1), "id2": strconv.Itoa(id2)},
+		Mode:     mode,
+		Modes:    diffModes,
+		Split:    view == diffViewSplit,
+		Raw:      raw,
 		DiffHTML: template.HTML(diffHTML),
 	}
 
 	w.Header().Set("Content-Type", "text/html")
 	if err := diffTmpl.Execute(w, data); err != nil {
-		log.Printf("Template execution error: %v", err)
+		slog.Error("Template execution error", "path", r.URL.Path, "error", err)
 	}
 }
 
-func togglePushHandler(w http.ResponseWriter, r *http.Request) {
+// deleteMonitoredURL deletes a URL along with its snapshots, their cached
+// diffs and search index entries, its checks, its last check time and its
+// stored validators, all in one transaction.
+func deleteMonitoredURL(id int) error {
+	tx, err := writeDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM monitored_urls WHERE id = ?", id); err != nil {
+		return err
+	}
+	if err := invalidateURLDiffs(tx, id); err != nil {
+		return err
+	}
+	if err := unindexURLSnapshots(tx, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM url_snapshots WHERE url_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM url_checks WHERE url_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM notifications WHERE url_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM url_validators WHERE url_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM url_last_check WHERE url_id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// togglePauseHandler pauses or resumes monitoring of a URL. Paused URLs keep
+// their history but are not fetched. Resuming a URL paused for failing too
+// often starts its failure count afresh, so that it gets another
+// -max-failures checks.
+func togglePauseHandler(w http.ResponseWriter, r *http.Request) {
 	idStr := r.URL.Query().Get("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
@@ -243,26 +961,367 @@ func togglePushHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var current int
-	err = db.QueryRow("SELECT push_enabled FROM monitored_urls WHERE id = ?", id).Scan(&current)
+	m, err := loadMonitoredURL(id)
 	if err != nil {
 		http.Error(w, "URL not found", http.StatusNotFound)
 		return
 	}
 
-	// Toggle: if current is 1, switch to 0; otherwise switch to 1.
-	newVal := 1
-	if current != 0 {
-		newVal = 0
+	m.Paused = !m.Paused
+	_, err = writeDB.Exec("UPDATE monitored_urls SET paused = ?, consecutive_failures = CASE WHEN auto_paused THEN 0 ELSE consecutive_failures END, auto_paused = 0 WHERE id = ?", boolToInt(m.Paused), id)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if m.Paused {
+		m.logger().Info("Pausing monitoring", "event", "pause")
+		stopMonitor(id)
+	} else {
+		m.logger().Info("Resuming monitoring", "event", "resume")
+		startMonitor(m)
 	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
 
-	mu.Lock()
-	_, err = db.Exec("UPDATE monitored_urls SET push_enabled = ? WHERE id = ?", newVal, id)
-	mu.Unlock()
+// latestDiffHandler redirects to the diff between the two most recent
+// snapshots of a URL. If fewer than two snapshots exist there is nothing to
+// compare, so it redirects to the URL's history instead.
+func latestDiffHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := r.URL.Query().Get("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query("SELECT id FROM url_snapshots WHERE url_id = ? AND region = '' ORDER BY timestamp DESC LIMIT 2", id)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var snapID int
+		if err := rows.Scan(&snapID); err != nil {
+			continue
+		}
+		ids = append(ids, snapID)
+	}
 
+	if len(ids) < 2 {
+		http.Redirect(w, r, "/history?id="+strconv.Itoa(id), http.StatusSeeOther)
+		return
+	}
+	// ids[0] is the newest snapshot; diff from the older one to it.
+	http.Redirect(w, r, "/diff?id1="+strconv.Itoa(ids[1])+"&id2="+strconv.Itoa(ids[0]), http.StatusSeeOther)
+}
+
+// checkNowHandler triggers an immediate check of a monitored URL, outside
+// its regular schedule.
+func checkNowHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	if !requestCheck(id) {
+		http.Error(w, "URL not being monitored", http.StatusNotFound)
+		return
+	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
+
+// checkAllHandler triggers an immediate check of every URL being
+// monitored, such as after a network outage, and reports how many were
+// triggered.
+func checkAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	n := requestAllChecks()
+	slog.Info("Checking all URLs now", "event", "check_all", "count", n)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "Triggered checks of %s.\n", plural(n, "URL", "URLs"))
+}
+
+// forceSnapshotHandler fetches a URL and stores its current content as a
+// manual snapshot, whether or not it has changed. No notification is sent.
+func forceSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	idStr := r.FormValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	m, err := loadMonitoredURL(id)
+	if err != nil {
+		http.Error(w, "URL not found", http.StatusNotFound)
+		return
+	}
+
+	m.logger().Info("Taking manual snapshot", "event", "manual_snapshot")
+	res, err := fetchContent(r.Context(), m)
+	if err != nil {
+		m.logger().Warn("Error fetching URL", "event", "fetch_failed", "status", fetchErrorStatus(err), "error", err)
+		http.Error(w, "Error fetching URL", http.StatusBadGateway)
+		return
+	}
+	snap := res.snapshot(m)
+	snap.Manual = true
+	if err := db.QueryRow("SELECT NOT EXISTS (SELECT 1 FROM url_snapshots WHERE url_id = ? AND region = '')", id).Scan(&snap.Initial); err != nil {
+		slog.Error("Error reading snapshots", "url_id", id, "error", err)
+	}
+	snapshotID, err := saveSnapshot(snap)
+	if err == nil && m.Screenshot {
+		saveScreenshot(r.Context(), m, snapshotID)
+	}
+
+	http.Redirect(w, r, "/history?id="+strconv.Itoa(id), http.StatusSeeOther)
+}
+
+// NotificationPreview is the change notification previewNotificationHandler
+// renders: what would be sent, and over which channels.
+type NotificationPreview struct {
+	Title    string   `json:"title"`
+	Message  string   `json:"message"`
+	Channels []string `json:"channels"`
+	// Pushover is set only if the URL notifies over Pushover.
+	Pushover *PushoverOptions `json:"pushover,omitempty"`
+}
+
+// previewNotificationHandler renders the change notification that would be
+// sent for the URL with the posted id or, without one, for the URL
+// configuration posted with the add form, using the current time as sample
+// data. Nothing is sent.
+func previewNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid form")
+		return
+	}
+
+	var m MonitoredURL
+	if idStr := r.FormValue("id"); idStr != "" {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		m, err = loadMonitoredURL(id)
+		if err == sql.ErrNoRows {
+			writeJSONError(w, http.StatusNotFound, "URL not found")
+			return
+		} else if err != nil {
+			slog.Error("Error loading URL for notification preview", "url_id", id, "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+	} else {
+		form := r.PostForm
+		// Let the preview be shown before the URL and its frequency, which
+		// the notification doesn't depend on, have been entered.
+		if strings.TrimSpace(form.Get("url")) == "" {
+			form.Set("url", "https://example.com/")
+		}
+		if strings.TrimSpace(form.Get("frequency")) == "" {
+			form.Set("frequency", "3600")
+		}
+		var err error
+		if m, err = monitoredURLFromForm(form); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	preview := NotificationPreview{
+		Title:    notificationTitle,
+		Message:  notificationMessage(NotificationData{URL: m.URL, URLID: m.ID, ChangeTime: time.Now()}),
+		Channels: nonNilStrings(m.Channels),
+	}
+	if hasChannel(m.Channels, channelPushover) {
+		preview.Pushover = &m.Pushover
+	}
+	writeJSON(w, http.StatusOK, preview)
+}
+
+// profilesHandler lists the fetch profiles and, on POST, creates or updates
+// one. Headers are entered one per line as "Name: value".
+func profilesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		p := FetchProfile{
+			Name:               strings.TrimSpace(r.FormValue("name")),
+			UserAgent:          strings.TrimSpace(r.FormValue("user_agent")),
+			Proxy:              strings.TrimSpace(r.FormValue("proxy")),
+			InsecureSkipVerify: r.FormValue("insecure") != "",
+			Headers:            parseHeaderLines(r.FormValue("headers")),
+		}
+		if p.Name == "" {
+			http.Error(w, "Profile name is required", http.StatusBadRequest)
+			return
+		}
+		if timeoutStr := r.FormValue("timeout"); timeoutStr != "" {
+			timeout, err := strconv.Atoi(timeoutStr)
+			if err != nil || timeout < 0 {
+				http.Error(w, "Invalid timeout", http.StatusBadRequest)
+				return
+			}
+			p.Timeout = time.Duration(timeout) * time.Second
+		}
+		if p.Proxy != "" {
+			if _, err := parseProxyURL(p.Proxy); err != nil {
+				http.Error(w, "Invalid proxy URL: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := saveFetchProfile(p); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/profiles", http.StatusSeeOther)
+		return
+	}
+
+	profiles, err := listFetchProfiles()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := profilesTmpl.Execute(w, profiles); err != nil {
+		slog.Error("Template execution error", "path", r.URL.Path, "error", err)
+	}
+}
+
+// parseHeaderLines parses "Name: value" lines into a header map, skipping
+// blank or malformed lines.
+func parseHeaderLines(text string) map[string]string {
+	headers := map[string]string{}
+	for _, line := range strings.Split(text, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			continue
+		}
+		headers[name] = strings.TrimSpace(value)
+	}
+	return headers
+}
+
+// formatHeaderLines formats a header map as sorted "Name: value" lines, the
+// inverse of parseHeaderLines.
+func formatHeaderLines(headers map[string]string) string {
+	lines := make([]string, 0, len(headers))
+	for name, value := range headers {
+		lines = append(lines, name+": "+value)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// replayHandler serves a page that steps through a URL's snapshots, oldest
+// first. The page loads its snapshot list from replayFeedHandler and shows
+// each snapshot via replayFrameHandler in a sandboxed iframe.
+func replayHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := r.URL.Query().Get("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var urlStr string
+	err = db.QueryRow("SELECT url FROM monitored_urls WHERE id = ?", id).Scan(&urlStr)
+	if err != nil {
+		http.Error(w, "URL not found", http.StatusNotFound)
+		return
+	}
+
+	data := struct {
+		ID  int
+		URL string
+	}{
+		ID:  id,
+		URL: urlStr,
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := replayTmpl.Execute(w, data); err != nil {
+		slog.Error("Template execution error", "path", r.URL.Path, "error", err)
+	}
+}
+
+// replayFeedHandler returns the ids and timestamps of a URL's snapshots as
+// JSON, ordered oldest first.
+func replayFeedHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := r.URL.Query().Get("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query("SELECT id, timestamp FROM url_snapshots WHERE url_id = ? AND region = '' ORDER BY timestamp ASC", id)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type frame struct {
+		ID        int    `json:"id"`
+		Timestamp string `json:"timestamp"`
+	}
+	frames := []frame{}
+	for rows.Next() {
+		var f frame
+		var ts time.Time
+		if err := rows.Scan(&f.ID, &ts); err != nil {
+			continue
+		}
+		f.Timestamp = ts.Local().Format(time.RFC1123)
+		frames = append(frames, f)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(frames); err != nil {
+		slog.Error("Error encoding replay feed", "error", err)
+	}
+}
+
+// replayFrameHandler serves the content of one snapshot for the replay
+// iframe. The Content-Security-Policy sandbox keeps scripts in the stored
+// page from running even if the frame is opened directly.
+func replayFrameHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := r.URL.Query().Get("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	content, err := loadSnapshotContent(id)
+	if err != nil {
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	if _, err := io.WriteString(w, content); err != nil {
+		slog.Error("Error writing snapshot", "snapshot_id", id, "error", err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func postForm(h http.HandlerFunc, target string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func TestEditURLFrequency(t *testing.T) {
+	openTestDB(t)
+	for _, freq := range []string{"10", "30", "60", "120", "300", "86400"} {
+		m := addTestURL(t, MonitoredURL{URL: "https://example.com/" + freq, Paused: true})
+		w := postForm(editURLHandler, "/edit", url.Values{
+			"id":        {itoa(m.ID)},
+			"url":       {m.URL},
+			"frequency": {freq},
+		})
+		if freq == "10" {
+			// Below the default -min-frequency of 30 seconds.
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "at least 30 seconds") {
+				t.Errorf("editing to %ss: got %d %q, want the -min-frequency error", freq, w.Code, w.Body.String())
+			}
+			continue
+		}
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("editing to %ss: got %d %q, want a redirect", freq, w.Code, w.Body.String())
+			continue
+		}
+		got, err := loadMonitoredURL(m.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := mustSeconds(t, freq); got.Frequency != want {
+			t.Errorf("editing to %ss stored a frequency of %v", freq, got.Frequency)
+		}
+	}
+}
+
+func TestEditURLFrequencyWithOffset(t *testing.T) {
+	openTestDB(t)
+	m := addTestURL(t, MonitoredURL{URL: "https://example.com/", Paused: true, Frequency: time.Hour, Offset: time.Minute})
+	w := postForm(editURLHandler, "/edit", url.Values{"id": {itoa(m.ID)}, "url": {m.URL}, "frequency": {"60"}})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "longer than the URL's offset") {
+		t.Errorf("got %d %q, want the offset error", w.Code, w.Body.String())
+	}
+	w = postForm(editURLHandler, "/edit", url.Values{"id": {itoa(m.ID)}, "url": {m.URL}, "frequency": {"120"}})
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("got %d %q, want a redirect", w.Code, w.Body.String())
+	}
+}
+
+func TestEditURLScheduleWithOffset(t *testing.T) {
+	openTestDB(t)
+	m := addTestURL(t, MonitoredURL{URL: "https://example.com/", Paused: true, Frequency: time.Hour, Offset: time.Minute})
+	w := postForm(editURLHandler, "/edit", url.Values{"id": {itoa(m.ID)}, "url": {m.URL}, "frequency": {"0 9 * * *"}})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "offset can't be checked on a schedule") {
+		t.Errorf("got %d %q, want the schedule and offset error", w.Code, w.Body.String())
+	}
+	got, err := loadMonitoredURL(m.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Schedule != "" {
+		t.Errorf("stored schedule %q", got.Schedule)
+	}
+
+	m = addTestURL(t, MonitoredURL{URL: "https://example.com/other", Paused: true, Frequency: time.Hour})
+	w = postForm(editURLHandler, "/edit", url.Values{"id": {itoa(m.ID)}, "url": {m.URL}, "frequency": {"0 9 * * *"}})
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("without an offset: got %d %q, want a redirect", w.Code, w.Body.String())
+	}
+}
+
+func TestEditURLValidatesURL(t *testing.T) {
+	openTestDB(t)
+	m := addTestURL(t, MonitoredURL{URL: "https://example.com/", Paused: true})
+	for _, bad := range []string{"", "ftp://example.com/", "example.com", "https://"} {
+		w := postForm(editURLHandler, "/edit", url.Values{"id": {itoa(m.ID)}, "url": {bad}, "frequency": {"3600"}})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("editing to %q: got %d, want %d", bad, w.Code, http.StatusBadRequest)
+		}
+	}
+	w := postForm(editURLHandler, "/edit", url.Values{"id": {itoa(m.ID)}, "url": {"  https://example.com/new  "}, "frequency": {"3600"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("got %d %q, want a redirect", w.Code, w.Body.String())
+	}
+	got, err := loadMonitoredURL(m.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.URL != "https://example.com/new" {
+		t.Errorf("stored %q, want the trimmed URL", got.URL)
+	}
+}
+
+func decodePreview(t *testing.T, w *httptest.ResponseRecorder) NotificationPreview {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %q, want %d", w.Code, w.Body.String(), http.StatusOK)
+	}
+	var p NotificationPreview
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPreviewNotification(t *testing.T) {
+	openTestDB(t)
+	saved := messageTemplate
+	t.Cleanup(func() { messageTemplate = saved })
+	messageTemplate = template.Must(template.New("message").Parse("{{.URL}} (id {{.URLID}}) changed"))
+
+	// From the add form, with the channels and Pushover options posted.
+	p := decodePreview(t, postForm(previewNotificationHandler, "/previewNotification", url.Values{
+		"url":               {"https://example.com/form"},
+		"frequency":         {"3600"},
+		"channels":          {channelPushover, channelEmail},
+		"pushover_priority": {"1"},
+		"pushover_sound":    {"siren"},
+	}))
+	if p.Message != "https://example.com/form (id 0) changed" {
+		t.Errorf("form preview message = %q", p.Message)
+	}
+	if strings.Join(p.Channels, ",") != channelPushover+","+channelEmail {
+		t.Errorf("form preview channels = %v", p.Channels)
+	}
+	if p.Pushover == nil || p.Pushover.Priority != 1 || p.Pushover.Sound != "siren" {
+		t.Errorf("form preview Pushover options = %+v", p.Pushover)
+	}
+
+	// A blank form still previews, but invalid settings are reported.
+	p = decodePreview(t, postForm(previewNotificationHandler, "/previewNotification", url.Values{}))
+	if p.Message != "https://example.com/ (id 0) changed" {
+		t.Errorf("blank form preview message = %q", p.Message)
+	}
+	w := postForm(previewNotificationHandler, "/previewNotification", url.Values{"url": {"ftp://example.com/"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid URL: got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// From a stored URL, ignoring anything else posted.
+	m := addTestURL(t, MonitoredURL{URL: "https://example.com/stored", Paused: true, Channels: []string{channelNtfy}})
+	p = decodePreview(t, postForm(previewNotificationHandler, "/previewNotification", url.Values{
+		"id":       {itoa(m.ID)},
+		"url":      {"https://example.com/form"},
+		"channels": {channelPushover},
+	}))
+	if want := "https://example.com/stored (id " + itoa(m.ID) + ") changed"; p.Message != want {
+		t.Errorf("stored URL preview message = %q, want %q", p.Message, want)
+	}
+	if strings.Join(p.Channels, ",") != channelNtfy || p.Pushover != nil {
+		t.Errorf("stored URL preview is sent via %v with Pushover options %+v, want ntfy alone", p.Channels, p.Pushover)
+	}
+	w = postForm(previewNotificationHandler, "/previewNotification", url.Values{"id": {itoa(m.ID + 1)}})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown id: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Health states stored in monitored_urls.health_state.
+const (
+	healthUnknown = -1
+	healthDown    = 0
+	healthUp      = 1
+)
+
+// statusError is returned for responses with a non-2xx status code.
+type statusError struct {
+	StatusCode int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("HTTP %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// fetchErrorStatus returns the HTTP status a fetch failed with, or 0 if it
+// failed before getting a response.
+func fetchErrorStatus(err error) int {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.StatusCode
+	}
+	return 0
+}
+
+// failureWarnThreshold is the number of consecutive failed checks after
+// which the index page flags a URL.
+const failureWarnThreshold = 3
+
+// maxFailures is the number of consecutive failed checks after which a URL
+// is paused, or 0 to keep checking it however often it fails. Set with
+// -max-failures.
+var maxFailures = 0
+
+// recordFailures counts consecutive failed fetches of a URL, keeping the
+// last error, and resets the count once a fetch succeeds. It returns the
+// count.
+func recordFailures(urlID int, fetchErr error) int {
+	var err error
+	failures := 0
+	if fetchErr != nil {
+		err = writeDB.QueryRow("UPDATE monitored_urls SET consecutive_failures = consecutive_failures + 1, last_error = ? WHERE id = ? RETURNING consecutive_failures", fetchErr.Error(), urlID).Scan(&failures)
+	} else {
+		_, err = writeDB.Exec("UPDATE monitored_urls SET consecutive_failures = 0, last_error = '' WHERE id = ? AND consecutive_failures != 0", urlID)
+	}
+	if err != nil {
+		slog.Error("Error saving failure count", "url_id", urlID, "error", err)
+	}
+	return failures
+}
+
+// pauseFailingURL pauses m once it has failed maxFailures times in a row,
+// marking it as paused for failing so that resuming it starts a new count,
+// and sends a notification saying so. It reports whether m was paused.
+func pauseFailingURL(m MonitoredURL, failures int, fetchErr error) bool {
+	if maxFailures <= 0 || failures < maxFailures {
+		return false
+	}
+	if _, err := writeDB.Exec("UPDATE monitored_urls SET paused = 1, auto_paused = 1 WHERE id = ?", m.ID); err != nil {
+		m.logger().Error("Error pausing URL", "error", err)
+		return false
+	}
+	m.logger().Warn("Pausing monitoring after repeated failures", "event", "auto_pause", "failures", failures, "error", fetchErr)
+	stopMonitor(m.ID)
+	message := fmt.Sprintf("Monitoring of %s was paused after %d consecutive failed checks; the last failed with: %v. Resume it from the index page once it is fixed.", m.URL, failures, fetchErr)
+	sendNotification(m.ID, enabledChannels(m.ID), loadPushoverOptions(m.ID), "URL Monitoring Paused", message, m.URL)
+	return true
+}
+
+// checkHealth records whether the latest fetch of m succeeded and sends a
+// notification when the URL goes down or comes back up. The first check
+// only records the state.
+func checkHealth(m MonitoredURL, fetchErr error) {
+	state := healthUp
+	if fetchErr != nil {
+		state = healthDown
+	}
+
+	var previous int
+	if err := db.QueryRow("SELECT health_state FROM monitored_urls WHERE id = ?", m.ID).Scan(&previous); err != nil {
+		m.logger().Error("Error reading health state", "error", err)
+		return
+	}
+	if previous == state {
+		return
+	}
+
+	_, err := writeDB.Exec("UPDATE monitored_urls SET health_state = ? WHERE id = ?", state, m.ID)
+	if err != nil {
+		m.logger().Error("Error saving health state", "error", err)
+	}
+
+	if previous == healthUnknown {
+		return
+	}
+	var title, message string
+	now := time.Now().Format(time.RFC1123)
+	var se *statusError
+	switch {
+	case fetchErr == nil:
+		title = "URL Back Up"
+		message = fmt.Sprintf("%s is back up (%s)", m.URL, now)
+	case errors.As(fetchErr, &se):
+		title = "URL Down: " + se.Error()
+		message = fmt.Sprintf("%s returned %s (%s)", m.URL, se, now)
+	default:
+		title = "URL Unreachable"
+		message = fmt.Sprintf("%s is unreachable: %v (%s)", m.URL, fetchErr, now)
+	}
+	m.logger().Info(title, "event", "health_change", "up", fetchErr == nil)
+	sendNotification(m.ID, enabledChannels(m.ID), loadPushoverOptions(m.ID), title, message, m.URL)
+}
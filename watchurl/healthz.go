@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// startTime is when the server started, for reporting uptime.
+var startTime = time.Now()
+
+// healthzTimeout bounds the database ping of a health check.
+const healthzTimeout = 2 * time.Second
+
+// Healthz is the JSON body of /healthz responses.
+type Healthz struct {
+	Status         string `json:"status"`
+	UptimeSeconds  int64  `json:"uptime_seconds"`
+	ActiveMonitors int    `json:"active_monitors"`
+	Error          string `json:"error,omitempty"`
+}
+
+// healthzHandler reports whether the server can reach its database, with
+// 200 OK if so and 503 Service Unavailable if not. It is exempt from Basic
+// Auth so that supervisors can probe it.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	h := Healthz{
+		Status:         "ok",
+		UptimeSeconds:  int64(time.Since(startTime) / time.Second),
+		ActiveMonitors: activeMonitors(),
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), healthzTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		h.Status = "unavailable"
+		h.Error = err.Error()
+		writeJSON(w, http.StatusServiceUnavailable, h)
+		return
+	}
+	writeJSON(w, http.StatusOK, h)
+}
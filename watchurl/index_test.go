@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// queryPlan returns the details of the EXPLAIN QUERY PLAN rows for query.
+func queryPlan(t *testing.T, query string, args ...interface{}) string {
+	t.Helper()
+	rows, err := db.Query("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var details []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			t.Fatal(err)
+		}
+		details = append(details, detail)
+	}
+	return strings.Join(details, "\n")
+}
+
+func TestSnapshotQueriesUseIndex(t *testing.T) {
+	openTestDB(t)
+	start := time.Now().Add(-24 * time.Hour)
+	for u := 0; u < 20; u++ {
+		m := addTestURL(t, MonitoredURL{URL: fmt.Sprintf("https://example.com/%d", u), Paused: true})
+		for i := 0; i < 25; i++ {
+			_, err := saveSnapshot(NewSnapshot{URLID: m.ID, Content: fmt.Sprintf("<p>%d</p>", i), ContentType: "text/html", Timestamp: start.Add(time.Duration(i) * time.Minute)})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := writeDB.Exec("ANALYZE"); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := map[string]string{
+		"latest snapshot": "SELECT id, content_hash, title FROM url_snapshots WHERE url_id = ? AND region = ? ORDER BY timestamp DESC LIMIT 1",
+		"history page":    "SELECT id, timestamp FROM url_snapshots WHERE url_id = ? AND region = ? ORDER BY timestamp DESC LIMIT ? OFFSET ?",
+		"last updated":    "SELECT MAX(timestamp) FROM url_snapshots WHERE url_id = ?",
+	}
+	for name, query := range queries {
+		args := []interface{}{5, "", 20, 0}[:strings.Count(query, "?")]
+		plan := queryPlan(t, query, args...)
+		if !strings.Contains(plan, "idx_url_snapshots_url_id_timestamp") {
+			t.Errorf("%s doesn't use idx_url_snapshots_url_id_timestamp:\n%s", name, plan)
+		}
+		if strings.Contains(plan, "TEMP B-TREE") {
+			t.Errorf("%s sorts its rows:\n%s", name, plan)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// extractJSONLD returns the canonicalized JSON-LD structured data found in the
+// <script type="application/ld+json"> blocks of the input HTML. Every block is
+// decoded and re-encoded with sorted keys; blocks (and top-level arrays) are
+// merged into a single array sorted by their canonical encoding so that the
+// output does not depend on the order blocks appear in the page. The second
+// return value is false if the page contains no valid JSON-LD.
+func extractJSONLD(input string) (string, bool) {
+	doc, err := html.Parse(strings.NewReader(input))
+	if err != nil {
+		return "", false
+	}
+
+	var items []interface{}
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "script" && isJSONLDScript(n) {
+			var text strings.Builder
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == html.TextNode {
+					text.WriteString(c.Data)
+				}
+			}
+			var v interface{}
+			if err := json.Unmarshal([]byte(text.String()), &v); err == nil {
+				// A block may hold a single object or an array of objects.
+				if arr, ok := v.([]interface{}); ok {
+					items = append(items, arr...)
+				} else {
+					items = append(items, v)
+				}
+			}
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	if len(items) == 0 {
+		return "", false
+	}
+
+	// json.Marshal sorts map keys, which gives each item a canonical form.
+	encoded := make([]string, 0, len(items))
+	for _, item := range items {
+		b, err := json.Marshal(item)
+		if err != nil {
+			continue
+		}
+		encoded = append(encoded, string(b))
+	}
+	sort.Strings(encoded)
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte("["+strings.Join(encoded, ",")+"]"), "", "  "); err != nil {
+		return "", false
+	}
+	return buf.String(), true
+}
+
+// isJSONLDScript reports whether the <script> node n holds JSON-LD data.
+func isJSONLDScript(n *html.Node) bool {
+	for _, a := range n.Attr {
+		if strings.ToLower(a.Key) == "type" {
+			mediaType := strings.TrimSpace(strings.SplitN(a.Val, ";", 2)[0])
+			return strings.EqualFold(mediaType, "application/ld+json")
+		}
+	}
+	return false
+}
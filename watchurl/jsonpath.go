@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// isJSONType reports whether contentType is a JSON media type.
+func isJSONType(contentType string) bool {
+	mt := mediaType(contentType)
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
+
+// extractJSON pretty-prints a JSON document with sorted object keys. If path
+// is set, only the value at that path (see lookupJSONPath) is kept; a path
+// that matches nothing is logged and the whole document is used instead. It
+// reports false if input is not valid JSON.
+func extractJSON(m MonitoredURL, input string) (string, bool) {
+	var doc interface{}
+	dec := json.NewDecoder(strings.NewReader(input))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return "", false
+	}
+	if m.JSONPath != "" {
+		if v, ok := lookupJSONPath(doc, m.JSONPath); ok {
+			doc = v
+		} else {
+			m.logger().Warn("JSON path matched nothing; using the whole document", "event", "json_path_miss", "json_path", m.JSONPath)
+		}
+	}
+	// json.MarshalIndent sorts map keys, so formatting-only changes vanish.
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
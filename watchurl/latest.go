@@ -0,0 +1,58 @@
+package main
+
+import "database/sql"
+
+// latestSnapshot tracks the newest snapshot of a URL from one region (""
+// for the primary fetch) for change detection. Every refresh looks the
+// snapshot up in the database, so snapshots taken, re-extracted or deleted
+// elsewhere are noticed, but its content is only read again when the
+// snapshot itself has changed.
+type latestSnapshot struct {
+	region string
+	// id and storedHash identify the snapshot and its content_hash as last
+	// seen in the database; id is 0 if there is no snapshot.
+	id         int64
+	storedHash string
+	// hash is the comparisonHash of the snapshot's content for hashType.
+	hash     string
+	hashType string
+	// title is the snapshot's page title.
+	title string
+}
+
+// refresh brings hash up to date for comparing content of contentType from
+// m with the latest snapshot.
+func (l *latestSnapshot) refresh(m MonitoredURL, contentType string) error {
+	var id int64
+	var storedHash, title string
+	var content, contentPath sql.NullString
+	var compressed bool
+	err := db.QueryRow("SELECT id, content_hash, title FROM url_snapshots WHERE url_id = ? AND region = ? ORDER BY timestamp DESC LIMIT 1", m.ID, l.region).Scan(&id, &storedHash, &title)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	l.title = title
+	if id == l.id && storedHash == l.storedHash && contentType == l.hashType && l.hash != "" {
+		return nil
+	}
+	if id != 0 {
+		if err := db.QueryRow("SELECT content, content_path, compressed FROM url_snapshots WHERE id = ?", id).Scan(&content, &contentPath, &compressed); err != nil {
+			return err
+		}
+	}
+	c, err := snapshotContent(content, contentPath, compressed)
+	if err != nil {
+		return err
+	}
+	l.id, l.storedHash = id, storedHash
+	l.hash, l.hashType = comparisonHash(m, contentType, c), contentType
+	return nil
+}
+
+// content returns the content of the latest snapshot, or "" if there is none.
+func (l *latestSnapshot) content() (string, error) {
+	if l.id == 0 {
+		return "", nil
+	}
+	return loadSnapshotContent(int(l.id))
+}
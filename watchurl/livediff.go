@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// liveDiffTimeout bounds the fetch of a live diff, as the page waits for
+// it.
+const liveDiffTimeout = time.Minute
+
+// liveDiffHandler fetches a monitored URL now and diffs the result with its
+// latest snapshot, to preview whether it has changed. Nothing is saved and
+// no one is notified. Without a snapshot to compare with, the whole of the
+// current content is shown as new.
+func liveDiffHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = diffModeChar
+	} else if !isDiffMode(mode) {
+		http.Error(w, "Invalid mode", http.StatusBadRequest)
+		return
+	}
+	split := r.URL.Query().Get("view") == diffViewSplit
+
+	m, err := loadMonitoredURL(id)
+	if err != nil {
+		http.Error(w, "URL not found", http.StatusNotFound)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), liveDiffTimeout)
+	defer cancel()
+	res, err := fetchContent(ctx, m)
+	if err != nil {
+		m.logger().Warn("Error fetching URL for a live diff", "event", "fetch_failed", "status", fetchErrorStatus(err), "error", err)
+		http.Error(w, "Could not fetch the URL: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	var last latestSnapshot
+	var old string
+	err = last.refresh(m, res.ContentType)
+	if err == nil {
+		old, err = last.content()
+	}
+	if err != nil {
+		slog.Error("Error reading last snapshot", "url_id", id, "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	heading := fmt.Sprintf("Changes to %s since snapshot %d", m.URL, last.id)
+	if last.id == 0 {
+		heading = fmt.Sprintf("Current content of %s, which has no snapshot yet", m.URL)
+	} else if comparisonHash(m, res.ContentType, res.Content) == last.hash {
+		heading = fmt.Sprintf("No change to %s since snapshot %d", m.URL, last.id)
+	}
+	if !acquireDiffSlot(r.Context()) {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Too many diffs in progress; try again shortly", http.StatusServiceUnavailable)
+		return
+	}
+	diffs := []diffmatchpatch.Diff{{Type: diffmatchpatch.DiffInsert, Text: res.Content}}
+	if last.id != 0 {
+		diffs = diffContents(mode, old, res.Content)
+	}
+	diffHTML := renderDiff(diffs, split)
+	releaseDiffSlot()
+
+	data := DiffView{
+		Heading:  heading,
+		Path:     "/livediff",
+		Params:   map[string]string{"id": strconv.Itoa(id)},
+		Mode:     mode,
+		Modes:    diffModes,
+		Split:    split,
+		Live:     true,
+		DiffHTML: template.HTML(diffHTML),
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := diffTmpl.Execute(w, data); err != nil {
+		slog.Error("Template execution error", "path", r.URL.Path, "error", err)
+	}
+}
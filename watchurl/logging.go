@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// setupLogging replaces the default logger with a log/slog handler writing
+// to stderr in the given format, "text" (key=value pairs) or "json", that
+// drops records below level ("debug", "info", "warn" or "error"). Messages
+// still logged through the log package go to the same handler at info
+// level.
+func setupLogging(format, level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q: must be debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid log format %q: must be text or json", format)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// logger returns a logger that tags its records with the URL's id and
+// address, so that one monitor's records can be picked out of many.
+func (m MonitoredURL) logger() *slog.Logger {
+	return slog.With("url_id", m.ID, "url", m.URL)
+}
+
+// statusRecorder is an http.ResponseWriter that remembers the status code
+// written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests wraps next so that every request is logged at debug level
+// with its method, path, response status and duration.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		slog.Debug("Handled request", "event", "request", "method", r.Method, "path", r.URL.Path,
+			"status", rec.status, "duration", time.Since(start).Round(time.Microsecond))
+	})
+}
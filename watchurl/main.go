@@ -2,17 +2,26 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"embed"
+	"errors"
 	"flag"
+	"fmt"
 	"html/template"
-	"io/ioutil"
+	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
+	"github.com/dustin/go-humanize"
 	"golang.org/x/net/html"
 	_ "modernc.org/sqlite"
 )
@@ -22,27 +31,230 @@ var templatesFS embed.FS
 
 var (
 	// Load the templates from the embedded filesystem.
-	indexTmpl   = template.Must(template.ParseFS(templatesFS, "templates/index.html"))
-	historyTmpl = template.Must(template.ParseFS(templatesFS, "templates/history.html"))
-	diffTmpl    = template.Must(template.ParseFS(templatesFS, "templates/diff.html"))
+	indexTmpl    = template.Must(template.ParseFS(templatesFS, "templates/index.html"))
+	historyTmpl  = template.Must(template.ParseFS(templatesFS, "templates/history.html"))
+	diffTmpl     = template.Must(template.ParseFS(templatesFS, "templates/diff.html"))
+	profilesTmpl = template.Must(template.ParseFS(templatesFS, "templates/profiles.html"))
+	replayTmpl   = template.Must(template.ParseFS(templatesFS, "templates/replay.html"))
+	searchTmpl   = template.Must(template.ParseFS(templatesFS, "templates/search.html"))
+	bulkTmpl     = template.Must(template.ParseFS(templatesFS, "templates/bulk.html"))
+	statusTmpl   = template.Must(template.ParseFS(templatesFS, "templates/status.html"))
+
+	historyCompactTmpl = template.Must(template.ParseFS(templatesFS, "templates/history_compact.html"))
+	notificationsTmpl  = template.Must(template.ParseFS(templatesFS, "templates/notifications.html"))
 )
 
 // MonitoredURL represents a URL to be watched. Frequency is stored as a time.Duration (in nanoseconds).
 // When a user enters a frequency in seconds, it is converted by multiplying with time.Second.
 type MonitoredURL struct {
-	ID          int
-	URL         string
-	Frequency   time.Duration
-	PushEnabled bool
+	ID        int
+	URL       string
+	Frequency time.Duration
+	// Channels are the notification channels changes are sent to.
+	Channels []string
+	// Offset phase-shifts the checks of this URL within its frequency
+	// interval. Zero means checks are not aligned to any phase.
+	Offset time.Duration
+	// StartAt, if set, is a local time of day, "15:04", that the URL's
+	// checks are aligned to: they run at that time and every Frequency
+	// before and after it.
+	StartAt string
+	// FollowSelector, if set, is a CSS selector for a link on the fetched
+	// page whose target is fetched and compared instead of the page itself.
+	FollowSelector string
+	// ConfirmCount is how many times a detected change is re-checked,
+	// ConfirmDelay apart, before it is recorded. Zero records immediately.
+	ConfirmCount int
+	ConfirmDelay time.Duration
+	// Condition is an optional success predicate (see parseCondition);
+	// a notification is sent whenever its result flips.
+	Condition string
+	// Regions are additional vantage points the URL is fetched through on
+	// every check, so region-specific content can be detected.
+	Regions []Region
+	// Profile names the fetch profile holding the HTTP settings for this URL.
+	Profile string
+	// ExtractMode selects how fetched content is reduced before comparison
+	// (see extractContent).
+	ExtractMode string
+	// Selector, if set, is a CSS selector limiting the watched content to
+	// the matched elements of the page.
+	Selector string
+	// StartMarker and EndMarker, if set, limit the page to the text between
+	// them, markers excluded, before it is extracted; see betweenMarkers.
+	StartMarker, EndMarker string
+	// NormalizeWhitespace ignores whitespace-only differences when
+	// comparing content; snapshots still store the content as fetched.
+	NormalizeWhitespace bool
+	// MinChange is the number of changed characters a change must exceed
+	// to be recorded. Zero records every change.
+	MinChange int
+	// Paused URLs are not fetched until they are resumed.
+	Paused bool
+	// Headers are extra request headers for this URL, applied on top of
+	// those of its fetch profile.
+	Headers map[string]string
+	// JSONPath, if set, limits the watched content of JSON responses to the
+	// value at this path (see lookupJSONPath).
+	JSONPath string
+	// Tags group URLs on the index page; see parseTags.
+	Tags []string
+	// NoRedirects, if set, makes a redirect response the content that is
+	// watched instead of the page it points to, so that a page starting to
+	// redirect registers as a change.
+	NoRedirects bool
+	// Masks are regular expressions whose matches are replaced with a
+	// placeholder before content is compared and stored; see applyMasks.
+	Masks []string
+	// BlockMarkers are regular expressions, one of which matching a fetched
+	// page marks it as blocked rather than changed; see checkBlockMarkers.
+	BlockMarkers []string
+	// Screenshot, if set, also stores a screenshot with each snapshot; see
+	// captureScreenshot.
+	Screenshot bool
+	// UserAgent, if set, overrides the User-Agent of the URL's fetch
+	// profile.
+	UserAgent string
+	// InsecureSkipVerify, if set, accepts any certificate from the server,
+	// even if the URL's fetch profile doesn't. ClientCert and ClientKey are
+	// the paths of a client certificate for servers that require one.
+	InsecureSkipVerify bool
+	ClientCert         string
+	ClientKey          string
+	// Schedule, if set, is a cron expression (see parseCron) the URL is
+	// checked on instead of every Frequency, which then holds the shortest
+	// gap between its firings.
+	Schedule string
+	// StoreRaw keeps the unmodified response body with each snapshot of
+	// this URL, as -store-raw does for all URLs.
+	StoreRaw bool
+	// WatchTitle counts a change of the page's <title> alone as a change.
+	WatchTitle bool
+	// Method is the HTTP method the URL is fetched with, GET or POST, and
+	// RequestBody the body sent with a POST, of media type BodyType.
+	Method      string
+	RequestBody string
+	BodyType    string
+	// Pushover sets the priority and sound of the URL's Pushover messages.
+	Pushover PushoverOptions
 }
 
 // MonitoredURLView is used to pass URL data (with frequency in seconds) to the index template.
 type MonitoredURLView struct {
-	ID          int
-	URL         string
-	Frequency   int
-	LastUpdated string
-	PushEnabled bool
+	ID                  int
+	URL                 string
+	Frequency           int
+	LastUpdated         string
+	Channels            []string
+	ExtractMode         string
+	Offset              int
+	StartAt             string
+	FollowSelector      string
+	Selector            string
+	StartMarker         string
+	EndMarker           string
+	NormalizeWhitespace bool
+	MinChange           int
+	Paused              bool
+	// AutoPaused is set if the URL was paused for failing too often.
+	AutoPaused bool
+	// Headers holds the URL's extra request headers as "Name: value" lines.
+	Headers   string
+	JSONPath  string
+	Profile   string
+	Condition string
+	// ConditionState is one of the condition* constants.
+	ConditionState int
+	ConfirmCount   int
+	ConfirmDelay   int
+	Regions        string
+	Tags           []string
+	NoRedirects    bool
+	// Masks holds the URL's masks one per line, and BlockMarkers its block
+	// markers.
+	Masks        string
+	BlockMarkers string
+	// LastChange summarizes the most recent change; see latestChangeSummary.
+	LastChange string
+	Screenshot bool
+	UserAgent  string
+	// ConsecutiveFailures counts the failed checks since the last
+	// successful one, the latest of which failed with LastError.
+	ConsecutiveFailures int
+	LastError           string
+	// LastCheck and NextCheck say when the URL was last fetched and when
+	// its next check is due; see checkTimes.
+	LastCheck string
+	NextCheck string
+	Schedule  string
+	StoreRaw  bool
+	// SnapshotCount is the number of snapshots of the page, and
+	// SnapshotBytes the size of the content and raw pages of those and of
+	// its region snapshots as stored in the database. Content moved to
+	// files by -snapshot-dir is not counted.
+	SnapshotCount int
+	SnapshotBytes int64
+	// Title is the page title in the latest snapshot.
+	Title       string
+	WatchTitle  bool
+	Method      string
+	RequestBody string
+	BodyType    string
+	Pushover    PushoverOptions
+	// InsecureSkipVerify, ClientCert and ClientKey are the URL's TLS
+	// settings.
+	InsecureSkipVerify bool
+	ClientCert         string
+	ClientKey          string
+}
+
+// SnapshotSize returns SnapshotBytes in human-readable form.
+func (u MonitoredURLView) SnapshotSize() string {
+	return humanize.Bytes(uint64(u.SnapshotBytes))
+}
+
+// FrequencyValue returns the URL's frequency as the edit form accepts it:
+// its schedule, or else its frequency in seconds.
+func (u MonitoredURLView) FrequencyValue() string {
+	if u.Schedule != "" {
+		return u.Schedule
+	}
+	return strconv.Itoa(u.Frequency)
+}
+
+// Failing reports whether the URL has failed often enough in a row to be
+// flagged.
+func (u MonitoredURLView) Failing() bool {
+	return u.ConsecutiveFailures >= failureWarnThreshold
+}
+
+// TagList returns the URL's tags in the comma-separated form the edit form
+// accepts.
+func (u MonitoredURLView) TagList() string {
+	return strings.Join(u.Tags, ", ")
+}
+
+// ChannelChoices returns every notification channel and whether the URL
+// uses it.
+func (u MonitoredURLView) ChannelChoices() []ChannelChoice {
+	return channelChoices(u.Channels)
+}
+
+// IndexView contains the monitored URLs and summary metrics for the index page.
+type IndexView struct {
+	URLs []MonitoredURLView
+	// Tag is the tag the URLs are filtered by, if any.
+	Tag string
+	// Sorts are the links that sort the URLs, and Sorted is set if one of
+	// them is in effect.
+	Sorts  []IndexSort
+	Sorted bool
+	// ReadOnly hides the controls that change anything.
+	ReadOnly bool
+	// Channels lists every notification channel, for the add form.
+	Channels []string
+	Profiles []FetchProfile
+	Metrics  Metrics
 }
 
 // Snapshot represents a URL snapshot for display.
@@ -50,6 +262,47 @@ type Snapshot struct {
 	ID        int
 	Timestamp string
 	Content   template.HTML
+	// Manual is set for snapshots captured on demand rather than on change.
+	Manual bool
+	// StatusCode, ContentLength and FetchDuration describe the HTTP response
+	// the snapshot was taken from. They are zero for older snapshots.
+	StatusCode    int
+	ContentLength int64
+	FetchDuration time.Duration
+	// FinalURL is the address the content came from after redirects.
+	FinalURL string
+	// HasScreenshot is set if a screenshot was stored with the snapshot,
+	// and HasRaw if its raw response body was.
+	HasScreenshot bool
+	HasRaw        bool
+	// ContentType is the media type of Content; empty for older snapshots,
+	// which are HTML.
+	ContentType string
+	// Title is the page's title; empty if it had none or wasn't HTML.
+	Title string
+	// Initial is set for the first capture of the URL.
+	Initial bool
+}
+
+// Display returns the content for showing in a page: HTML as it is, and
+// other content types as preformatted text.
+func (s Snapshot) Display() template.HTML {
+	if isHTMLType(s.ContentType) {
+		return s.Content
+	}
+	return template.HTML("<pre>" + html.EscapeString(string(s.Content)) + "</pre>")
+}
+
+// ResponseSummary describes the HTTP response a snapshot was taken from, or
+// returns "" if that was not recorded.
+func (s Snapshot) ResponseSummary() string {
+	if s.StatusCode == 0 {
+		return ""
+	}
+	if s.ContentType != "" {
+		return fmt.Sprintf("HTTP %d, %s, %s, fetched in %v", s.StatusCode, mediaType(s.ContentType), humanize.Bytes(uint64(s.ContentLength)), s.FetchDuration)
+	}
+	return fmt.Sprintf("HTTP %d, %s, fetched in %v", s.StatusCode, humanize.Bytes(uint64(s.ContentLength)), s.FetchDuration)
 }
 
 // DiffSnapshot is a helper struct for displaying diffs in the history view.
@@ -57,78 +310,446 @@ type DiffSnapshot struct {
 	Snapshot Snapshot
 	// NextID holds the id of the next (older) snapshot, if available.
 	NextID int
+	// Index is the snapshot's position in the full history, newest first.
+	Index int
+	// Summary describes the change from the older snapshot; it is only
+	// computed for the compact history view.
+	Summary string
+	// TitleChanged is set if the page title differs from the older
+	// snapshot's.
+	TitleChanged bool
 }
 
 // HistoryView contains the URL and its snapshots for the history page.
 type HistoryView struct {
-	URL       string
+	ID  int
+	URL string
+	// Region is the vantage point whose snapshots are shown; empty for the
+	// primary fetch.
+	Region    string
+	Regions   []Region
 	Snapshots []DiffSnapshot
+	// Page is the 1-based page number. PrevURL and NextURL link to the
+	// adjacent pages, if there are any.
+	Page             int
+	PrevURL, NextURL string
+	// Activity summarizes the recent checks; it is only loaded for the
+	// first page of the primary history.
+	Activity *CheckActivity
+	// ReadOnly hides the links that change data.
+	ReadOnly bool
 }
 
 var (
-	db *sql.DB
-	// mu protects concurrent writes to the database.
-	mu sync.Mutex
+	// db serves reads and writeDB, see openDatabase, writes.
+	db      *sql.DB
+	writeDB *sql.DB
+	// storeRaw enables storing the unmodified response body with snapshots.
+	storeRaw bool
 )
 
 func main() {
 	// Parse the port flag from the command line.
 	port := flag.String("port", "8080", "server port")
+	dbPath := flag.String("db", "./monitor.db", "path of the SQLite database file")
+	busyTimeout := flag.Duration("db-busy-timeout", 5*time.Second, "how long to wait for a lock held by another process using the database, such as the sqlite3 shell, before failing with SQLITE_BUSY")
+	journalMode := flag.String("db-journal-mode", "WAL", "SQLite journal mode: WAL, DELETE, TRUNCATE, PERSIST, MEMORY or OFF")
+	synchronous := flag.String("db-synchronous", "NORMAL", "SQLite synchronous setting: OFF, NORMAL, FULL or EXTRA")
+	flag.BoolVar(&readOnly, "readonly", false, "open the database read-only and serve a dashboard without monitoring or editing")
+	flag.StringVar(&snapshotDir, "snapshot-dir", "", "store snapshot content as files in this directory instead of in the database")
+	flag.Int64Var(&diffCacheBytes, "diff-cache-bytes", diffCacheBytes, "maximum total size of rendered diffs cached in the database (0 disables)")
+	maxDiffs := flag.Int("max-concurrent-diffs", 4, "maximum number of diffs computed at once (0 for no limit)")
+	maxFetches := flag.Int("max-concurrent-fetches", 10, "maximum number of URLs fetched at once (0 for no limit)")
+	flag.Int64Var(&maxBodyBytes, "max-body-bytes", maxBodyBytes, "give up on responses larger than this many bytes (0 for no limit)")
+	flag.BoolVar(&storeRaw, "store-raw", false, "also store the unmodified response body of each snapshot, so it can be re-extracted later")
+	flag.StringVar(&fallbackUserAgent, "user-agent", fallbackUserAgent, "User-Agent sent for URLs whose fetch profile doesn't set one")
+	flag.BoolVar(&respectRobots, "respect-robots", false, "skip URLs disallowed by their site's robots.txt and honor its Crawl-delay")
+	flag.StringVar(&defaultProxy, "proxy", "", "fetch through this HTTP or SOCKS5 proxy, e.g. socks5://localhost:1080, unless a URL's profile sets its own")
+	flag.IntVar(&maxIdleConns, "max-idle-conns", maxIdleConns, "maximum number of idle connections kept open for reuse by fetches (0 for no limit)")
+	flag.IntVar(&maxIdleConnsPerHost, "max-idle-conns-per-host", maxIdleConnsPerHost, "maximum number of idle connections kept open to each host")
+	flag.DurationVar(&idleConnTimeout, "idle-conn-timeout", idleConnTimeout, "close idle connections after this long (0 to keep them)")
+	flag.DurationVar(&fetchTimeout, "timeout", fetchTimeout, "default timeout for each fetch, used by profiles without their own (0 for none)")
+	flag.IntVar(&fetchRetries, "fetch-retries", fetchRetries, "retry fetches failing with a connection error or 5xx status this many times")
+	flag.DurationVar(&fetchRetryDelay, "fetch-retry-delay", fetchRetryDelay, "wait before the first fetch retry; doubled for each further retry")
+	flag.IntVar(&jitterPercent, "jitter", 0, "randomly vary check intervals by up to this percentage (0-99) to spread out fetches")
+	flag.IntVar(&maxSnapshotsPerURL, "max-snapshots-per-url", 0, "keep at most this many snapshots per URL and region (0 for no limit)")
+	flag.DurationVar(&maxSnapshotAge, "max-snapshot-age", 0, "delete snapshots older than this, e.g. 720h (0 for no limit)")
+	flag.BoolVar(&desktopNotify, "desktop-notify", false, "show notifications of URLs using the desktop channel on this machine (needs notify-send on Linux)")
+	flag.DurationVar(&minFrequency, "min-frequency", minFrequency, "refuse to check a URL more often than this (0 for no limit)")
+	flag.DurationVar(&maxFrequency, "max-frequency", maxFrequency, "refuse to check a URL less often than this (0 for no limit)")
+	flag.IntVar(&maxFailures, "max-failures", 0, "pause a URL after this many consecutive failed checks and notify once (0 to keep checking)")
+	flag.DurationVar(&maxNotificationAge, "max-notification-age", maxNotificationAge, "delete the record of notifications older than this (0 to keep them all)")
+	flag.DurationVar(&maxCheckAge, "max-check-age", maxCheckAge, "delete the record of checks older than this (0 to keep them all)")
+	flag.DurationVar(&notifyCooldown, "notify-cooldown", 0, "after notifying of a change to a URL, hold back further notifications for it this long, then send one summary (0 for none)")
+	exportFile := flag.String("export", "", "write all monitored URLs to this JSON file and exit")
+	exportSnapshots := flag.Bool("export-snapshots", false, "include snapshots in -export")
+	checkURL := flag.String("check-url", "", "fetch this URL once, print how it differs from its latest snapshot and exit, with status 1 if it changed")
+	importFile := flag.String("import", "", "add the monitored URLs (and snapshots) in this JSON file, as written by -export, before starting")
+	extraStrip := flag.String("strip-tags", "", "comma-separated element names to remove from pages before comparing, in addition to "+strings.Join(defaultStripTags, ", "))
+	migrateSnapshots := flag.Bool("migrate-snapshots", false, "move existing inline snapshot content into -snapshot-dir at startup")
+	logFormat := flag.String("log-format", "text", "log as text (key=value pairs) or json")
+	logLevel := flag.String("log-level", "info", "minimum level to log: debug, info, warn or error")
+	flag.BoolVar(&compressSnapshots, "compress-snapshots", false, "gzip the content of new snapshots stored in the database")
+	compressExisting := flag.Bool("compress-existing", false, "gzip the content of existing uncompressed snapshots in the database at startup")
+	configPath := flag.String("config", os.Getenv(configEnvPrefix+"CONFIG"), "read defaults for the other flags from this YAML file, e.g. \"port: 8080\" (flags and "+configEnvPrefix+"* environment variables take precedence)")
 	flag.Parse()
+	if err := applyConfig(*configPath); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	if err := setupLogging(*logFormat, *logLevel); err != nil {
+		log.Fatalf("Invalid logging flags: %v", err)
+	}
+	if jitterPercent < 0 || jitterPercent >= 100 {
+		log.Fatalf("Invalid -jitter %d: must be between 0 and 99", jitterPercent)
+	}
+	if defaultProxy != "" {
+		if _, err := parseProxyURL(defaultProxy); err != nil {
+			log.Fatalf("Invalid -proxy: %v", err)
+		}
+	}
+	if desktopNotify {
+		if _, err := desktopNotifyCommand(context.Background(), "", ""); err != nil {
+			slog.Warn("Desktop notifications will fail", "error", err)
+		}
+	}
+	addStripTags(*extraStrip)
+	loadMessageTemplate()
+	if *maxDiffs > 0 {
+		diffSlots = make(chan struct{}, *maxDiffs)
+	}
+	if *maxFetches > 0 {
+		fetchSlots = make(chan struct{}, *maxFetches)
+	}
+	if readOnly {
+		if *importFile != "" || *migrateSnapshots || *compressExisting {
+			log.Fatalf("-import, -migrate-snapshots and -compress-existing cannot be used with -readonly")
+		}
+		// Rendered diffs cannot be cached without writing to the database.
+		diffCacheBytes = 0
+	}
 
-	var err error
 	// Open (or create) the SQLite database file using modernc's pure Go driver.
-	db, err = sql.Open("sqlite", "./monitor.db")
+	dsn, err := databaseDSN(*dbPath, *busyTimeout, *journalMode, *synchronous)
 	if err != nil {
+		log.Fatal(err)
+	}
+	if err = openDatabase(dsn); err != nil {
 		log.Fatalf("Error opening database: %v", err)
 	}
-	// Set SQLite to wait up to 5000 milliseconds for a lock.
-	if _, err = db.Exec("PRAGMA busy_timeout = 5000"); err != nil {
-		log.Fatalf("Error setting busy timeout: %v", err)
+
+	if readOnly {
+		if err = checkSchemaCurrent(); err != nil {
+			log.Fatalf("Error opening database: %v", err)
+		}
+	} else {
+		// Initialize database tables.
+		if err = setupDatabase(); err != nil {
+			log.Fatalf("Error setting up database: %v", err)
+		}
 	}
-	if _, err = db.Exec("PRAGMA journal_mode=WAL;"); err != nil {
-		log.Fatalf("Error setting WAL mode: %v", err)
+	if *migrateSnapshots {
+		if err = migrateSnapshotsToFiles(); err != nil {
+			log.Fatalf("Error migrating snapshots to files: %v", err)
+		}
 	}
-	defer db.Close()
-
-	// Initialize database tables.
-	if err = setupDatabase(); err != nil {
-		log.Fatalf("Error setting up database: %v", err)
+	if *compressExisting {
+		if err = compressExistingSnapshots(); err != nil {
+			log.Fatalf("Error compressing snapshots: %v", err)
+		}
 	}
 
-	// Load monitored URLs from the database and start monitoring.
-	rows, err := db.Query("SELECT id, url, frequency, push_enabled FROM monitored_urls")
-	if err != nil {
-		log.Fatalf("Error querying monitored URLs: %v", err)
+	if *exportFile != "" {
+		if err = exportData(*exportFile, *exportSnapshots); err != nil {
+			log.Fatalf("Error exporting to %s: %v", *exportFile, err)
+		}
+		closeDatabase()
+		return
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var m MonitoredURL
-		var freqSeconds, pushInt int
-		if err := rows.Scan(&m.ID, &m.URL, &freqSeconds, &pushInt); err != nil {
-			log.Printf("Error scanning row: %v", err)
-			continue
+	if *checkURL != "" {
+		status := runCheckURL(*checkURL, os.Stdout)
+		closeDatabase()
+		os.Exit(status)
+	}
+	if *importFile != "" {
+		if err = importData(*importFile); err != nil {
+			log.Fatalf("Error importing from %s: %v", *importFile, err)
 		}
-		m.Frequency = time.Duration(freqSeconds) * time.Second
-		m.PushEnabled = pushInt != 0
-		go monitorURL(m)
+	}
+
+	if readOnly {
+		slog.Info("Read-only mode: not monitoring URLs", "event", "readonly")
+	} else {
+		startMonitors()
+		go runRetention()
 	}
 
 	// Setup HTTP handlers.
 	http.HandleFunc("/", indexHandler)
-	http.HandleFunc("/add", addURLHandler)
-	http.HandleFunc("/delete", deleteURLHandler)
+	http.HandleFunc("/add", writeHandler(addURLHandler))
+	http.HandleFunc("/bulkAdd", writeHandler(bulkAddHandler))
+	http.HandleFunc("/edit", writeHandler(editURLHandler))
+	http.HandleFunc("/delete", writeHandler(deleteURLHandler))
 	http.HandleFunc("/history", historyHandler)
 	http.HandleFunc("/diff", diffHandler)
-	http.HandleFunc("/togglePush", togglePushHandler)
+	http.HandleFunc("/screenshot", screenshotHandler)
+	http.HandleFunc("/raw", rawHandler)
+	http.HandleFunc("/snapshot", snapshotHandler)
+	http.HandleFunc("/snapshot/delete", writeHandler(deleteSnapshotHandler))
+	http.HandleFunc("/compare", compareHandler)
+	http.HandleFunc("/search", searchHandler)
+	http.HandleFunc("/togglePause", writeHandler(togglePauseHandler))
+	http.HandleFunc("/latestDiff", latestDiffHandler)
+	http.HandleFunc("/livediff", writeHandler(liveDiffHandler))
+	http.HandleFunc("/forceSnapshot", writeHandler(forceSnapshotHandler))
+	http.HandleFunc("/check", writeHandler(checkNowHandler))
+	http.HandleFunc("/check-all", writeHandler(checkAllHandler))
+	http.HandleFunc("/previewNotification", previewNotificationHandler)
+	http.HandleFunc("/profiles", writeMethodsHandler(profilesHandler))
+	http.HandleFunc("/replay", replayHandler)
+	http.HandleFunc("/replay/feed", replayFeedHandler)
+	http.HandleFunc("/replay/frame", replayFrameHandler)
+	http.HandleFunc("/admin/reextract", writeHandler(reextractHandler))
+	http.HandleFunc("/api/urls", writeMethodsHandler(apiURLsHandler))
+	http.HandleFunc("/api/snapshot", apiSnapshotHandler)
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/status", statusHandler)
+	http.HandleFunc("/notifications", notificationsHandler)
+	http.HandleFunc("/api/status", apiStatusHandler)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{Addr: ":" + *port, Handler: logRequests(requireBasicAuth(http.DefaultServeMux))}
+	go func() {
+		slog.Info("Server starting", "event", "start", "port", *port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	slog.Info("Shutting down", "event", "shutdown")
+
+	// Stop taking requests, then stop monitoring, letting in-flight writes
+	// finish, and only then close the database.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error shutting down server", "error", err)
+	}
+	stopAllMonitors(shutdownCtx)
+
+	if err := closeDatabase(); err != nil {
+		slog.Error("Error closing database", "error", err)
+	}
+}
+
+// shutdownTimeout bounds how long shutdown waits for in-flight requests and
+// checks to finish.
+const shutdownTimeout = 10 * time.Second
+
+// monitoredURLColumns lists the monitored_urls columns read by
+// scanMonitoredURL, in order.
+const monitoredURLColumns = "id, url, frequency, channels, extract_mode, offset_seconds, follow_selector, profile, condition, confirm_count, confirm_delay, regions, selector, normalize_whitespace, min_change, paused, headers, json_path, tags, no_redirects, masks, screenshot, user_agent, schedule, store_raw, watch_title, method, request_body, body_type, start_at, block_markers, start_marker, end_marker, pushover_priority, pushover_sound, pushover_retry, pushover_expire, insecure_skip_verify, client_cert, client_key"
+
+// scanMonitoredURL scans a row selected with monitoredURLColumns.
+func scanMonitoredURL(row interface{ Scan(...interface{}) error }) (MonitoredURL, error) {
+	var m MonitoredURL
+	var freqSeconds, offsetSeconds, confirmDelaySeconds, normalizeInt, pausedInt, noRedirectsInt, screenshotInt, storeRawInt, watchTitleInt int
+	var channels, regions, headers, tags, masks, blockMarkers string
+	if err := row.Scan(&m.ID, &m.URL, &freqSeconds, &channels, &m.ExtractMode, &offsetSeconds, &m.FollowSelector, &m.Profile, &m.Condition, &m.ConfirmCount, &confirmDelaySeconds, &regions, &m.Selector, &normalizeInt, &m.MinChange, &pausedInt, &headers, &m.JSONPath, &tags, &noRedirectsInt, &masks, &screenshotInt, &m.UserAgent, &m.Schedule, &storeRawInt, &watchTitleInt, &m.Method, &m.RequestBody, &m.BodyType, &m.StartAt, &blockMarkers, &m.StartMarker, &m.EndMarker, &m.Pushover.Priority, &m.Pushover.Sound, &m.Pushover.Retry, &m.Pushover.Expire, &m.InsecureSkipVerify, &m.ClientCert, &m.ClientKey); err != nil {
+		return m, err
+	}
+	m.NoRedirects = noRedirectsInt != 0
+	m.Screenshot = screenshotInt != 0
+	m.StoreRaw = storeRawInt != 0
+	m.WatchTitle = watchTitleInt != 0
+	m.Tags = parseTags(tags)
+	m.NormalizeWhitespace = normalizeInt != 0
+	m.Paused = pausedInt != 0
+	var err error
+	if m.Headers, err = decodeHeaders(headers); err != nil {
+		m.logger().Warn("Ignoring invalid headers", "error", err)
+	}
+	if m.Masks, err = parseMasks(masks); err != nil {
+		m.logger().Warn("Ignoring invalid masks", "error", err)
+	}
+	if m.BlockMarkers, err = parseMasks(blockMarkers); err != nil {
+		m.logger().Warn("Ignoring invalid block markers", "error", err)
+	}
+	m.ConfirmDelay = time.Duration(confirmDelaySeconds) * time.Second
+	if regions != "" {
+		var err error
+		if m.Regions, err = parseRegions(regions); err != nil {
+			m.logger().Warn("Ignoring invalid regions", "error", err)
+		}
+	}
+	m.Frequency = time.Duration(freqSeconds) * time.Second
+	m.Channels = parseChannels(channels)
+	m.Offset = time.Duration(offsetSeconds) * time.Second
+	return m, nil
+}
+
+// loadMonitoredURL reads the monitored URL with the given id.
+func loadMonitoredURL(id int) (MonitoredURL, error) {
+	return scanMonitoredURL(db.QueryRow("SELECT "+monitoredURLColumns+" FROM monitored_urls WHERE id = ?", id))
+}
+
+// addMonitoredURL inserts m, setting its ID, and starts monitoring it.
+func addMonitoredURL(m *MonitoredURL) error {
+	if err := insertMonitoredURL(writeDB, m); err != nil {
+		return err
+	}
+	startMonitor(*m)
+	return nil
+}
+
+// insertMonitoredURL inserts m through q and sets its ID. It returns a
+// *duplicateURLError if the URL is already monitored with the same selector.
+func insertMonitoredURL(q queryExecer, m *MonitoredURL) error {
+	if err := checkDuplicateURL(q, m.URL, m.Selector, 0); err != nil {
+		return err
+	}
+	if m.ExtractMode == "" {
+		m.ExtractMode = extractModeBody
+	}
+	if m.Profile == "" {
+		m.Profile = defaultProfileName
+	}
+	if m.Method == "" {
+		m.Method = http.MethodGet
+	}
+
+	headers, err := encodeHeaders(m.Headers)
+	if err != nil {
+		return err
+	}
+
+	res, err := q.Exec(`INSERT INTO monitored_urls
+        (url, frequency, channels, extract_mode, offset_seconds, follow_selector, profile, condition, confirm_count, confirm_delay, regions, selector, normalize_whitespace, min_change, headers, json_path, paused, tags, no_redirects, masks, screenshot, user_agent, schedule, store_raw, watch_title, method, request_body, body_type, start_at, block_markers, start_marker, end_marker, pushover_priority, pushover_sound, pushover_retry, pushover_expire, insecure_skip_verify, client_cert, client_key)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.URL, int(m.Frequency/time.Second), formatChannels(m.Channels), m.ExtractMode, int(m.Offset/time.Second), m.FollowSelector, m.Profile,
+		m.Condition, m.ConfirmCount, int(m.ConfirmDelay/time.Second), formatRegions(m.Regions), m.Selector, boolToInt(m.NormalizeWhitespace), m.MinChange, headers, m.JSONPath, boolToInt(m.Paused), formatTags(m.Tags), boolToInt(m.NoRedirects), formatMasks(m.Masks), boolToInt(m.Screenshot), m.UserAgent, m.Schedule, boolToInt(m.StoreRaw), boolToInt(m.WatchTitle), m.Method, m.RequestBody, m.BodyType, m.StartAt, formatMasks(m.BlockMarkers), m.StartMarker, m.EndMarker, m.Pushover.Priority, m.Pushover.Sound, m.Pushover.Retry, m.Pushover.Expire, boolToInt(m.InsecureSkipVerify), m.ClientCert, m.ClientKey)
+	if isUniqueViolation(err) {
+		// Added concurrently since the check above.
+		if dupErr := checkDuplicateURL(q, m.URL, m.Selector, 0); dupErr != nil {
+			return dupErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	m.ID = int(id)
+	return nil
+}
+
+// boolToInt converts b to the 0/1 integer stored for boolean columns.
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// timestampLayout is how times are stored in the database: RFC 3339 in UTC,
+// with a fixed number of fractional digits so that stored times sort as
+// strings in time order.
+const timestampLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// dbTime formats t for storing in, or comparing with, a DATETIME column.
+func dbTime(t time.Time) string {
+	return t.UTC().Format(timestampLayout)
+}
+
+// parseStoredTime parses a time stored with dbTime that the driver returns
+// as a string, such as the result of MAX(timestamp).
+func parseStoredTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// databaseDSN returns the data source name of the database file at path.
+// Each connection waits up to busyTimeout for a lock held by another, and
+// transactions take the write lock up front so that two of them can't
+// deadlock upgrading from a read. The default WAL
+// journal lets reads proceed alongside a write, and with it synchronous
+// NORMAL is safe from corruption while syncing far less than FULL.
+func databaseDSN(path string, busyTimeout time.Duration, journalMode, synchronous string) (string, error) {
+	switch strings.ToUpper(journalMode) {
+	case "WAL", "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "OFF":
+	default:
+		return "", fmt.Errorf("invalid -db-journal-mode %q", journalMode)
+	}
+	switch strings.ToUpper(synchronous) {
+	case "OFF", "NORMAL", "FULL", "EXTRA":
+	default:
+		return "", fmt.Errorf("invalid -db-synchronous %q", synchronous)
+	}
+	if busyTimeout < 0 {
+		return "", fmt.Errorf("invalid -db-busy-timeout %v", busyTimeout)
+	}
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)", path, busyTimeout.Milliseconds())
+	if readOnly {
+		// The journal mode is a property of the file, which a read-only
+		// connection can't change.
+		return dsn + "&mode=ro", nil
+	}
+	return dsn + fmt.Sprintf("&_pragma=journal_mode(%s)&_pragma=synchronous(%s)&_txlock=immediate", journalMode, synchronous), nil
+}
+
+// openDatabase opens db and writeDB on the database with the given data
+// source name. writeDB has a single connection, so the process's writes
+// queue for it instead of contending for SQLite's write lock: the busy
+// handler of a connection waiting for the lock polls rather than queues, so
+// under load some writers would wait out any busy timeout and fail with
+// SQLITE_BUSY. The busy timeout then only matters when another process,
+// such as the sqlite3 shell, holds the lock.
+func openDatabase(dsn string) error {
+	var err error
+	if db, err = sql.Open("sqlite", dsn); err != nil {
+		return err
+	}
+	if writeDB, err = sql.Open("sqlite", dsn); err != nil {
+		db.Close()
+		return err
+	}
+	writeDB.SetMaxOpenConns(1)
+	return nil
+}
 
-	log.Printf("Server starting on :%s", *port)
-	log.Fatal(http.ListenAndServe(":"+*port, nil))
+// closeDatabase closes db and writeDB.
+func closeDatabase() error {
+	werr := writeDB.Close()
+	if err := db.Close(); err != nil {
+		return err
+	}
+	return werr
 }
 
-// setupDatabase creates necessary tables if they don't exist.
+// setupDatabase brings the schema up to date and seeds the default profile.
 func setupDatabase() error {
+	if err := migrateDatabase(); err != nil {
+		return err
+	}
+	if err := ensureUniqueURLs(); err != nil {
+		return err
+	}
+	if err := removeOrphanedRows(); err != nil {
+		return err
+	}
+	return seedDefaultProfile()
+}
+
+// migrateBaseline creates the schema as it stood before versioned
+// migrations. Databases created earlier may hold any subset of it, so every
+// step is idempotent.
+func migrateBaseline(tx *sql.Tx) error {
 	queries := []string{
 		`CREATE TABLE IF NOT EXISTS monitored_urls (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -147,155 +768,1024 @@ func setupDatabase() error {
 			url_id INTEGER PRIMARY KEY,
 			last_check DATETIME NOT NULL
 		);`,
+		`CREATE TABLE IF NOT EXISTS url_checks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url_id INTEGER NOT NULL,
+			timestamp DATETIME NOT NULL,
+			changed INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			FOREIGN KEY(url_id) REFERENCES monitored_urls(id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS fetch_profiles (
+			name TEXT PRIMARY KEY,
+			user_agent TEXT NOT NULL DEFAULT '',
+			timeout_seconds INTEGER NOT NULL DEFAULT 0,
+			headers TEXT NOT NULL DEFAULT '',
+			proxy TEXT NOT NULL DEFAULT '',
+			insecure_skip_verify INTEGER NOT NULL DEFAULT 0
+		);`,
+		`CREATE TABLE IF NOT EXISTS snapshot_diffs (
+			id1 INTEGER NOT NULL,
+			id2 INTEGER NOT NULL,
+			mode TEXT NOT NULL,
+			html TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			last_used DATETIME NOT NULL,
+			PRIMARY KEY (id1, id2, mode)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_snapshot_diffs_last_used ON snapshot_diffs(last_used);`,
+		`CREATE INDEX IF NOT EXISTS idx_url_checks_timestamp ON url_checks(timestamp);`,
+		`CREATE INDEX IF NOT EXISTS idx_url_checks_url_id ON url_checks(url_id, id);`,
+		`CREATE INDEX IF NOT EXISTS idx_url_snapshots_timestamp ON url_snapshots(timestamp);`,
+		// Serves the per-URL latest-snapshot and history lookups.
+		`CREATE INDEX IF NOT EXISTS idx_url_snapshots_url_id_timestamp ON url_snapshots(url_id, timestamp);`,
 	}
 	for _, q := range queries {
-		if _, err := db.Exec(q); err != nil {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	// Columns added after the initial schema. These are applied to existing
+	// databases so that older monitor.db files keep working. New schema
+	// changes belong in their own migration instead.
+	columns := []struct {
+		table, column, definition string
+	}{
+		{"monitored_urls", "extract_mode", "TEXT NOT NULL DEFAULT 'body'"},
+		{"url_snapshots", "content_path", "TEXT"},
+		{"monitored_urls", "offset_seconds", "INTEGER NOT NULL DEFAULT 0"},
+		{"monitored_urls", "follow_selector", "TEXT NOT NULL DEFAULT ''"},
+		{"url_snapshots", "manual", "INTEGER NOT NULL DEFAULT 0"},
+		{"url_snapshots", "region", "TEXT NOT NULL DEFAULT ''"},
+		{"monitored_urls", "regions", "TEXT NOT NULL DEFAULT ''"},
+		{"url_snapshots", "raw", "TEXT"},
+		{"url_snapshots", "content_type", "TEXT NOT NULL DEFAULT ''"},
+		{"monitored_urls", "profile", "TEXT NOT NULL DEFAULT 'default'"},
+		{"monitored_urls", "condition", "TEXT NOT NULL DEFAULT ''"},
+		{"monitored_urls", "condition_state", "INTEGER NOT NULL DEFAULT -1"},
+		{"monitored_urls", "confirm_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"monitored_urls", "confirm_delay", "INTEGER NOT NULL DEFAULT 0"},
+		{"monitored_urls", "selector", "TEXT NOT NULL DEFAULT ''"},
+		{"monitored_urls", "normalize_whitespace", "INTEGER NOT NULL DEFAULT 0"},
+		{"monitored_urls", "min_change", "INTEGER NOT NULL DEFAULT 0"},
+		{"monitored_urls", "health_state", "INTEGER NOT NULL DEFAULT -1"},
+		{"monitored_urls", "paused", "INTEGER NOT NULL DEFAULT 0"},
+		{"monitored_urls", "headers", "TEXT NOT NULL DEFAULT ''"},
+		{"monitored_urls", "json_path", "TEXT NOT NULL DEFAULT ''"},
+		{"url_snapshots", "status_code", "INTEGER NOT NULL DEFAULT 0"},
+		{"url_snapshots", "content_length", "INTEGER NOT NULL DEFAULT 0"},
+		{"url_snapshots", "fetch_duration_ms", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(tx, c.table, c.column, c.definition); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// addColumnIfMissing adds the named column to table unless it already exists.
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	var exists bool
+	err := tx.QueryRow("SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
 // updateLastCheck persists the current time as the last check time for the given URL.
 func updateLastCheck(urlID int) {
-	mu.Lock()
-	defer mu.Unlock()
-	_, err := db.Exec("INSERT OR REPLACE INTO url_last_check (url_id, last_check) VALUES (?, ?)", urlID, time.Now())
+	_, err := writeDB.Exec("INSERT OR REPLACE INTO url_last_check (url_id, last_check) VALUES (?, ?)", urlID, dbTime(time.Now()))
 	if err != nil {
-		log.Printf("Error updating last check for URL id %d: %v", urlID, err)
+		slog.Error("Error updating last check", "url_id", urlID, "error", err)
 	}
 }
 
-func shouldSendPush(urlID int) bool {
-	var pushInt int
-	err := db.QueryRow("SELECT push_enabled FROM monitored_urls WHERE id = ?", urlID).Scan(&pushInt)
+// recordCheck logs the outcome of a single check of a URL. status is the HTTP
+// status of the response, if there was one, and checkErr the error, if the
+// check failed.
+func recordCheck(urlID int, changed bool, status int, checkErr error) {
+	changedInt := 0
+	if changed {
+		changedInt = 1
+	}
+	errText := ""
+	if checkErr != nil {
+		errText = checkErr.Error()
+	}
+
+	_, err := writeDB.Exec("INSERT INTO url_checks (url_id, timestamp, changed, status, error) VALUES (?, ?, ?, ?, ?)", urlID, dbTime(time.Now()), changedInt, status, errText)
 	if err != nil {
-		log.Printf("Error checking push setting for URL id %d: %v", urlID, err)
-		return true // default to sending push if in doubt
+		slog.Error("Error recording check", "url_id", urlID, "error", err)
 	}
-	return pushInt != 0
 }
 
-func monitorURL(m MonitoredURL) {
-	var lastContent string
-
-	// Retrieve the most recent snapshot for this URL, if it exists.
-	err := db.QueryRow("SELECT content FROM url_snapshots WHERE url_id = ? ORDER BY timestamp DESC LIMIT 1", m.ID).Scan(&lastContent)
-	if err != nil && err != sql.ErrNoRows {
-		log.Printf("Error retrieving last snapshot for URL id %d: %v", m.ID, err)
-	}
+// monitorURL checks m every m.Frequency, or on its cron schedule if it has
+// one, until ctx is cancelled or the URL is deleted, saving a snapshot
+// whenever its content changes. A value on checkNow triggers an immediate
+// extra check. Its progress is recorded in st.
+func monitorURL(ctx context.Context, m MonitoredURL, checkNow <-chan struct{}, st *monitorState) {
+	um := &urlMonitor{m: m, rs: newRegionState(), state: st}
+	logger := m.logger()
 
 	// Retrieve the last check time.
 	var lastCheck time.Time
-	err = db.QueryRow("SELECT last_check FROM url_last_check WHERE url_id = ?", m.ID).Scan(&lastCheck)
+	err := db.QueryRow("SELECT last_check FROM url_last_check WHERE url_id = ?", m.ID).Scan(&lastCheck)
 	if err != nil && err != sql.ErrNoRows {
-		log.Printf("Error retrieving last check for URL id %d: %v", m.ID, err)
+		logger.Error("Error retrieving last check", "error", err)
+	}
+
+	// waitFor sleeps for d, cutting the wait short if a check is requested.
+	// It reports false if ctx was cancelled.
+	requested := false
+	waitFor := func(d time.Duration) bool {
+		st.waiting(time.Now().Add(d))
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-checkNow:
+			logger.Info("Check requested", "event", "check_requested")
+			requested = true
+		case <-ctx.Done():
+			return false
+		}
+		return true
 	}
 
-	// Wait if the frequency interval hasn't elapsed.
-	if err != sql.ErrNoRows {
+	sched := m.cronSchedule()
+	if sched != nil {
+		// Wait for the next scheduled time after the last check; one
+		// missed while watchurl wasn't running is caught up at once.
+		if err != sql.ErrNoRows {
+			if waitTime := time.Until(sched.next(lastCheck)); waitTime > 0 {
+				logger.Info("Waiting for next scheduled check", "event", "wait", "wait", waitTime.Round(time.Second))
+				if !waitFor(waitTime) {
+					return
+				}
+			}
+		}
+	} else if err != sql.ErrNoRows {
+		// Wait if the frequency interval hasn't elapsed.
 		elapsed := time.Since(lastCheck)
 		if elapsed < m.Frequency {
 			waitTime := m.Frequency - elapsed
-			log.Printf("Last check for %s was %v ago; waiting %v before next check", m.URL, elapsed.Round(time.Second), waitTime.Round(time.Second))
-			time.Sleep(waitTime)
+			logger.Info("Waiting for next check", "event", "wait", "since_last_check", elapsed.Round(time.Second), "wait", waitTime.Round(time.Second))
+			if !waitFor(waitTime) {
+				return
+			}
 		}
 	}
 
-	// Update the last check timestamp (this applies even before the first snapshot).
-	updateLastCheck(m.ID)
+	// Shift the first check onto the URL's phase so that its checks land at
+	// the same offset within each interval, across restarts.
+	if sched == nil && m.Offset > 0 && !requested {
+		waitTime := time.Until(nextPhaseTime(time.Now(), m.Frequency, m.Offset))
+		logger.Info("Aligning to offset", "event", "wait", "offset", m.Offset, "wait", waitTime.Round(time.Second))
+		if !waitFor(waitTime) {
+			return
+		}
+	}
 
-	// Take an initial snapshot.
-	log.Printf("Taking initial snapshot for URL: %s", m.URL)
-	resp, err := fetchURL(m.URL)
-	if err != nil {
-		log.Printf("Error fetching %s: %v", m.URL, err)
-	} else {
-		bodyBytes, err := ioutil.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			log.Printf("Error reading response from %s: %v", m.URL, err)
+	// Likewise start the checks of a URL with a start time on that time
+	// of day, or a whole number of intervals from it.
+	if sched == nil && m.StartAt != "" && !requested {
+		if start, err := nextStartTime(time.Now(), m.Frequency, m.StartAt); err != nil {
+			logger.Warn("Ignoring invalid start time", "start_at", m.StartAt, "error", err)
 		} else {
-			currentContent := extractBody(string(bodyBytes))
-			if currentContent != lastContent {
-				lastContent = currentContent
-				saveSnapshot(m.ID, currentContent)
-			} else {
-				log.Printf("No change detected on initial check for %s", m.URL)
+			waitTime := time.Until(start)
+			logger.Info("Waiting for start time", "event", "wait", "start_at", m.StartAt, "wait", waitTime.Round(time.Second))
+			if !waitFor(waitTime) {
+				return
+			}
+		}
+	}
+
+	// Spread out the first checks of URLs started together, e.g. at boot,
+	// so they don't all fetch at once.
+	if sched == nil && !m.aligned() && !requested {
+		if spread := initialJitter(m.Frequency); spread > 0 {
+			logger.Info("Delaying first check by jitter", "event", "wait", "wait", spread.Round(time.Millisecond))
+			if !waitFor(spread) {
+				return
 			}
 		}
 	}
 
-	ticker := time.NewTicker(m.Frequency)
-	defer ticker.Stop()
+	// Take an initial snapshot.
+	logger.Info("Starting first check", "event", "first_check")
+	if !um.check(ctx, false) {
+		return
+	}
+
+	interval := checkInterval(m)
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	next := time.Now().Add(interval)
+
+	for {
+		st.waiting(next)
+		select {
+		case <-timer.C:
+			interval = checkInterval(m)
+			timer.Reset(interval)
+			next = time.Now().Add(interval)
+		case <-checkNow:
+			logger.Info("Check requested", "event", "check_requested")
+		case <-ctx.Done():
+			logger.Info("Stopping monitoring", "event", "stop")
+			return
+		}
 
-	for range ticker.C {
 		// Check if the URL still exists.
 		var exists int
 		err := db.QueryRow("SELECT 1 FROM monitored_urls WHERE id = ?", m.ID).Scan(&exists)
 		if err != nil {
 			if err == sql.ErrNoRows {
-				log.Printf("Monitored URL with id %d has been deleted; stopping monitoring", m.ID)
+				logger.Info("Monitored URL has been deleted; stopping monitoring", "event", "stop")
 				return // exit the goroutine if the URL is deleted
 			}
-			log.Printf("Error checking existence for URL id %d: %v", m.ID, err)
+			logger.Error("Error checking existence", "error", err)
 			continue
 		}
 
-		// Update last check time.
-		updateLastCheck(m.ID)
+		logger.Info("Checking URL", "event", "check")
+		if !um.check(ctx, true) {
+			return
+		}
+	}
+}
+
+// urlMonitor holds the state a monitoring goroutine carries between checks.
+// It is only used from that goroutine, so checks of one URL never race.
+type urlMonitor struct {
+	m MonitoredURL
+	// last tracks the latest primary snapshot.
+	last  latestSnapshot
+	rs    *regionState
+	state *monitorState
+}
+
+// check fetches the URL once, compares the result with the last snapshot,
+// and records the check. A change is saved as a new snapshot and, if notify
+// is set, sent as a notification. It reports false if ctx was cancelled,
+// in which case nothing is recorded, or if the URL was paused for failing
+// too often (see pauseFailingURL).
+func (um *urlMonitor) check(ctx context.Context, notify bool) bool {
+	m := um.m
+	logger := m.logger()
+	um.state.set(monitorChecking)
+	// Update the last check timestamp (this applies even before the first snapshot).
+	updateLastCheck(m.ID)
+
+	var v validators
+	if usesConditionalRequests(m) {
+		var err error
+		if v, err = loadValidators(m.ID); err != nil {
+			logger.Error("Error reading validators", "error", err)
+		}
+	}
+
+	// Retries must give up before the next check is due.
+	start := time.Now()
+	fetchCtx, cancel := context.WithTimeout(ctx, m.Frequency)
+	res, err := fetchContentIfModified(fetchCtx, m, v)
+	cancel()
+	duration := time.Since(start).Round(time.Millisecond)
+	if ctx.Err() != nil {
+		return false
+	}
+	status := fetchErrorStatus(err)
+	switch {
+	case err == nil && res.NotModified:
+		status = http.StatusNotModified
+	case err == nil:
+		status = res.StatusCode
+	}
+	um.state.checked(start, status, err)
+	var robotsErr *robotsDisallowedError
+	if errors.As(err, &robotsErr) {
+		// Not the site's fault, so its health is left alone.
+		logger.Info("Skipping check", "event", "robots_disallowed", "error", err)
+		recordCheck(m.ID, false, 0, err)
+		return true
+	}
+	checkHealth(m, err)
+	failures := recordFailures(m.ID, err)
+	if err != nil {
+		logger.Warn("Error fetching URL", "event", "fetch_failed", "duration", duration, "status", fetchErrorStatus(err), "error", err)
+		recordCheck(m.ID, false, fetchErrorStatus(err), err)
+		return !pauseFailingURL(m, failures, err)
+	}
+	if res.NotModified {
+		logger.Info("Not modified since the last snapshot", "event", "not_modified", "duration", duration, "status", http.StatusNotModified)
+		recordCheck(m.ID, false, http.StatusNotModified, nil)
+		return true
+	}
+	if m.Condition != "" {
+		checkCondition(m, res.Raw)
+	}
+	if len(m.Regions) > 0 {
+		um.rs.check(ctx, m, res)
+	}
+	res, changed, err := um.detectChange(ctx, res)
+	if err != nil {
+		logger.Error("Error reading last snapshot", "error", err)
+		recordCheck(m.ID, false, res.StatusCode, err)
+		return true
+	}
+	// The URL may have been edited while this check was in flight;
+	// leave recording to the replacement goroutine.
+	if ctx.Err() != nil {
+		return false
+	}
+	recordCheck(m.ID, changed, res.StatusCode, nil)
+	if !changed {
+		if usesConditionalRequests(m) {
+			saveValidators(m.ID, um.last.id, res.Validators)
+		}
+		if !notify {
+			logger.Info("No change detected on initial check", "event", "unchanged", "duration", duration, "status", res.StatusCode)
+		} else {
+			logger.Debug("No change detected", "event", "unchanged", "duration", duration, "status", res.StatusCode)
+		}
+		return true
+	}
+
+	initial, previousID := um.last.id == 0, um.last.id
+	if initial {
+		logger.Info("Saving initial capture", "event", "initial_capture", "duration", duration, "status", res.StatusCode)
+	} else {
+		logger.Info("Change detected", "event", "change", "duration", duration, "status", res.StatusCode)
+	}
+	snap := res.snapshot(m)
+	snap.Initial = initial
+	snapshotID, err := saveSnapshot(snap)
+	if err == nil && usesConditionalRequests(m) {
+		saveValidators(m.ID, snapshotID, res.Validators)
+	}
+	if err == nil && !initial {
+		// Counted with the monitors, so that shutdown waits for it
+		// before closing the database.
+		monitorsWG.Add(1)
+		go func() {
+			defer monitorsWG.Done()
+			precomputeDiff(ctx, int(previousID), int(snapshotID))
+		}()
+	}
+	if err == nil && m.Screenshot {
+		saveScreenshot(ctx, m, snapshotID)
+	}
+	// The first capture of a page isn't a change to it.
+	if notify && !initial {
+		notifyChange(m, snapshotID)
+	}
+	return true
+}
 
-		log.Printf("Checking URL: %s", m.URL)
-		resp, err := fetchURL(m.URL)
+// detectChange compares freshly fetched content with the latest snapshot
+// and reports whether it is a change worth recording, after any
+// confirmation re-fetches, the minimum change size and the title have had
+// their say. It returns the fetch result to record, which confirmation may
+// have replaced. Content is always a change when there is no snapshot yet.
+// It neither saves nor notifies anything, so the whole comparison can be
+// exercised against a database by itself.
+func (um *urlMonitor) detectChange(ctx context.Context, res FetchResult) (FetchResult, bool, error) {
+	m := um.m
+	if err := um.last.refresh(m, res.ContentType); err != nil {
+		return res, false, err
+	}
+	changed := comparisonHash(m, res.ContentType, res.Content) != um.last.hash
+	if changed && um.last.id != 0 {
+		res, changed = confirmChange(ctx, m, um.last.hash, res)
+	}
+	if changed && um.last.id != 0 && m.MinChange > 0 {
+		old, err := um.last.content()
 		if err != nil {
-			log.Printf("Error fetching %s: %v", m.URL, err)
-			continue
+			m.logger().Error("Error reading last snapshot", "error", err)
+		} else {
+			changed = exceedsMinChange(m, old, res.Content)
+		}
+	}
+	if !changed && m.WatchTitle && um.last.id != 0 && res.Title != um.last.title {
+		m.logger().Info("Title changed", "event", "title_change", "title", res.Title, "previous_title", um.last.title)
+		changed = true
+	}
+	return res, changed, nil
+}
+
+// exceedsMinChange reports whether the change from old to new content is
+// larger than m.MinChange characters. Smaller changes are logged and treated
+// as no change, so the next check still compares against old.
+func exceedsMinChange(m MonitoredURL, old, new string) bool {
+	if m.MinChange <= 0 {
+		return true
+	}
+	stats := computeDiffStats(old, new)
+	if stats.Inserted+stats.Deleted > m.MinChange {
+		return true
+	}
+	m.logger().Info("Change is within the character threshold; not recording it", "event", "change_ignored", "change", stats.String(), "min_change", m.MinChange)
+	return false
+}
+
+// confirmChange re-fetches a URL whose content appears to have changed, up to
+// m.ConfirmCount times at m.ConfirmDelay intervals, and reports whether the
+// change persisted through every re-check, that is, whether no re-check
+// matched lastHash, the comparisonHash of the last snapshot. It returns the most recent fetch
+// result, which is the content to record. A re-check that fails to fetch is
+// treated as inconclusive and ends confirmation without recording a change.
+func confirmChange(ctx context.Context, m MonitoredURL, lastHash string, res FetchResult) (FetchResult, bool) {
+	for i := 1; i <= m.ConfirmCount; i++ {
+		if !sleepCtx(ctx, m.ConfirmDelay) {
+			return res, false
 		}
-		bodyBytes, err := ioutil.ReadAll(resp.Body)
-		resp.Body.Close()
+		next, err := fetchContent(ctx, m)
 		if err != nil {
-			log.Printf("Error reading response from %s: %v", m.URL, err)
-			continue
+			m.logger().Warn("Error re-checking URL", "event", "confirm_failed", "confirmation", i, "confirmations", m.ConfirmCount, "error", err)
+			return res, false
+		}
+		if comparisonHash(m, next.ContentType, next.Content) == lastHash {
+			m.logger().Info("Change reverted; not recording it", "event", "change_reverted", "confirmation", i, "confirmations", m.ConfirmCount)
+			return next, false
+		}
+		res = next
+	}
+	if m.ConfirmCount > 0 {
+		m.logger().Info("Change confirmed", "event", "change_confirmed", "confirmations", m.ConfirmCount)
+	}
+	return res, true
+}
+
+// nextPhaseTime returns the first time at or after t whose distance from
+// offset past the Unix epoch is a whole multiple of freq. Checks scheduled at
+// these times keep a fixed phase regardless of when monitoring started.
+func nextPhaseTime(t time.Time, freq, offset time.Duration) time.Time {
+	return nextAlignedTime(t, time.Unix(0, 0).Add(offset), freq)
+}
+
+// nextStartTime returns the first time at or after t that is a whole
+// multiple of freq before or after the time of day startAt ("15:04", local
+// time) on t's day.
+func nextStartTime(t time.Time, freq time.Duration, startAt string) (time.Time, error) {
+	at, err := time.ParseInLocation("15:04", startAt, t.Location())
+	if err != nil {
+		return t, err
+	}
+	origin := time.Date(t.Year(), t.Month(), t.Day(), at.Hour(), at.Minute(), 0, 0, t.Location())
+	return nextAlignedTime(t, origin, freq), nil
+}
+
+// nextAlignedTime returns the first time at or after t whose distance from
+// origin, which may be in either direction, is a whole multiple of freq.
+func nextAlignedTime(t, origin time.Time, freq time.Duration) time.Time {
+	if freq <= 0 {
+		return t
+	}
+	rel := t.Sub(origin)
+	slots := rel / freq
+	if rel%freq > 0 {
+		slots++
+	}
+	return origin.Add(slots * freq)
+}
+
+// NewSnapshot describes a snapshot to be stored by saveSnapshot.
+type NewSnapshot struct {
+	URLID   int
+	Content string
+	// Manual is set for captures requested by the user rather than
+	// detected changes.
+	Manual bool
+	// Region names the vantage point the content was fetched from; empty
+	// for the primary fetch.
+	Region string
+	// Raw is the unmodified response body, stored only with -store-raw or
+	// if KeepRaw is set.
+	Raw     string
+	KeepRaw bool
+	// ContentType is the media type of Content.
+	ContentType string
+	// Timestamp is when the content was captured; zero means now.
+	Timestamp time.Time
+	// StatusCode, ContentLength and FetchDuration describe the HTTP
+	// response the content came from.
+	StatusCode    int
+	ContentLength int64
+	FetchDuration time.Duration
+	// FinalURL is the address the content came from after redirects.
+	FinalURL string
+	// Title is the page's title.
+	Title string
+	// Initial is set for the first snapshot of a URL (or of a region),
+	// which has nothing to be compared with.
+	Initial bool
+}
+
+// saveSnapshot persists a snapshot of the URL content and returns its row id.
+func saveSnapshot(s NewSnapshot) (int64, error) {
+	p, err := prepareSnapshot(s)
+	if err != nil {
+		return 0, err
+	}
+	id, err := insertSnapshot(p)
+	if err != nil {
+		slog.Error("Error saving snapshot", "url_id", s.URLID, "error", err)
+		p.discard()
+		return 0, err
+	}
+	slog.Debug("Saved snapshot", "event", "snapshot_saved", "url_id", s.URLID, "snapshot_id", id, "region", s.Region, "bytes", len(s.Content), "compressed", p.compressed, "file", p.path.String)
+	return id, nil
+}
+
+// insertSnapshot inserts p in a transaction of its own.
+func insertSnapshot(p preparedSnapshot) (int64, error) {
+	tx, err := writeDB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	id, err := p.insert(tx)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// preparedSnapshot is a NewSnapshot ready to be inserted.
+type preparedSnapshot struct {
+	NewSnapshot
+	now time.Time
+	// inline is the content stored in the row, compressed if compressed
+	// is set, or nil if it was written to the file at path.
+	inline     interface{}
+	compressed bool
+	path       sql.NullString
+	raw        sql.NullString
+}
+
+// prepareSnapshot readies s to be inserted. If a snapshot directory is
+// configured, the content is written to a file and only its path will be
+// stored in the database; otherwise it is stored inline, compressed with
+// -compress-snapshots.
+func prepareSnapshot(s NewSnapshot) (preparedSnapshot, error) {
+	p := preparedSnapshot{NewSnapshot: s, now: s.Timestamp}
+	if p.now.IsZero() {
+		p.now = time.Now()
+	}
+	var err error
+	p.inline, p.compressed, err = inlineContent(s.Content)
+	if err != nil {
+		slog.Error("Error compressing snapshot", "url_id", s.URLID, "error", err)
+		return p, err
+	}
+	if snapshotDir != "" {
+		path, err := writeSnapshotFile(s.URLID, p.now, s.Content)
+		if err != nil {
+			slog.Error("Error writing snapshot file", "url_id", s.URLID, "error", err)
+			return p, err
 		}
+		p.inline, p.compressed = nil, false
+		p.path = sql.NullString{String: path, Valid: true}
+	}
+	if storeRaw || s.KeepRaw {
+		p.raw = sql.NullString{String: s.Raw, Valid: true}
+	}
+	return p, nil
+}
+
+// insert adds the snapshot's row and its search index entry within tx and
+// returns the row id. If tx doesn't commit, the caller must discard p.
+func (p preparedSnapshot) insert(tx *sql.Tx) (int64, error) {
+	res, err := tx.Exec("INSERT INTO url_snapshots (url_id, timestamp, content, compressed, content_path, content_hash, manual, region, raw, content_type, status_code, content_length, fetch_duration_ms, final_url, title, initial) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		p.URLID, dbTime(p.now), p.inline, boolToInt(p.compressed), p.path, contentHash(p.Content), boolToInt(p.Manual), p.Region, p.raw, p.ContentType, p.StatusCode, p.ContentLength, p.FetchDuration.Milliseconds(), p.FinalURL, p.Title, boolToInt(p.Initial))
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := indexSnapshot(tx, id, p.Content); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// discard removes the file written for a snapshot that wasn't inserted.
+func (p preparedSnapshot) discard() {
+	if p.path.Valid {
+		os.Remove(p.path.String)
+	}
+}
+
+// FetchResult is the outcome of fetching a monitored URL.
+type FetchResult struct {
+	// Content is the extracted content that is compared and stored.
+	Content string
+	// Raw is the unmodified body of the fetched page.
+	Raw string
+	// ContentType is the media type of Content. It selects the canonicalizer
+	// used when comparing snapshots.
+	ContentType string
+	// StatusCode and ContentLength describe the response Raw was read from;
+	// ContentLength is the number of bytes read.
+	StatusCode    int
+	ContentLength int64
+	// FetchDuration is how long fetching took, including retries and
+	// following a link.
+	FetchDuration time.Duration
+	// FinalURL is the address Raw was read from after redirects.
+	FinalURL string
+	// Validators are the response's ETag and Last-Modified headers; they
+	// are left empty when a link was followed.
+	Validators validators
+	// NotModified is set, and nothing else but StatusCode, when a
+	// conditional request was answered with 304 Not Modified.
+	NotModified bool
+	// Title is the title of the page Raw holds, if it is HTML.
+	Title string
+}
+
+// snapshot returns the NewSnapshot that stores r for the given URL.
+func (r FetchResult) snapshot(m MonitoredURL) NewSnapshot {
+	return NewSnapshot{
+		URLID:         m.ID,
+		Content:       r.Content,
+		Raw:           r.Raw,
+		KeepRaw:       m.StoreRaw,
+		ContentType:   r.ContentType,
+		StatusCode:    r.StatusCode,
+		ContentLength: r.ContentLength,
+		FetchDuration: r.FetchDuration,
+		FinalURL:      r.FinalURL,
+		Title:         r.Title,
+	}
+}
+
+// fetchContent fetches the monitored URL and returns its extracted content.
+// If the URL has a follow selector, the first link it matches on the fetched
+// page is fetched in turn and that page's content is returned instead.
+func fetchContent(ctx context.Context, m MonitoredURL) (FetchResult, error) {
+	return fetchContentWith(ctx, m, loadFetchProfile(m.Profile))
+}
 
-		currentContent := extractBody(string(bodyBytes))
-		if currentContent != lastContent {
-			log.Printf("Change detected for %s", m.URL)
-			lastContent = currentContent
-			saveSnapshot(m.ID, currentContent)
-			if shouldSendPush(m.ID) {
-				sendPushoverNotification(m.URL, time.Now())
+// fetchContentWith is fetchContent using the given fetch profile.
+func fetchContentWith(ctx context.Context, m MonitoredURL, profile FetchProfile) (FetchResult, error) {
+	start := time.Now()
+	profile = profile.withHeaders(m.Headers)
+	profile.NoRedirects = m.NoRedirects
+	profile.Method, profile.Body, profile.BodyType = m.Method, m.RequestBody, m.BodyType
+	if m.UserAgent != "" {
+		profile.UserAgent = m.UserAgent
+	}
+	profile.InsecureSkipVerify = profile.InsecureSkipVerify || m.InsecureSkipVerify
+	profile.ClientCert, profile.ClientKey = m.ClientCert, m.ClientKey
+	body, resp, err := fetchBody(ctx, profile, m.URL)
+	if err != nil {
+		return FetchResult{}, err
+	}
+	if isRedirect(resp.StatusCode) {
+		// Only returned with NoRedirects: watch where it points.
+		return FetchResult{
+			Content:       fmt.Sprintf("Redirects (%d) to %s", resp.StatusCode, resp.Header.Get("Location")),
+			Raw:           body,
+			ContentType:   "text/plain",
+			StatusCode:    resp.StatusCode,
+			ContentLength: int64(len(body)),
+			FetchDuration: time.Since(start).Round(time.Millisecond),
+			FinalURL:      resp.Request.URL.String(),
+		}, nil
+	}
+
+	if m.FollowSelector != "" {
+		target, err := findLink(body, resp.Request.URL, m.FollowSelector)
+		if err != nil {
+			m.logger().Warn("Could not follow link; comparing the page itself", "event", "follow_failed", "selector", m.FollowSelector, "error", err)
+		} else {
+			m.logger().Info("Following link", "event", "follow", "selector", m.FollowSelector, "target", target)
+			// The link is an ordinary page, whatever the form was.
+			profile.Method, profile.Body, profile.BodyType = "", "", ""
+			body, resp, err = fetchBody(ctx, profile, target)
+			if err != nil {
+				return FetchResult{}, err
 			}
 		}
 	}
+
+	if err := checkBlockMarkers(m, body); err != nil {
+		return FetchResult{}, err
+	}
+	content, contentType := extractContent(m, body, resp.Header.Get("Content-Type"))
+	var v validators
+	if m.FollowSelector == "" {
+		v = validators{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	}
+	return FetchResult{
+		Content:       content,
+		Raw:           body,
+		ContentType:   contentType,
+		StatusCode:    resp.StatusCode,
+		ContentLength: int64(len(body)),
+		FetchDuration: time.Since(start).Round(time.Millisecond),
+		FinalURL:      resp.Request.URL.String(),
+		Validators:    v,
+		Title:         pageTitle(body, resp.Header.Get("Content-Type")),
+	}, nil
+}
+
+var (
+	// fetchRetries is how many times a fetch failing with a connection
+	// error or 5xx status is retried before giving up.
+	fetchRetries = 2
+	// fetchRetryDelay is the wait before the first retry; it doubles with
+	// each further retry.
+	fetchRetryDelay = time.Second
+)
+
+// fetchBody fetches rawURL, retrying transient failures with exponential
+// backoff for as long as ctx allows, and returns the response body along
+// with the response itself, whose body has already been read and closed.
+// Responses with a non-2xx status are returned as a *statusError, except
+// for redirects when p.NoRedirects is set.
+func fetchBody(ctx context.Context, p FetchProfile, rawURL string) (string, *http.Response, error) {
+	delay := fetchRetryDelay
+	for attempt := 1; ; attempt++ {
+		body, resp, err := fetchBodyOnce(ctx, p, rawURL)
+		if err == nil || attempt > fetchRetries || !retryableFetchError(err) || ctx.Err() != nil {
+			return body, resp, err
+		}
+		slog.Warn("Error fetching URL; retrying", "event", "fetch_retry", "url", rawURL, "status", fetchErrorStatus(err), "error", err, "wait", delay, "attempt", attempt, "retries", fetchRetries)
+		if !sleepCtx(ctx, delay) {
+			return body, resp, err
+		}
+		delay *= 2
+	}
+}
+
+// fetchSlots limits how many fetches run at once, so that the many
+// monitors started together at boot don't all open connections at the same
+// time. It is sized from the -max-concurrent-fetches flag in main.
+var fetchSlots chan struct{}
+
+// acquireFetchSlot blocks until a fetch slot is free or ctx is done. It
+// reports whether a slot was acquired; the caller must then call
+// releaseFetchSlot.
+func acquireFetchSlot(ctx context.Context) bool {
+	if fetchSlots == nil {
+		return true
+	}
+	select {
+	case fetchSlots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func releaseFetchSlot() {
+	if fetchSlots != nil {
+		<-fetchSlots
+	}
+}
+
+// retryableFetchError reports whether a fetch error is likely transient:
+// a network error or a 5xx response. Client errors (4xx) are not retried.
+func retryableFetchError(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// maxBodyBytes caps the size of a response body read by fetchBodyOnce, so
+// that a URL unexpectedly serving a huge file can't exhaust memory. Zero
+// means no limit.
+var maxBodyBytes int64 = 10 << 20
+
+// bodyTooLargeError is returned for responses larger than maxBodyBytes.
+type bodyTooLargeError struct {
+	Limit int64
+}
+
+func (e *bodyTooLargeError) Error() string {
+	return fmt.Sprintf("response is larger than the %s limit (see -max-body-bytes)", humanize.IBytes(uint64(e.Limit)))
 }
 
-// saveSnapshot persists a snapshot of the URL content.
-func saveSnapshot(urlID int, content string) {
-	mu.Lock()
-	defer mu.Unlock()
-	_, err := db.Exec("INSERT INTO url_snapshots (url_id, timestamp, content) VALUES (?, ?, ?)",
-		urlID, time.Now(), content)
+// fetchBodyOnce is fetchBody without retries. It holds a fetch slot from
+// the request until the body has been read.
+func fetchBodyOnce(ctx context.Context, p FetchProfile, rawURL string) (string, *http.Response, error) {
+	if err := checkRobots(ctx, p, rawURL); err != nil {
+		return "", nil, err
+	}
+	if !acquireFetchSlot(ctx) {
+		return "", nil, ctx.Err()
+	}
+	defer releaseFetchSlot()
+	resp, err := fetchURL(ctx, p, rawURL)
 	if err != nil {
-		log.Printf("Error saving snapshot for URL id %d: %v", urlID, err)
+		return "", nil, err
+	}
+	defer closeBody(resp)
+	if (resp.StatusCode < 200 || resp.StatusCode > 299) && !(p.NoRedirects && isRedirect(resp.StatusCode)) {
+		return "", nil, &statusError{StatusCode: resp.StatusCode}
+	}
+	var body io.Reader = resp.Body
+	if maxBodyBytes > 0 {
+		if resp.ContentLength > maxBodyBytes {
+			return "", nil, &bodyTooLargeError{Limit: maxBodyBytes}
+		}
+		body = io.LimitReader(resp.Body, maxBodyBytes+1)
+	}
+	bodyBytes, err := io.ReadAll(body)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading response: %w", err)
+	}
+	if maxBodyBytes > 0 && int64(len(bodyBytes)) > maxBodyBytes {
+		return "", nil, &bodyTooLargeError{Limit: maxBodyBytes}
 	}
+	if final := resp.Request.URL.String(); final != rawURL {
+		slog.Info("Fetch was redirected", "event", "redirect", "url", rawURL, "final_url", final)
+	}
+	return string(bodyBytes), resp, nil
+}
+
+// isRedirect reports whether status is an HTTP redirect status.
+func isRedirect(status int) bool {
+	return status >= 300 && status <= 399
 }
 
-func fetchURL(url string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", url, nil)
+// fetchURL requests url using the settings of the given fetch profile,
+// including the method and body set on it for the URL. The
+// request is abandoned when ctx is cancelled or the profile's timeout expires.
+// Compressed responses are decoded; see decodeBody.
+func fetchURL(ctx context.Context, p FetchProfile, url string) (*http.Response, error) {
+	client, err := p.client()
+	if err != nil {
+		return nil, err
+	}
+	method := p.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if p.Body != "" {
+		body = strings.NewReader(p.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", p.userAgent())
+	if p.BodyType != "" {
+		req.Header.Set("Content-Type", p.BodyType)
+	}
+	// Asking for compression ourselves stops the transport from doing it,
+	// so that every coding is handled the same way.
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	for name, value := range p.Headers {
+		req.Header.Set(name, value)
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		slog.Warn("Fetch timed out", "event", "fetch_timeout", "url", url, "timeout", client.Timeout)
+	}
 	if err != nil {
 		return nil, err
 	}
-	// Set a common user agent, e.g., mimicking Chrome on Windows.
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) "+
-		"AppleWebKit/537.36 (KHTML, like Gecko) "+
-		"Chrome/90.0.4430.93 Safari/537.36")
-	return http.DefaultClient.Do(req)
+	slog.Debug("Fetched URL", "event", "fetch", "url", url, "status", resp.StatusCode,
+		"duration", time.Since(start).Round(time.Millisecond), "content_encoding", resp.Header.Get("Content-Encoding"))
+	if err := decodeBody(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Extraction modes stored in monitored_urls.extract_mode.
+const (
+	extractModeBody   = "body"
+	extractModeText   = "text"
+	extractModeRaw    = "raw"
+	extractModeJSONLD = "jsonld"
+)
+
+// extractContent reduces a fetched page to the content that is compared and
+// stored, according to the URL's extraction mode and the response content
+// type, and applies the URL's masks. It returns the content along with its
+// media type.
+func extractContent(m MonitoredURL, input, contentType string) (string, string) {
+	content, contentType := extractUnmasked(m, input, contentType)
+	return applyMasks(m, content), contentType
+}
+
+// extractUnmasked does the work of extractContent apart from masking.
+func extractUnmasked(m MonitoredURL, input, contentType string) (string, string) {
+	switch m.ExtractMode {
+	case extractModeJSONLD:
+		content, ok := extractJSONLD(input)
+		if !ok {
+			m.logger().Warn("No JSON-LD blocks found; falling back to body", "event", "extract_fallback")
+			return extractBody(input), contentType
+		}
+		return content, "application/ld+json"
+	case extractModeRaw:
+		// The response is kept as it was fetched, apart from binary
+		// content, which still can't be diffed or shown.
+		if isBinaryType(contentType) {
+			return binarySummary(input, contentType), contentType
+		}
+		return input, contentType
+	case extractModeText:
+		content, contentType := extractDocument(m, input, contentType)
+		if !isHTMLType(contentType) {
+			return content, contentType
+		}
+		return extractText(content), "text/plain; charset=utf-8"
+	default:
+		return extractDocument(m, input, contentType)
+	}
+}
+
+// extractDocument reduces a page as the body extraction mode does: JSON is
+// narrowed to the URL's JSON path, other structured and plain text formats
+// are kept as they are, and HTML is narrowed to the URL's markers and
+// selector, or else its <body>.
+func extractDocument(m MonitoredURL, input, contentType string) (string, string) {
+	if isJSONType(contentType) {
+		if content, ok := extractJSON(m, input); ok {
+			return content, contentType
+		}
+	}
+	// Structured formats are kept as they are; parsing them as HTML
+	// would mangle them.
+	if isStructuredType(contentType) {
+		return input, contentType
+	}
+	// Binary content is watched through its hash: the bytes themselves
+	// can't be diffed or shown.
+	if isBinaryType(contentType) {
+		return binarySummary(input, contentType), contentType
+	}
+	if m.StartMarker != "" || m.EndMarker != "" {
+		if part, ok := betweenMarkers(input, m.StartMarker, m.EndMarker); ok {
+			input = part
+		} else {
+			// A site change that drops a marker is still noticed.
+			m.logger().Warn("Markers not found; falling back to the whole page", "event", "extract_fallback", "start_marker", m.StartMarker, "end_marker", m.EndMarker)
+		}
+	}
+	// Plain text and other text formats are kept as they are.
+	if !isHTMLType(contentType) {
+		return input, contentType
+	}
+	if m.Selector != "" {
+		if content, ok := extractSelector(input, m.Selector); ok {
+			return content, contentType
+		}
+		m.logger().Warn("Selector matched nothing; falling back to body", "event", "extract_fallback", "selector", m.Selector)
+	}
+	return extractBody(input), contentType
+}
+
+// binarySummary describes binary content by its type, size and hash, which
+// is what is compared and stored in its place.
+func binarySummary(input, contentType string) string {
+	return fmt.Sprintf("Binary content (%s, %s, SHA-256 %s)", mediaType(contentType), humanize.Bytes(uint64(len(input))), contentHash(input))
+}
+
+// betweenMarkers returns the part of s after the first occurrence of start
+// and before the next occurrence of end. An empty start stands for the
+// beginning of s and an empty end for its end. It reports false if a marker
+// isn't found.
+func betweenMarkers(s, start, end string) (string, bool) {
+	if start != "" {
+		i := strings.Index(s, start)
+		if i < 0 {
+			return "", false
+		}
+		s = s[i+len(start):]
+	}
+	if end != "" {
+		i := strings.Index(s, end)
+		if i < 0 {
+			return "", false
+		}
+		s = s[:i]
+	}
+	return s, true
 }
 
 // extractBody parses the input HTML and returns only the inner HTML of the <body> tag,
-// while stripping out non-visible tags (e.g. <meta>). If no <body> tag is found or the input
-// isn’t valid HTML, the original input is returned.
+// while stripping out non-visible tags (e.g. <meta>) and sorting each element's attributes
+// so that markup differing only in attribute order extracts identically. If no <body> tag
+// is found or the input isn’t valid HTML, the original input is returned.
 func extractBody(input string) string {
 	doc, err := html.Parse(strings.NewReader(input))
 	if err != nil {
@@ -323,8 +1813,10 @@ func extractBody(input string) string {
 		return input
 	}
 
-	// Remove non-visible tags such as <meta> from the <body> node.
-	removeMetaNodes(body)
+	// Remove non-visible nodes such as <script> and comments from the <body>
+	// node.
+	stripNodes(body)
+	sortAttributes(body)
 
 	var buf bytes.Buffer
 	for c := body.FirstChild; c != nil; c = c.NextSibling {
@@ -334,50 +1826,3 @@ func extractBody(input string) string {
 	}
 	return buf.String()
 }
-
-// removeMetaNodes traverses the node tree under n and removes any <meta>,
-// <script>, or hidden <input> elements.
-func removeMetaNodes(n *html.Node) {
-	if n == nil {
-		return
-	}
-	var newChildren []*html.Node
-	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		if c.Type == html.ElementNode {
-			// Skip <meta> and <script> tags.
-			if c.Data == "meta" || c.Data == "script" {
-				continue
-			}
-			// Skip <input> tags with type="hidden".
-			if c.Data == "input" {
-				skip := false
-				for _, a := range c.Attr {
-					if strings.ToLower(a.Key) == "type" && strings.ToLower(a.Val) == "hidden" {
-						skip = true
-						break
-					}
-				}
-				if skip {
-					continue
-				}
-			}
-		}
-		newChildren = append(newChildren, c)
-	}
-	// Rebuild the child linked list.
-	if len(newChildren) > 0 {
-		n.FirstChild = newChildren[0]
-		newChildren[0].PrevSibling = nil
-		for i := 1; i < len(newChildren); i++ {
-			newChildren[i].PrevSibling = newChildren[i-1]
-			newChildren[i-1].NextSibling = newChildren[i]
-		}
-		newChildren[len(newChildren)-1].NextSibling = nil
-	} else {
-		n.FirstChild = nil
-	}
-	// Recursively process each remaining child.
-	for _, c := range newChildren {
-		removeMetaNodes(c)
-	}
-}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	// Only show what the code under test logs with -v.
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+	os.Exit(m.Run())
+}
+
+// openTestDB points db at a fresh database in a temporary directory, with
+// the schema set up as at startup, and closes it when the test ends. Any
+// monitors the test started are stopped first.
+func openTestDB(t testing.TB) {
+	t.Helper()
+	dsn, err := databaseDSN(filepath.Join(t.TempDir(), "monitor.db"), 5*time.Second, "WAL", "NORMAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := openDatabase(dsn); err != nil {
+		t.Fatal(err)
+	}
+	if err := setupDatabase(); err != nil {
+		t.Fatalf("setupDatabase: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stopAllMonitors(ctx)
+		closeDatabase()
+	})
+}
+
+// addTestURL inserts m, paused unless the test unpauses it, and returns it
+// with its ID set.
+func addTestURL(t testing.TB, m MonitoredURL) MonitoredURL {
+	t.Helper()
+	if m.Frequency == 0 {
+		m.Frequency = time.Hour
+	}
+	if err := insertMonitoredURL(writeDB, &m); err != nil {
+		t.Fatalf("insertMonitoredURL: %v", err)
+	}
+	return m
+}
+
+func itoa(n int) string { return strconv.Itoa(n) }
+
+// mustSeconds parses s as a whole number of seconds.
+func mustSeconds(t *testing.T, s string) time.Duration {
+	t.Helper()
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maskPlaceholder replaces every match of a URL's masks.
+const maskPlaceholder = "[masked]"
+
+// parseMasks splits masks given one regular expression per line, dropping
+// blank lines, and reports the first one that does not compile.
+func parseMasks(s string) ([]string, error) {
+	var masks []string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if _, err := regexp.Compile(line); err != nil {
+			return nil, fmt.Errorf("%q: %w", line, err)
+		}
+		masks = append(masks, line)
+	}
+	return masks, nil
+}
+
+// formatMasks joins masks in the form stored in monitored_urls.masks and
+// accepted by parseMasks.
+func formatMasks(masks []string) string {
+	return strings.Join(masks, "\n")
+}
+
+// applyMasks replaces the parts of content matching any of m's masks with
+// maskPlaceholder, so that tokens changing on every request are neither
+// compared nor stored.
+func applyMasks(m MonitoredURL, content string) string {
+	for _, mask := range m.Masks {
+		re, err := regexp.Compile(mask)
+		if err != nil {
+			m.logger().Warn("Ignoring invalid mask", "mask", mask, "error", err)
+			continue
+		}
+		content = re.ReplaceAllLiteralString(content, maskPlaceholder)
+	}
+	return content
+}
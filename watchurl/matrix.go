@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MatrixMessage is the content of an m.room.message event.
+type MatrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+// matrixTxnCounter makes transaction ids unique within a process; the start
+// time makes them unique across restarts.
+var (
+	matrixTxnCounter atomic.Int64
+	matrixTxnPrefix  = fmt.Sprintf("watchurl-%d", time.Now().UnixNano())
+)
+
+// sendMatrixNotification posts a change notification to MATRIX_ROOM_ID. If
+// BASE_URL is set to the address of this server's web UI, the message
+// links to the diff that introduced snapshot snapshotID.
+func sendMatrixNotification(monitoredURL, message string, urlID int, snapshotID int64) error {
+	msg := matrixText(message)
+	if base := os.Getenv("BASE_URL"); base != "" {
+		diffURL := strings.TrimRight(base, "/") + snapshotDiffPath(urlID, snapshotID)
+		msg.Body += "\n" + diffURL
+		msg.FormattedBody += `<br><a href="` + html.EscapeString(diffURL) + `">View the diff</a>`
+	}
+	return sendMatrixMessage(msg)
+}
+
+// sendMatrixTitled posts a message with the given title about monitoredURL
+// to MATRIX_ROOM_ID.
+func sendMatrixTitled(title, message, monitoredURL string) error {
+	msg := matrixText(message + "\n" + monitoredURL)
+	msg.Body = title + "\n" + msg.Body
+	msg.FormattedBody = "<strong>" + html.EscapeString(title) + "</strong><br>" + msg.FormattedBody
+	return sendMatrixMessage(msg)
+}
+
+// matrixText returns a text message with text as both its plain and its
+// HTML body, so that links and emphasis can be added to the HTML one.
+func matrixText(text string) MatrixMessage {
+	return MatrixMessage{
+		MsgType:       "m.text",
+		Body:          text,
+		Format:        "org.matrix.custom.html",
+		FormattedBody: strings.ReplaceAll(html.EscapeString(text), "\n", "<br>"),
+	}
+}
+
+// sendMatrixMessage sends msg to MATRIX_ROOM_ID on MATRIX_HOMESERVER as the
+// user of MATRIX_ACCESS_TOKEN.
+func sendMatrixMessage(msg MatrixMessage) error {
+	homeserver := os.Getenv("MATRIX_HOMESERVER")
+	token := os.Getenv("MATRIX_ACCESS_TOKEN")
+	roomID := os.Getenv("MATRIX_ROOM_ID")
+	if homeserver == "" || token == "" || roomID == "" {
+		return errors.New("missing Matrix homeserver, access token or room id")
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("encoding Matrix message: %w", err)
+	}
+	// The transaction id lets the homeserver recognize a retried request.
+	txnID := fmt.Sprintf("%s-%d", matrixTxnPrefix, matrixTxnCounter.Add(1))
+	endpoint := strings.TrimRight(homeserver, "/") + "/_matrix/client/v3/rooms/" +
+		url.PathEscape(roomID) + "/send/m.room.message/" + url.PathEscape(txnID)
+	req, err := http.NewRequest(http.MethodPut, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating Matrix request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Matrix returned %s: %s", resp.Status, errBody)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// NotificationData is what the NOTIFY_TEMPLATE message template is rendered
+// against, e.g. "{{.URL}} changed ({{.DiffStats}}): {{.DiffURL}}".
+type NotificationData struct {
+	URL        string
+	URLID      int
+	ChangeTime time.Time
+	// SnapshotID is the id of the new snapshot; zero in previews.
+	SnapshotID int64
+}
+
+// DiffStats compares the new snapshot with the one before it. It is only
+// computed if the template uses it.
+func (d NotificationData) DiffStats() DiffStats {
+	if d.SnapshotID == 0 {
+		return DiffStats{}
+	}
+	prevID, ok := previousSnapshotID(d.URLID, d.SnapshotID)
+	if !ok {
+		return DiffStats{}
+	}
+	older, err := loadSnapshotContent(int(prevID))
+	if err != nil {
+		slog.Error("Error reading snapshot", "url_id", d.URLID, "snapshot_id", prevID, "error", err)
+		return DiffStats{}
+	}
+	newer, err := loadSnapshotContent(int(d.SnapshotID))
+	if err != nil {
+		slog.Error("Error reading snapshot", "url_id", d.URLID, "snapshot_id", d.SnapshotID, "error", err)
+		return DiffStats{}
+	}
+	return computeDiffStats(older, newer)
+}
+
+// DiffURL links to the diff of the change on this server's web UI, or is
+// empty if BASE_URL is not set.
+func (d NotificationData) DiffURL() string {
+	base := os.Getenv("BASE_URL")
+	if base == "" {
+		return ""
+	}
+	return strings.TrimRight(base, "/") + snapshotDiffPath(d.URLID, d.SnapshotID)
+}
+
+// messageTemplate is the parsed NOTIFY_TEMPLATE, or nil to use
+// defaultNotificationMessage.
+var messageTemplate *template.Template
+
+// loadMessageTemplate parses NOTIFY_TEMPLATE, if set. An invalid template is
+// logged and the default message is used instead.
+func loadMessageTemplate() {
+	text := os.Getenv("NOTIFY_TEMPLATE")
+	if text == "" {
+		return
+	}
+	t, err := template.New("message").Parse(text)
+	if err != nil {
+		slog.Warn("Ignoring invalid NOTIFY_TEMPLATE", "error", err)
+		return
+	}
+	messageTemplate = t
+}
+
+// notificationMessage formats the body of a change notification with
+// NOTIFY_TEMPLATE, falling back to the default if there is none or it fails.
+func notificationMessage(d NotificationData) string {
+	if messageTemplate != nil {
+		var b strings.Builder
+		err := messageTemplate.Execute(&b, d)
+		if err == nil {
+			return b.String()
+		}
+		slog.Warn("Error rendering NOTIFY_TEMPLATE; using the default message", "url_id", d.URLID, "url", d.URL, "error", err)
+	}
+	return defaultNotificationMessage(d.URL, d.ChangeTime)
+}
+
+// defaultNotificationMessage is the change notification body used without
+// NOTIFY_TEMPLATE.
+func defaultNotificationMessage(monitoredURL string, changeTime time.Time) string {
+	return fmt.Sprintf("Change detected on %s at %s", monitoredURL, changeTime.Format(time.RFC1123))
+}
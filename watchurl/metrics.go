@@ -0,0 +1,40 @@
+package main
+
+import "time"
+
+// Metrics holds the summary figures shown in the index page footer.
+type Metrics struct {
+	URLs       int
+	Checks24h  int
+	Changes24h int
+	Failing    int
+}
+
+// loadMetrics computes the dashboard totals from the check and snapshot
+// tables. Each query is served by an index on the table it reads.
+func loadMetrics() (Metrics, error) {
+	var m Metrics
+	since := time.Now().Add(-24 * time.Hour)
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM monitored_urls").Scan(&m.URLs); err != nil {
+		return m, err
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM url_checks WHERE timestamp > ?", dbTime(since)).Scan(&m.Checks24h); err != nil {
+		return m, err
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM url_snapshots WHERE timestamp > ? AND manual = 0", dbTime(since)).Scan(&m.Changes24h); err != nil {
+		return m, err
+	}
+	// A URL is failing if its most recent check recorded an error.
+	err := db.QueryRow(`
+        SELECT COUNT(*)
+        FROM url_checks c
+        JOIN (
+            SELECT url_id, MAX(id) AS id
+            FROM url_checks
+            GROUP BY url_id
+        ) latest ON c.id = latest.id
+        JOIN monitored_urls mu ON mu.id = c.url_id
+        WHERE c.error != ''`).Scan(&m.Failing)
+	return m, err
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// migration upgrades the schema by one version.
+type migration struct {
+	name string
+	up   func(tx *sql.Tx) error
+}
+
+// migrations lists every schema change in order; migrations[i] takes a
+// database from version i to version i+1. The version is kept in SQLite's
+// user_version pragma. Append new migrations to the end and never edit or
+// reorder ones that have shipped.
+var migrations = []migration{
+	{"baseline schema", migrateBaseline},
+	{"monitored_urls.tags", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "monitored_urls", "tags", "TEXT NOT NULL DEFAULT ''")
+	}},
+	{"url_snapshots.content_hash", migrateContentHash},
+	{"monitored_urls.channels", migrateChannels},
+	{"redirect settings", func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "monitored_urls", "no_redirects", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		return addColumnIfMissing(tx, "url_snapshots", "final_url", "TEXT NOT NULL DEFAULT ''")
+	}},
+	{"snapshot_fts", migrateSnapshotFTS},
+	{"monitored_urls.masks", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "monitored_urls", "masks", "TEXT NOT NULL DEFAULT ''")
+	}},
+	{"screenshots", func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "monitored_urls", "screenshot", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		return addColumnIfMissing(tx, "url_snapshots", "screenshot", "BLOB")
+	}},
+	{"monitored_urls.user_agent", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "monitored_urls", "user_agent", "TEXT NOT NULL DEFAULT ''")
+	}},
+	{"failure counts", func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "monitored_urls", "consecutive_failures", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		return addColumnIfMissing(tx, "monitored_urls", "last_error", "TEXT NOT NULL DEFAULT ''")
+	}},
+	{"url_validators", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS url_validators (
+            url_id INTEGER PRIMARY KEY,
+            snapshot_id INTEGER NOT NULL,
+            etag TEXT NOT NULL DEFAULT '',
+            last_modified TEXT NOT NULL DEFAULT ''
+        )`)
+		return err
+	}},
+	{"monitored_urls.schedule", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "monitored_urls", "schedule", "TEXT NOT NULL DEFAULT ''")
+	}},
+	{"monitored_urls.store_raw", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "monitored_urls", "store_raw", "INTEGER NOT NULL DEFAULT 0")
+	}},
+	{"url_snapshots.compressed", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "url_snapshots", "compressed", "INTEGER NOT NULL DEFAULT 0")
+	}},
+	{"titles", func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "url_snapshots", "title", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		return addColumnIfMissing(tx, "monitored_urls", "watch_title", "INTEGER NOT NULL DEFAULT 0")
+	}},
+	{"request method and body", func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "monitored_urls", "method", "TEXT NOT NULL DEFAULT 'GET'"); err != nil {
+			return err
+		}
+		if err := addColumnIfMissing(tx, "monitored_urls", "request_body", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		return addColumnIfMissing(tx, "monitored_urls", "body_type", "TEXT NOT NULL DEFAULT ''")
+	}},
+	{"url_snapshots.initial", func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "url_snapshots", "initial", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		// The earliest snapshot kept of each history stands in for its
+		// initial capture, which retention may have deleted.
+		_, err := tx.Exec(`UPDATE url_snapshots SET initial = 1 WHERE id IN (
+            SELECT id FROM url_snapshots s
+            WHERE timestamp = (SELECT MIN(timestamp) FROM url_snapshots WHERE url_id = s.url_id AND region = s.region)
+            GROUP BY url_id, region)`)
+		return err
+	}},
+	{"UTC timestamps", migrateTimestamps},
+	{"url_checks.status", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "url_checks", "status", "INTEGER NOT NULL DEFAULT 0")
+	}},
+	{"monitored_urls.start_at", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "monitored_urls", "start_at", "TEXT NOT NULL DEFAULT ''")
+	}},
+	{"monitored_urls.block_markers", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "monitored_urls", "block_markers", "TEXT NOT NULL DEFAULT ''")
+	}},
+	{"text markers", func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "monitored_urls", "start_marker", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		return addColumnIfMissing(tx, "monitored_urls", "end_marker", "TEXT NOT NULL DEFAULT ''")
+	}},
+	{"pushover options", func(tx *sql.Tx) error {
+		for _, c := range []struct{ name, def string }{
+			{"pushover_priority", "INTEGER NOT NULL DEFAULT 0"},
+			{"pushover_sound", "TEXT NOT NULL DEFAULT ''"},
+			{"pushover_retry", "INTEGER NOT NULL DEFAULT 0"},
+			{"pushover_expire", "INTEGER NOT NULL DEFAULT 0"},
+		} {
+			if err := addColumnIfMissing(tx, "monitored_urls", c.name, c.def); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"monitored_urls.auto_paused", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "monitored_urls", "auto_paused", "INTEGER NOT NULL DEFAULT 0")
+	}},
+	{"TLS settings", func(tx *sql.Tx) error {
+		for _, c := range []struct{ name, def string }{
+			{"insecure_skip_verify", "INTEGER NOT NULL DEFAULT 0"},
+			{"client_cert", "TEXT NOT NULL DEFAULT ''"},
+			{"client_key", "TEXT NOT NULL DEFAULT ''"},
+		} {
+			if err := addColumnIfMissing(tx, "monitored_urls", c.name, c.def); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"notifications", func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS notifications (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            url_id INTEGER NOT NULL,
+            snapshot_id INTEGER NOT NULL DEFAULT 0,
+            channel TEXT NOT NULL,
+            title TEXT NOT NULL DEFAULT '',
+            timestamp DATETIME NOT NULL,
+            success INTEGER NOT NULL,
+            error TEXT NOT NULL DEFAULT ''
+        )`); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_notifications_url_id ON notifications(url_id, id)"); err != nil {
+			return err
+		}
+		_, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_notifications_timestamp ON notifications(timestamp)")
+		return err
+	}},
+}
+
+// migrateDatabase applies the migrations the database has not seen yet, each
+// in its own transaction together with the version bump.
+func migrateDatabase() error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this build supports (%d)", version, len(migrations))
+	}
+	for ; version < len(migrations); version++ {
+		m := migrations[version]
+		if err := applyMigration(version+1, m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", version+1, m.name, err)
+		}
+		slog.Info("Applied database migration", "event", "migrate", "version", version+1, "name", m.name)
+	}
+	return nil
+}
+
+// applyMigration runs m and records the database as being at version.
+func applyMigration(version int, m migration) error {
+	tx, err := writeDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	// PRAGMA does not accept bound parameters.
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateContentHash adds url_snapshots.content_hash and fills it in for
+// existing snapshots.
+func migrateContentHash(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "url_snapshots", "content_hash", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	rows, err := tx.Query("SELECT id, content, content_path FROM url_snapshots WHERE content_hash = ''")
+	if err != nil {
+		return err
+	}
+	hashes := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var content, contentPath sql.NullString
+		if err := rows.Scan(&id, &content, &contentPath); err != nil {
+			rows.Close()
+			return err
+		}
+		c, err := snapshotContent(content, contentPath, false)
+		if err != nil {
+			slog.Warn("Error reading snapshot; leaving its hash empty", "snapshot_id", id, "error", err)
+			continue
+		}
+		hashes[id] = contentHash(c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, h := range hashes {
+		if _, err := tx.Exec("UPDATE url_snapshots SET content_hash = ? WHERE id = ?", h, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// timestampColumns lists the DATETIME columns, as table and column.
+var timestampColumns = [][2]string{
+	{"url_snapshots", "timestamp"},
+	{"url_last_check", "last_check"},
+	{"url_checks", "timestamp"},
+	{"snapshot_diffs", "last_used"},
+}
+
+// migrateTimestamps rewrites times stored in the driver's default format,
+// time.Time's String form in local time, in the format of dbTime. The old
+// format neither sorts nor compares correctly as text.
+func migrateTimestamps(tx *sql.Tx) error {
+	for _, c := range timestampColumns {
+		rows, err := tx.Query(fmt.Sprintf("SELECT rowid, %s FROM %s", c[1], c[0]))
+		if err != nil {
+			return err
+		}
+		times := make(map[int64]time.Time)
+		for rows.Next() {
+			var id int64
+			var t time.Time
+			if err := rows.Scan(&id, &t); err != nil {
+				rows.Close()
+				return fmt.Errorf("%s.%s: %w", c[0], c[1], err)
+			}
+			times[id] = t
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for id, t := range times {
+			if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE rowid = ?", c[0], c[1]), dbTime(t), id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateChannels replaces monitored_urls.push_enabled with a list of
+// notification channels. URLs with push notifications enabled get Pushover.
+func migrateChannels(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "monitored_urls", "channels", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE monitored_urls SET channels = ? WHERE push_enabled != 0", channelPushover); err != nil {
+		return err
+	}
+	_, err := tx.Exec("ALTER TABLE monitored_urls DROP COLUMN push_enabled")
+	return err
+}
+
+// migrateSnapshotFTS creates the full-text index of snapshot content used by
+// /search and indexes existing snapshots. The index is contentless, since
+// the content is already stored in url_snapshots or in snapshot files.
+func migrateSnapshotFTS(tx *sql.Tx) error {
+	if _, err := tx.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS snapshot_fts USING fts5(content, content='', contentless_delete=1)"); err != nil {
+		return err
+	}
+	rows, err := tx.Query("SELECT id, content, content_path FROM url_snapshots")
+	if err != nil {
+		return err
+	}
+	contents := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var content, contentPath sql.NullString
+		if err := rows.Scan(&id, &content, &contentPath); err != nil {
+			rows.Close()
+			return err
+		}
+		c, err := snapshotContent(content, contentPath, false)
+		if err != nil {
+			slog.Warn("Error reading snapshot; leaving it out of the search index", "snapshot_id", id, "error", err)
+			continue
+		}
+		contents[id] = c
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, c := range contents {
+		if err := indexSnapshot(tx, id, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSchemaCurrent returns an error unless every migration has been
+// applied. It stands in for migrateDatabase when the database is read-only.
+func checkSchemaCurrent() error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version != len(migrations) {
+		return fmt.Errorf("database schema version is %d, want %d; run once without -readonly to migrate it", version, len(migrations))
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testSite serves a page whose body can be changed between checks.
+type testSite struct {
+	mu   sync.Mutex
+	body string
+}
+
+func (s *testSite) set(body string) {
+	s.mu.Lock()
+	s.body = body
+	s.mu.Unlock()
+}
+
+func (s *testSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte("<html><head><title>Test</title></head><body>" + s.body + "</body></html>"))
+}
+
+func countSnapshots(t *testing.T, urlID int) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM url_snapshots WHERE url_id = ?", urlID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestCheckDetectsChanges(t *testing.T) {
+	openTestDB(t)
+	site := &testSite{}
+	srv := httptest.NewServer(site)
+	defer srv.Close()
+
+	m := addTestURL(t, MonitoredURL{URL: srv.URL, NormalizeWhitespace: true})
+	m, err := loadMonitoredURL(m.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	um := &urlMonitor{m: m, rs: newRegionState(), state: newMonitorState()}
+	ctx := context.Background()
+
+	steps := []struct {
+		name      string
+		body      string
+		changed   bool
+		snapshots int
+	}{
+		{"first capture", "<p>Hello world</p>", true, 1},
+		{"no change", "<p>Hello world</p>", false, 1},
+		{"whitespace only", "\n\n    <p>Hello \t  world</p>  \n\n", false, 1},
+		{"real change", "<p>Goodbye world</p>", true, 2},
+		{"no change after the change", "<p>Goodbye world</p>", false, 2},
+	}
+	for _, step := range steps {
+		site.set(step.body)
+
+		res, err := fetchContent(ctx, m)
+		if err != nil {
+			t.Fatalf("%s: fetchContent: %v", step.name, err)
+		}
+		if _, changed, err := um.detectChange(ctx, res); err != nil {
+			t.Fatalf("%s: detectChange: %v", step.name, err)
+		} else if changed != step.changed {
+			t.Errorf("%s: detectChange reported changed = %v, want %v", step.name, changed, step.changed)
+		}
+
+		if !um.check(ctx, false) {
+			t.Fatalf("%s: check stopped monitoring", step.name)
+		}
+		if n := countSnapshots(t, m.ID); n != step.snapshots {
+			t.Errorf("%s: %d snapshots, want %d", step.name, n, step.snapshots)
+		}
+		var changed bool
+		if err := db.QueryRow("SELECT changed FROM url_checks WHERE url_id = ? ORDER BY id DESC LIMIT 1", m.ID).Scan(&changed); err != nil {
+			t.Fatalf("%s: reading the recorded check: %v", step.name, err)
+		} else if changed != step.changed {
+			t.Errorf("%s: check recorded changed = %v, want %v", step.name, changed, step.changed)
+		}
+	}
+
+	var initial bool
+	if err := db.QueryRow("SELECT initial FROM url_snapshots WHERE url_id = ? ORDER BY id LIMIT 1", m.ID).Scan(&initial); err != nil {
+		t.Fatal(err)
+	}
+	if !initial {
+		t.Error("the first capture isn't marked initial")
+	}
+}
+
+func latestSnapshotID(t *testing.T, urlID int) int {
+	t.Helper()
+	var id int
+	if err := db.QueryRow("SELECT id FROM url_snapshots WHERE url_id = ? ORDER BY timestamp DESC, id DESC LIMIT 1", urlID).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// TestCheckAfterDeletingLatestSnapshot deletes the latest snapshot behind
+// a monitor's back, as retention or the history page would, and checks
+// that the next check compares against the one before it.
+func TestCheckAfterDeletingLatestSnapshot(t *testing.T) {
+	openTestDB(t)
+	site := &testSite{}
+	srv := httptest.NewServer(site)
+	defer srv.Close()
+
+	m := addTestURL(t, MonitoredURL{URL: srv.URL})
+	m, err := loadMonitoredURL(m.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	um := &urlMonitor{m: m, rs: newRegionState(), state: newMonitorState()}
+	ctx := context.Background()
+	check := func(body string) {
+		t.Helper()
+		site.set(body)
+		if !um.check(ctx, false) {
+			t.Fatal("check stopped monitoring")
+		}
+	}
+
+	check("<p>A</p>")
+	check("<p>B</p>")
+	if n := countSnapshots(t, m.ID); n != 2 {
+		t.Fatalf("%d snapshots, want 2", n)
+	}
+
+	// With B gone, A is the baseline again: B is a change once more...
+	if err := deleteSnapshot(latestSnapshotID(t, m.ID)); err != nil {
+		t.Fatal(err)
+	}
+	check("<p>B</p>")
+	if n := countSnapshots(t, m.ID); n != 2 {
+		t.Errorf("after re-fetching B: %d snapshots, want 2", n)
+	}
+	if content, err := loadSnapshotContent(latestSnapshotID(t, m.ID)); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(content, "B") {
+		t.Errorf("latest snapshot is %q, want B", content)
+	}
+
+	// ...and A is not.
+	if err := deleteSnapshot(latestSnapshotID(t, m.ID)); err != nil {
+		t.Fatal(err)
+	}
+	check("<p>A</p>")
+	if n := countSnapshots(t, m.ID); n != 1 {
+		t.Errorf("after re-fetching A: %d snapshots, want 1", n)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// A monitorHandle controls a running monitorURL goroutine.
+type monitorHandle struct {
+	cancel context.CancelFunc
+	// checkNow requests an immediate check; it is buffered so that a
+	// request made during a check is picked up right after it.
+	checkNow chan struct{}
+	// state is kept up to date by the goroutine for the status page.
+	state *monitorState
+}
+
+var (
+	// monitors holds the handle of each running monitorURL goroutine,
+	// keyed by URL id.
+	monitors   = map[int]monitorHandle{}
+	monitorsMu sync.Mutex
+	// monitorsWG counts running monitorURL goroutines, including ones that
+	// have been stopped but are still finishing a check.
+	monitorsWG sync.WaitGroup
+)
+
+// startMonitors starts monitoring every URL in the database that is not
+// paused.
+func startMonitors() {
+	rows, err := db.Query("SELECT " + monitoredURLColumns + " FROM monitored_urls")
+	if err != nil {
+		log.Fatalf("Error querying monitored URLs: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m, err := scanMonitoredURL(rows)
+		if err != nil {
+			slog.Error("Error scanning monitored URL", "error", err)
+			continue
+		}
+		if m.Paused {
+			m.logger().Info("Monitoring is paused", "event", "paused")
+			continue
+		}
+		startMonitor(m)
+	}
+}
+
+// startMonitor starts monitoring m, first stopping any goroutine already
+// monitoring the same URL id.
+func startMonitor(m MonitoredURL) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := monitorHandle{cancel: cancel, checkNow: make(chan struct{}, 1), state: newMonitorState()}
+	monitorsMu.Lock()
+	if old, ok := monitors[m.ID]; ok {
+		old.cancel()
+	}
+	monitors[m.ID] = h
+	monitorsMu.Unlock()
+	monitorsWG.Add(1)
+	go func() {
+		defer monitorsWG.Done()
+		defer h.state.set(monitorStopped)
+		monitorURL(ctx, m, h.checkNow, h.state)
+	}()
+}
+
+// activeMonitors returns how many URLs are being monitored.
+func activeMonitors() int {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+	return len(monitors)
+}
+
+// stopMonitor stops the goroutine monitoring the URL id, if there is one.
+func stopMonitor(id int) {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+	if h, ok := monitors[id]; ok {
+		h.cancel()
+		delete(monitors, id)
+	}
+}
+
+// stopAllMonitors stops every monitoring goroutine and waits until they have
+// exited or ctx is done, whichever comes first.
+func stopAllMonitors(ctx context.Context) {
+	monitorsMu.Lock()
+	for id, h := range monitors {
+		h.cancel()
+		delete(monitors, id)
+	}
+	monitorsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		monitorsWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("Timed out waiting for monitoring to stop", "event", "stop")
+	}
+}
+
+// requestCheck asks the goroutine monitoring the URL id to check it right
+// away. It reports false if the URL isn't being monitored.
+func requestCheck(id int) bool {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+	h, ok := monitors[id]
+	if !ok {
+		return false
+	}
+	select {
+	case h.checkNow <- struct{}{}:
+	default:
+		// A check is already pending.
+	}
+	return true
+}
+
+// requestAllChecks asks every monitoring goroutine to check its URL right
+// away and returns how many were asked. The checks still wait their turn
+// for a fetch slot, so no more than -max-concurrent-fetches run at once.
+func requestAllChecks() int {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+	for _, h := range monitors {
+		select {
+		case h.checkNow <- struct{}{}:
+		default:
+		}
+	}
+	return len(monitors)
+}
+
+// sleepCtx waits for d and reports whether it elapsed before ctx was
+// cancelled.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// jitterPercent is the maximum random variation of check intervals, as a
+// percentage of the frequency. It is kept below 100 so that intervals stay
+// positive.
+var jitterPercent int
+
+// aligned reports whether m's checks are aligned to a phase, by an offset
+// or a start time.
+func (m MonitoredURL) aligned() bool {
+	return m.Offset > 0 || m.StartAt != ""
+}
+
+// checkInterval returns the time until the next check of m: its frequency,
+// varied by up to jitterPercent in either direction, or the time until its
+// next scheduled check. URLs aligned to an offset or a start time are not
+// jittered, since that would undo the alignment.
+func checkInterval(m MonitoredURL) time.Duration {
+	if sched := m.cronSchedule(); sched != nil {
+		if next := sched.next(time.Now()); !next.IsZero() {
+			return time.Until(next)
+		}
+		return m.Frequency
+	}
+	if jitterPercent <= 0 || m.aligned() {
+		return m.Frequency
+	}
+	maxJitter := int64(m.Frequency) * int64(jitterPercent) / 100
+	if maxJitter <= 0 {
+		return m.Frequency
+	}
+	d := m.Frequency + time.Duration(rand.Int63n(2*maxJitter+1)-maxJitter)
+	if d <= 0 {
+		return m.Frequency
+	}
+	return d
+}
+
+// initialJitter returns a random delay of up to jitterPercent of freq.
+func initialJitter(freq time.Duration) time.Duration {
+	maxJitter := int64(freq) * int64(jitterPercent) / 100
+	if maxJitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(maxJitter + 1))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxNotificationAge, if positive, is how long the record of each
+// notification attempt is kept. Set with -max-notification-age.
+var maxNotificationAge = 30 * 24 * time.Hour
+
+// notificationPageSize is how many attempts the notifications page lists.
+const notificationPageSize = 200
+
+// NotificationAttempt is one try at sending a notification over one
+// channel, as recorded in the notifications table.
+type NotificationAttempt struct {
+	ID    int
+	URLID int
+	// SnapshotID is the snapshot a change notification announced, or 0
+	// for other notifications, such as of a URL going down.
+	SnapshotID int64
+	Channel    string
+	Title      string
+	Timestamp  time.Time
+	Success    bool
+	// Error says why an attempt failed, e.g. that the channel isn't
+	// configured or what the service answered.
+	Error string
+}
+
+// When returns the attempt's time for display.
+func (a NotificationAttempt) When() string {
+	return a.Timestamp.Local().Format(time.RFC1123)
+}
+
+// DiffPath returns the path of the diff a change notification announced.
+func (a NotificationAttempt) DiffPath() string {
+	return snapshotDiffPath(a.URLID, a.SnapshotID)
+}
+
+// recordNotification logs and records the outcome, err, of sending a
+// notification titled title about urlID over channel.
+func recordNotification(urlID int, snapshotID int64, channel, title string, err error) {
+	errText := ""
+	if err != nil {
+		errText = err.Error()
+		slog.Error("Error sending notification", "event", "notify_failed", "url_id", urlID, "channel", channel, "error", err)
+	} else {
+		slog.Info("Notification sent", "event", "notify_sent", "url_id", urlID, "channel", channel)
+	}
+	_, dbErr := writeDB.Exec("INSERT INTO notifications (url_id, snapshot_id, channel, title, timestamp, success, error) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		urlID, snapshotID, channel, title, dbTime(time.Now()), boolToInt(err == nil), errText)
+	if dbErr != nil {
+		slog.Error("Error recording notification", "event", "notify_record_failed", "url_id", urlID, "channel", channel, "error", dbErr)
+	}
+}
+
+// loadNotificationAttempts returns up to limit of a URL's most recent
+// notification attempts, newest first.
+func loadNotificationAttempts(urlID, limit int) ([]NotificationAttempt, error) {
+	rows, err := db.Query("SELECT id, url_id, snapshot_id, channel, title, timestamp, success, error FROM notifications WHERE url_id = ? ORDER BY id DESC LIMIT ?", urlID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var attempts []NotificationAttempt
+	for rows.Next() {
+		var a NotificationAttempt
+		if err := rows.Scan(&a.ID, &a.URLID, &a.SnapshotID, &a.Channel, &a.Title, &a.Timestamp, &a.Success, &a.Error); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// pruneNotifications deletes the record of notification attempts older
+// than maxNotificationAge, returning how many were deleted.
+func pruneNotifications(now time.Time) (int64, error) {
+	if maxNotificationAge <= 0 {
+		return 0, nil
+	}
+	res, err := writeDB.Exec("DELETE FROM notifications WHERE timestamp < ?", dbTime(now.Add(-maxNotificationAge)))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// NotificationsView is the data of the notifications page.
+type NotificationsView struct {
+	URLID    int
+	URL      string
+	Attempts []NotificationAttempt
+}
+
+// notificationsHandler lists the recent notification attempts for a URL,
+// so that one that never arrived can be told apart from a change that was
+// never detected.
+func notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	view := NotificationsView{URLID: id}
+	err = db.QueryRow("SELECT url FROM monitored_urls WHERE id = ?", id).Scan(&view.URL)
+	if err == sql.ErrNoRows {
+		http.Error(w, "URL not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if view.Attempts, err = loadNotificationAttempts(id, notificationPageSize); err != nil {
+		slog.Error("Error reading notifications", "url_id", id, "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := notificationsTmpl.Execute(w, view); err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRecordFailedNotification(t *testing.T) {
+	openTestDB(t)
+	logged := recordErrors(t)
+	m := addTestURL(t, MonitoredURL{URL: "https://example.com/", Paused: true})
+	recordNotification(m.ID, 0, channelEmail, notificationTitle, errors.New("SMTP_HOST is not set"))
+
+	// Failed deliveries are logged as errors, so -log-level=warn keeps them.
+	if len(logged.errors) != 1 {
+		t.Fatalf("logged %q, want one error", logged.errors)
+	}
+	for _, want := range []string{"event=notify_failed", "url_id=" + itoa(m.ID), "channel=email", "SMTP_HOST is not set"} {
+		if !strings.Contains(logged.errors[0], want) {
+			t.Errorf("logged %q, want it to include %q", logged.errors[0], want)
+		}
+	}
+	attempts, err := loadNotificationAttempts(m.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 1 || attempts[0].Success || attempts[0].Error != "SMTP_HOST is not set" {
+		t.Errorf("recorded %+v, want one failed attempt", attempts)
+	}
+}
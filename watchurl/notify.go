@@ -0,0 +1,73 @@
+package main
+
+import "time"
+
+// sendChangeNotification notifies the given channels of a change recorded
+// as snapshot snapshotID. Each attempt is recorded (see recordNotification);
+// channels that aren't configured are recorded as failed.
+func sendChangeNotification(channels []string, monitoredURL string, urlID int, changeTime time.Time, snapshotID int64) {
+	var message string
+	if hasChannel(channels, channelPushover) || hasChannel(channels, channelEmail) ||
+		hasChannel(channels, channelTelegram) || hasChannel(channels, channelDiscord) ||
+		hasChannel(channels, channelNtfy) || hasChannel(channels, channelSlack) ||
+		hasChannel(channels, channelMatrix) || hasChannel(channels, channelDesktop) {
+		message = notificationMessage(NotificationData{URL: monitoredURL, URLID: urlID, ChangeTime: changeTime, SnapshotID: snapshotID})
+	}
+	if hasChannel(channels, channelPushover) {
+		recordNotification(urlID, snapshotID, channelPushover, notificationTitle, sendPushoverNotification(monitoredURL, message, urlID))
+	}
+	if hasChannel(channels, channelEmail) {
+		recordNotification(urlID, snapshotID, channelEmail, notificationTitle, sendEmailNotification(monitoredURL, message))
+	}
+	if hasChannel(channels, channelTelegram) {
+		recordNotification(urlID, snapshotID, channelTelegram, notificationTitle, sendTelegramNotification(message, urlID))
+	}
+	if hasChannel(channels, channelWebhook) {
+		recordNotification(urlID, snapshotID, channelWebhook, notificationTitle, sendWebhookNotification(monitoredURL, changeTime, snapshotID))
+	}
+	if hasChannel(channels, channelDiscord) {
+		recordNotification(urlID, snapshotID, channelDiscord, notificationTitle, sendDiscordNotification(monitoredURL, message, urlID, changeTime, snapshotID))
+	}
+	if hasChannel(channels, channelNtfy) {
+		recordNotification(urlID, snapshotID, channelNtfy, notificationTitle, sendNtfyNotification(monitoredURL, message, urlID, snapshotID))
+	}
+	if hasChannel(channels, channelSlack) {
+		recordNotification(urlID, snapshotID, channelSlack, notificationTitle, sendSlackNotification(monitoredURL, message, urlID, snapshotID))
+	}
+	if hasChannel(channels, channelMatrix) {
+		recordNotification(urlID, snapshotID, channelMatrix, notificationTitle, sendMatrixNotification(monitoredURL, message, urlID, snapshotID))
+	}
+	if hasChannel(channels, channelDesktop) {
+		recordNotification(urlID, snapshotID, channelDesktop, notificationTitle, sendDesktopNotification(notificationTitle, message))
+	}
+}
+
+// sendNotification sends a message with the given title about the URL with
+// id urlID over the given channels, using opts for Pushover, and records
+// each attempt. Webhooks only carry changes, so they are skipped.
+func sendNotification(urlID int, channels []string, opts PushoverOptions, title, message, monitoredURL string) {
+	if hasChannel(channels, channelPushover) {
+		recordNotification(urlID, 0, channelPushover, title, sendPushoverMessage(title, message, monitoredURL, opts))
+	}
+	if hasChannel(channels, channelEmail) {
+		recordNotification(urlID, 0, channelEmail, title, sendEmailMessage(title, message+"\n\n"+monitoredURL))
+	}
+	if hasChannel(channels, channelTelegram) {
+		recordNotification(urlID, 0, channelTelegram, title, sendTelegramMessage(title+"\n"+message+"\n\n"+monitoredURL))
+	}
+	if hasChannel(channels, channelDiscord) {
+		recordNotification(urlID, 0, channelDiscord, title, sendDiscordMessage(title, message, monitoredURL))
+	}
+	if hasChannel(channels, channelNtfy) {
+		recordNotification(urlID, 0, channelNtfy, title, sendNtfyMessage(title, message, monitoredURL))
+	}
+	if hasChannel(channels, channelSlack) {
+		recordNotification(urlID, 0, channelSlack, title, sendSlackTitled(title, message, monitoredURL))
+	}
+	if hasChannel(channels, channelMatrix) {
+		recordNotification(urlID, 0, channelMatrix, title, sendMatrixTitled(title, message, monitoredURL))
+	}
+	if hasChannel(channels, channelDesktop) {
+		recordNotification(urlID, 0, channelDesktop, title, sendDesktopNotification(title, message+"\n"+monitoredURL))
+	}
+}
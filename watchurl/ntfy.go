@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const defaultNtfyServer = "https://ntfy.sh"
+
+// sendNtfyNotification publishes a change notification to the ntfy topic.
+// If BASE_URL is set to the address of this server's web UI, clicking the
+// notification opens the diff that introduced snapshot snapshotID;
+// otherwise it opens the monitored URL.
+func sendNtfyNotification(monitoredURL, message string, urlID int, snapshotID int64) error {
+	click := monitoredURL
+	if base := os.Getenv("BASE_URL"); base != "" {
+		click = strings.TrimRight(base, "/") + snapshotDiffPath(urlID, snapshotID)
+	}
+	return sendNtfyMessage(notificationTitle, message, click)
+}
+
+// sendNtfyMessage publishes message with the given title to NTFY_TOPIC on
+// NTFY_SERVER, authenticating with NTFY_TOKEN if it is set.
+func sendNtfyMessage(title, message, click string) error {
+	topic := os.Getenv("NTFY_TOPIC")
+	if topic == "" {
+		return errors.New("missing ntfy topic")
+	}
+	server := os.Getenv("NTFY_SERVER")
+	if server == "" {
+		server = defaultNtfyServer
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(server, "/")+"/"+topic, strings.NewReader(message))
+	if err != nil {
+		return fmt.Errorf("creating ntfy request: %w", err)
+	}
+	req.Header.Set("Title", title)
+	req.Header.Set("Tags", "eyes")
+	if click != "" {
+		req.Header.Set("Click", click)
+	}
+	if token := os.Getenv("NTFY_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("ntfy returned %s: %s", resp.Status, body)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+)
+
+// orphanedURL matches rows whose url_id no longer names a monitored URL.
+const orphanedURL = "url_id NOT IN (SELECT id FROM monitored_urls)"
+
+// removeOrphanedRows deletes the snapshots, checks, last check times and
+// validators left behind by URLs deleted before deleteMonitoredURL removed
+// all of them, along with the orphaned snapshots' cached diffs, search index
+// entries and files. The rows go in one transaction; the files only once
+// it has committed.
+func removeOrphanedRows() error {
+	tx, err := writeDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	paths, err := orphanedSnapshotFiles(tx)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM snapshot_diffs
+        WHERE id1 IN (SELECT id FROM url_snapshots WHERE ` + orphanedURL + `)
+           OR id2 IN (SELECT id FROM url_snapshots WHERE ` + orphanedURL + `)`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM snapshot_fts WHERE rowid IN (SELECT id FROM url_snapshots WHERE " + orphanedURL + ")"); err != nil {
+		return err
+	}
+	var removed int64
+	for _, table := range []string{"url_snapshots", "url_checks", "url_last_check", "url_validators", "notifications"} {
+		res, err := tx.Exec("DELETE FROM " + table + " WHERE " + orphanedURL)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		removed += n
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	removeSnapshotFiles(paths)
+	if removed > 0 {
+		slog.Info("Removed rows left behind by deleted URLs", "event", "orphans", "rows", removed)
+	}
+	return nil
+}
+
+// orphanedSnapshotFiles lists the content files of snapshots whose URL no
+// longer exists.
+func orphanedSnapshotFiles(tx *sql.Tx) ([]string, error) {
+	rows, err := tx.Query("SELECT content_path FROM url_snapshots WHERE content_path IS NOT NULL AND " + orphanedURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
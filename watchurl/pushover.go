@@ -1,13 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
-	"time"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -15,50 +18,143 @@ import (
 func init() {
 	// Load environment variables from the .env file if it exists.
 	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, relying on environment variables")
+		slog.Info("No .env file found, relying on environment variables")
 	}
 }
 
 const (
 	pushoverAPIEndpoint = "https://api.pushover.net/1/messages.json"
+	notificationTitle   = "URL Change Notification"
 )
 
-func sendPushoverNotification(monitoredURL string, changeTime time.Time) {
+// Pushover's emergency priority repeats a message every Retry seconds, at
+// least pushoverMinRetry apart, until it is acknowledged or Expire seconds,
+// at most pushoverMaxExpire, have passed.
+const (
+	pushoverPriorityEmergency = 2
+	pushoverMinRetry          = 30
+	pushoverMaxExpire         = 10800
+)
+
+// pushoverSoundPattern matches the names Pushover gives its sounds, built
+// in or uploaded.
+var pushoverSoundPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// PushoverOptions are the Pushover parameters of a URL's
+// notifications. The zero value sends with Pushover's defaults.
+type PushoverOptions struct {
+	// Priority is from -2 (no alert) through 0 (normal) to 2 (emergency).
+	Priority int `json:"priority"`
+	// Sound, if set, names the sound to play instead of the user's default.
+	Sound string `json:"sound,omitempty"`
+	// Retry and Expire, in seconds, apply to emergency priority only.
+	Retry  int `json:"retry,omitempty"`
+	Expire int `json:"expire,omitempty"`
+}
+
+// PriorityName describes the priority as the add form offers it.
+func (o PushoverOptions) PriorityName() string {
+	switch o.Priority {
+	case -2:
+		return "lowest"
+	case -1:
+		return "low"
+	case 1:
+		return "high"
+	case pushoverPriorityEmergency:
+		return "emergency"
+	}
+	return "normal"
+}
+
+// parsePushoverOptions validates Pushover options as submitted with a form.
+// Emergency priority needs both a retry and an expiry, as the API requires;
+// other priorities ignore them.
+func parsePushoverOptions(priority, sound, retry, expire string) (PushoverOptions, error) {
+	var o PushoverOptions
+	var err error
+	if priority = strings.TrimSpace(priority); priority != "" {
+		if o.Priority, err = strconv.Atoi(priority); err != nil || o.Priority < -2 || o.Priority > pushoverPriorityEmergency {
+			return o, errors.New("priority must be from -2 to 2")
+		}
+	}
+	if o.Sound = strings.TrimSpace(sound); o.Sound != "" && !pushoverSoundPattern.MatchString(o.Sound) {
+		return o, fmt.Errorf("invalid sound %q", o.Sound)
+	}
+	if o.Priority != pushoverPriorityEmergency {
+		return o, nil
+	}
+	if o.Retry, err = strconv.Atoi(strings.TrimSpace(retry)); err != nil || o.Retry < pushoverMinRetry {
+		return o, fmt.Errorf("emergency priority needs a retry of at least %d seconds", pushoverMinRetry)
+	}
+	if o.Expire, err = strconv.Atoi(strings.TrimSpace(expire)); err != nil || o.Expire <= 0 || o.Expire > pushoverMaxExpire {
+		return o, fmt.Errorf("emergency priority needs an expiry of at most %d seconds", pushoverMaxExpire)
+	}
+	return o, nil
+}
+
+// loadPushoverOptions reads the Pushover options of the URL with the given
+// id, falling back to the defaults if that fails.
+func loadPushoverOptions(urlID int) PushoverOptions {
+	var o PushoverOptions
+	err := db.QueryRow("SELECT pushover_priority, pushover_sound, pushover_retry, pushover_expire FROM monitored_urls WHERE id = ?", urlID).
+		Scan(&o.Priority, &o.Sound, &o.Retry, &o.Expire)
+	if err != nil {
+		slog.Error("Error reading Pushover options", "url_id", urlID, "error", err)
+		return PushoverOptions{}
+	}
+	return o
+}
+
+func sendPushoverNotification(monitoredURL, message string, urlID int) error {
+	return sendPushoverMessage(notificationTitle, message, monitoredURL, loadPushoverOptions(urlID))
+}
+
+// sendPushoverMessage sends a Pushover message with the given title and body,
+// linking to monitoredURL.
+func sendPushoverMessage(title, message, monitoredURL string, opts PushoverOptions) error {
 	// Read API keys from environment variables
 	pushoverUserKey := os.Getenv("PUSHOVER_USER_KEY")
 	pushoverAPIToken := os.Getenv("PUSHOVER_API_TOKEN")
 
 	// Validate that keys are set
 	if pushoverUserKey == "" || pushoverAPIToken == "" {
-		log.Println("Missing Pushover API key or user key")
-		return
+		return errors.New("missing Pushover API key or user key")
 	}
 
-	message := fmt.Sprintf("Change detected on %s at %s", monitoredURL, changeTime.Format(time.RFC1123))
 	data := url.Values{}
 	data.Set("token", pushoverAPIToken)
 	data.Set("user", pushoverUserKey)
 	data.Set("message", message)
-	data.Set("title", "URL Change Notification")
+	data.Set("title", title)
 	data.Set("url", monitoredURL)
 	data.Set("url_title", "View URL")
+	if opts.Priority != 0 {
+		data.Set("priority", strconv.Itoa(opts.Priority))
+	}
+	if opts.Priority == pushoverPriorityEmergency {
+		data.Set("retry", strconv.Itoa(opts.Retry))
+		data.Set("expire", strconv.Itoa(opts.Expire))
+	}
+	if opts.Sound != "" {
+		data.Set("sound", opts.Sound)
+	}
 
 	resp, err := http.PostForm(pushoverAPIEndpoint, data)
 	if err != nil {
-		log.Printf("Error sending Pushover notification: %v", err)
-		return
+		return err
 	}
 	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("Error reading response body: %v", err)
+		slog.Warn("Error reading Pushover response", "error", err)
 	} else {
-		log.Printf("Pushover response: %s", body)
+		slog.Debug("Pushover response", "body", string(body))
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Received non-OK HTTP status: %s", resp.Status)
-	} else {
-		log.Printf("Pushover notification sent successfully, status: %s", resp.Status)
+		return fmt.Errorf("Pushover returned %s", resp.Status)
 	}
+	slog.Debug("Pushover notification sent", "status", resp.Status)
+	return nil
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// errNoRaw is returned by loadSnapshotRaw for snapshots stored without
+// their response body.
+var errNoRaw = errors.New("no raw body stored")
+
+// loadSnapshotRaw returns the unmodified response body stored with a
+// snapshot.
+func loadSnapshotRaw(id int) (string, error) {
+	var raw sql.NullString
+	if err := db.QueryRow("SELECT raw FROM url_snapshots WHERE id = ?", id).Scan(&raw); err != nil {
+		return "", err
+	}
+	if !raw.Valid {
+		return "", errNoRaw
+	}
+	return raw.String, nil
+}
+
+// rawHandler serves the raw response body of a snapshot as plain text, so
+// that the monitored page's markup is shown rather than run.
+func rawHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	raw, err := loadSnapshotRaw(id)
+	if err == sql.ErrNoRows || err == errNoRaw {
+		http.Error(w, "Raw body not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write([]byte(raw))
+}
@@ -0,0 +1,32 @@
+package main
+
+import "net/http"
+
+// readOnly is set by -readonly. The database is then opened read-only, no
+// URLs are monitored and handlers that change anything answer 403, leaving
+// a dashboard that can only browse URLs, history and diffs.
+var readOnly bool
+
+// writeHandler wraps a handler that changes state so that it is refused in
+// read-only mode.
+func writeHandler(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if readOnly {
+			http.Error(w, "Read-only mode", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// writeMethodsHandler is writeHandler for handlers that also serve pages:
+// in read-only mode GET and HEAD requests are still passed through.
+func writeMethodsHandler(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if readOnly && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Read-only mode", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ReextractProgress reports the state of the background re-extraction job.
+type ReextractProgress struct {
+	Running  bool      `json:"running"`
+	URLID    int       `json:"url_id,omitempty"`
+	Total    int       `json:"total"`
+	Done     int       `json:"done"`
+	Updated  int       `json:"updated"`
+	Skipped  int       `json:"skipped"`
+	Errors   int       `json:"errors"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+var (
+	reextractMu       sync.Mutex
+	reextractProgress ReextractProgress
+)
+
+// reextractHandler starts a re-extraction job on POST (optionally limited to
+// one URL with id) and reports the job's progress as JSON on GET.
+func reextractHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		urlID := 0
+		if idStr := r.FormValue("id"); idStr != "" {
+			var err error
+			urlID, err = strconv.Atoi(idStr)
+			if err != nil {
+				http.Error(w, "Invalid id", http.StatusBadRequest)
+				return
+			}
+		}
+
+		reextractMu.Lock()
+		if reextractProgress.Running {
+			reextractMu.Unlock()
+			http.Error(w, "A re-extraction job is already running", http.StatusConflict)
+			return
+		}
+		reextractProgress = ReextractProgress{Running: true, URLID: urlID, Started: time.Now()}
+		reextractMu.Unlock()
+
+		go reextractSnapshots(urlID)
+		w.WriteHeader(http.StatusAccepted)
+	}
+
+	reextractMu.Lock()
+	progress := reextractProgress
+	reextractMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(progress); err != nil {
+		slog.Error("Error encoding re-extraction progress", "error", err)
+	}
+}
+
+// reextractSnapshots re-runs content extraction, under the URLs' current
+// settings, over the stored raw bodies of all snapshots (or only those of
+// urlID if it is non-zero). Snapshots stored without a raw body are skipped.
+func reextractSnapshots(urlID int) {
+	update := func(f func(p *ReextractProgress)) {
+		reextractMu.Lock()
+		f(&reextractProgress)
+		reextractMu.Unlock()
+	}
+	defer update(func(p *ReextractProgress) {
+		p.Running = false
+		p.Finished = time.Now()
+		slog.Info("Re-extraction finished", "event", "reextract", "updated", p.Updated, "skipped", p.Skipped, "errors", p.Errors)
+	})
+
+	query := "SELECT id, url_id FROM url_snapshots"
+	var args []interface{}
+	if urlID != 0 {
+		query += " WHERE url_id = ?"
+		args = append(args, urlID)
+	}
+	rows, err := db.Query(query+" ORDER BY id", args...)
+	if err != nil {
+		slog.Error("Error listing snapshots for re-extraction", "event", "reextract", "error", err)
+		update(func(p *ReextractProgress) { p.Errors++ })
+		return
+	}
+	type ref struct{ id, urlID int }
+	var refs []ref
+	for rows.Next() {
+		var s ref
+		if err := rows.Scan(&s.id, &s.urlID); err == nil {
+			refs = append(refs, s)
+		}
+	}
+	rows.Close()
+	update(func(p *ReextractProgress) { p.Total = len(refs) })
+
+	urls := map[int]MonitoredURL{}
+	for _, s := range refs {
+		m, ok := urls[s.urlID]
+		if !ok {
+			if m, err = loadMonitoredURL(s.urlID); err != nil {
+				slog.Error("Error loading URL for re-extraction", "event", "reextract", "url_id", s.urlID, "error", err)
+			}
+			urls[s.urlID] = m
+		}
+
+		updated, err := reextractSnapshot(m, s.id)
+		update(func(p *ReextractProgress) {
+			p.Done++
+			switch {
+			case err != nil:
+				p.Errors++
+			case updated:
+				p.Updated++
+			default:
+				p.Skipped++
+			}
+		})
+		if err != nil {
+			slog.Error("Error re-extracting snapshot", "event", "reextract", "url_id", s.urlID, "snapshot_id", s.id, "error", err)
+		}
+	}
+}
+
+// reextractSnapshot re-extracts one snapshot from its raw body. It reports
+// whether the stored content was rewritten.
+func reextractSnapshot(m MonitoredURL, id int) (bool, error) {
+	if m.ID == 0 {
+		return false, fmt.Errorf("snapshot %d belongs to no monitored URL", id)
+	}
+	var raw, contentCol, contentPath sql.NullString
+	var contentType string
+	var compressed bool
+	err := db.QueryRow("SELECT raw, content, content_path, content_type, compressed FROM url_snapshots WHERE id = ?", id).
+		Scan(&raw, &contentCol, &contentPath, &contentType, &compressed)
+	if err != nil {
+		return false, err
+	}
+	if !raw.Valid {
+		return false, nil
+	}
+	old, err := snapshotContent(contentCol, contentPath, compressed)
+	if err != nil {
+		return false, err
+	}
+	content, _ := extractContent(m, raw.String, contentType)
+	if content == old {
+		return false, nil
+	}
+
+	if contentPath.Valid && contentPath.String != "" {
+		if err := os.WriteFile(contentPath.String, []byte(content), 0o644); err != nil {
+			return false, err
+		}
+	}
+
+	tx, err := writeDB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if !contentPath.Valid || contentPath.String == "" {
+		inline, compressed, err := inlineContent(content)
+		if err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec("UPDATE url_snapshots SET content = ?, compressed = ?, content_hash = ? WHERE id = ?", inline, boolToInt(compressed), contentHash(content), id); err != nil {
+			return false, err
+		}
+	} else if _, err := tx.Exec("UPDATE url_snapshots SET content_hash = ? WHERE id = ?", contentHash(content), id); err != nil {
+		return false, err
+	}
+	if err := indexSnapshot(tx, int64(id), content); err != nil {
+		return false, err
+	}
+	// Rendered diffs of this snapshot are now stale.
+	if _, err := tx.Exec("DELETE FROM snapshot_diffs WHERE id1 = ? OR id2 = ?", id, id); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A Region is a named vantage point, reached through a proxy, from which a
+// URL is fetched in addition to the primary fetch.
+type Region struct {
+	Name  string
+	Proxy string
+}
+
+// parseRegions parses one "name=proxyURL" region per line.
+func parseRegions(text string) ([]Region, error) {
+	var regions []Region
+	seen := map[string]bool{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, proxy, ok := strings.Cut(line, "=")
+		name, proxy = strings.TrimSpace(name), strings.TrimSpace(proxy)
+		if !ok || name == "" || proxy == "" {
+			return nil, fmt.Errorf("region %q must be written as name=proxyURL", line)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate region %q", name)
+		}
+		if _, err := parseProxyURL(proxy); err != nil {
+			return nil, fmt.Errorf("region %q: %w", name, err)
+		}
+		seen[name] = true
+		regions = append(regions, Region{Name: name, Proxy: proxy})
+	}
+	return regions, nil
+}
+
+// formatRegions is the inverse of parseRegions.
+func formatRegions(regions []Region) string {
+	lines := make([]string, len(regions))
+	for i, r := range regions {
+		lines[i] = r.Name + "=" + r.Proxy
+	}
+	return strings.Join(lines, "\n")
+}
+
+// regionState tracks, for one monitoring goroutine, the latest snapshot of
+// each region and which regions currently disagree with the primary fetch,
+// so that notifications are only sent when something changes.
+type regionState struct {
+	last      map[string]*latestSnapshot
+	disagrees map[string]bool
+}
+
+func newRegionState() *regionState {
+	return &regionState{
+		last:      map[string]*latestSnapshot{},
+		disagrees: map[string]bool{},
+	}
+}
+
+// check fetches m through each of its regions. A snapshot is saved for a
+// region whenever its content changes over time, and a notification is sent
+// when a region starts or stops disagreeing with the primary result.
+func (rs *regionState) check(ctx context.Context, m MonitoredURL, primary FetchResult) {
+	profile := loadFetchProfile(m.Profile)
+	for _, region := range m.Regions {
+		last := rs.last[region.Name]
+		if last == nil {
+			last = &latestSnapshot{region: region.Name}
+			rs.last[region.Name] = last
+		}
+
+		p := profile
+		p.Proxy = region.Proxy
+		res, err := fetchContentWith(ctx, m, p)
+		if err != nil {
+			m.logger().Warn("Error fetching via region", "event", "region_fetch_failed", "region", region.Name, "error", err)
+			continue
+		}
+
+		if err := last.refresh(m, res.ContentType); err != nil {
+			m.logger().Error("Error reading last region snapshot", "region", region.Name, "error", err)
+			continue
+		}
+		if comparisonHash(m, res.ContentType, res.Content) != last.hash {
+			m.logger().Info("Change detected in region", "event", "change", "region", region.Name)
+			snap := res.snapshot(m)
+			snap.Region = region.Name
+			snap.Initial = last.id == 0
+			saveSnapshot(snap)
+		}
+
+		disagrees := !urlContentEqual(m, res.ContentType, res.Content, primary.Content)
+		if disagrees == rs.disagrees[region.Name] {
+			continue
+		}
+		rs.disagrees[region.Name] = disagrees
+		var message string
+		if disagrees {
+			message = fmt.Sprintf("Region %s sees different content for %s than the primary fetch (%s)", region.Name, m.URL, time.Now().Format(time.RFC1123))
+		} else {
+			message = fmt.Sprintf("Region %s agrees with the primary fetch for %s again (%s)", region.Name, m.URL, time.Now().Format(time.RFC1123))
+		}
+		m.logger().Warn(message, "event", "region_mismatch", "region", region.Name, "disagrees", disagrees)
+		sendNotification(m.ID, enabledChannels(m.ID), loadPushoverOptions(m.ID), "URL Region Mismatch", message, m.URL)
+	}
+}
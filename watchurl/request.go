@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// defaultBodyType is the content type of a request body that doesn't name
+// one: the encoding of an HTML form.
+const defaultBodyType = "application/x-www-form-urlencoded"
+
+// parseRequest validates a request method, body and body content type as
+// entered in a form and returns them as stored. The method is GET unless
+// given; only POST may carry a body, whose type defaults to
+// defaultBodyType.
+func parseRequest(method, body, bodyType string) (string, string, string, error) {
+	method = strings.ToUpper(strings.TrimSpace(method))
+	bodyType = strings.TrimSpace(bodyType)
+	switch method {
+	case "", http.MethodGet:
+		if body != "" {
+			return "", "", "", fmt.Errorf("a request body needs the POST method")
+		}
+		return http.MethodGet, "", "", nil
+	case http.MethodPost:
+		if bodyType == "" && body != "" {
+			bodyType = defaultBodyType
+		}
+		return method, body, bodyType, nil
+	default:
+		return "", "", "", fmt.Errorf("unsupported method %q: must be GET or POST", method)
+	}
+}
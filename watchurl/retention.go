@@ -0,0 +1,143 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"os"
+	"time"
+)
+
+var (
+	// maxSnapshotsPerURL, if positive, is how many snapshots are kept for
+	// each URL and region.
+	maxSnapshotsPerURL int
+	// maxSnapshotAge, if positive, is how long snapshots are kept.
+	maxSnapshotAge time.Duration
+)
+
+// retentionInterval is how often the retention policy is applied.
+const retentionInterval = time.Hour
+
+// runRetention applies the retention policy now and then every
+// retentionInterval. It does nothing if no policy is configured.
+func runRetention() {
+	snapshots := maxSnapshotsPerURL > 0 || maxSnapshotAge > 0
+	if !snapshots && maxCheckAge <= 0 {
+		return
+	}
+	for {
+		now := time.Now()
+		if snapshots {
+			if n, err := pruneSnapshots(now); err != nil {
+				slog.Error("Error pruning snapshots", "event", "prune", "error", err)
+			} else if n > 0 {
+				slog.Info("Pruned snapshots under the retention policy", "event", "prune", "snapshots", n)
+			}
+		}
+		if n, err := pruneChecks(now); err != nil {
+			slog.Error("Error pruning checks", "event", "prune", "error", err)
+		} else if n > 0 {
+			slog.Info("Pruned the record of old checks", "event", "prune", "checks", n, "max_age", maxCheckAge)
+		}
+		if n, err := pruneNotifications(now); err != nil {
+			slog.Error("Error pruning notifications", "event", "prune", "error", err)
+		} else if n > 0 {
+			slog.Info("Pruned the record of old notifications", "event", "prune", "notifications", n, "max_age", maxNotificationAge)
+		}
+		time.Sleep(retentionInterval)
+	}
+}
+
+// pruneSnapshots deletes snapshots beyond maxSnapshotsPerURL or older than
+// maxSnapshotAge, returning how many were deleted. The latest snapshot of
+// each URL and region is always kept, since monitoring compares against it.
+func pruneSnapshots(now time.Time) (int, error) {
+	rows, err := db.Query(`SELECT id, url_id, region, timestamp, content_path FROM url_snapshots
+        ORDER BY url_id, region, timestamp DESC, id DESC`)
+	if err != nil {
+		return 0, err
+	}
+	type group struct {
+		urlID  int
+		region string
+	}
+	var (
+		current group
+		rank    int
+		started bool
+		ids     []int
+		paths   []string
+	)
+	for rows.Next() {
+		var id int
+		var g group
+		var ts string
+		var path sql.NullString
+		if err := rows.Scan(&id, &g.urlID, &g.region, &ts, &path); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !started || g != current {
+			current, rank, started = g, 0, true
+		}
+		rank++
+		if rank == 1 {
+			continue
+		}
+
+		prune := maxSnapshotsPerURL > 0 && rank > maxSnapshotsPerURL
+		if !prune && maxSnapshotAge > 0 {
+			if t, err := parseStoredTime(ts); err == nil && now.Sub(t) > maxSnapshotAge {
+				prune = true
+			}
+		}
+		if prune {
+			ids = append(ids, id)
+			if path.Valid && path.String != "" {
+				paths = append(paths, path.String)
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	tx, err := writeDB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err := deleteSnapshotRows(tx, id); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			slog.Error("Error removing snapshot file", "path", path, "error", err)
+		}
+	}
+	return len(ids), nil
+}
+
+// deleteSnapshotRows deletes snapshot id along with its search index entry
+// and cached diffs within tx. Its content file, if any, is left for the
+// caller to remove once tx has committed.
+func deleteSnapshotRows(tx *sql.Tx, id int) error {
+	if _, err := tx.Exec("DELETE FROM snapshot_diffs WHERE id1 = ? OR id2 = ?", id, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM snapshot_fts WHERE rowid = ?", id); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM url_snapshots WHERE id = ?", id)
+	return err
+}
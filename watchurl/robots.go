@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// respectRobots, set with -respect-robots, makes fetches obey each host's
+// robots.txt: disallowed URLs are not fetched, and requests to a host are
+// spaced out by its Crawl-delay.
+var respectRobots bool
+
+const (
+	// robotsTTL is how long a host's robots.txt is cached.
+	robotsTTL = 24 * time.Hour
+	// robotsErrorTTL is how long a robots.txt that could not be fetched is
+	// treated as allowing everything before it is tried again.
+	robotsErrorTTL = time.Hour
+	// maxRobotsBytes bounds how much of a robots.txt is read.
+	maxRobotsBytes = 512 << 10
+)
+
+// robotsRule is one Allow or Disallow line.
+type robotsRule struct {
+	allow bool
+	path  string
+}
+
+// robotsGroup is the set of rules for one or more user agents.
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// robotsRules is a parsed robots.txt.
+type robotsRules struct {
+	groups  []*robotsGroup
+	fetched time.Time
+	ttl     time.Duration
+}
+
+// robotsDisallowedError is returned for fetches of URLs a host's robots.txt
+// disallows.
+type robotsDisallowedError struct {
+	URL string
+}
+
+func (e *robotsDisallowedError) Error() string {
+	return fmt.Sprintf("%s is disallowed by robots.txt", e.URL)
+}
+
+var (
+	robotsMu sync.Mutex
+	// robotsCache holds the robots.txt rules of each host, keyed by
+	// scheme and host.
+	robotsCache = map[string]*robotsRules{}
+	// nextHostFetch is the earliest time the next fetch of each host may
+	// start under its Crawl-delay.
+	nextHostFetch = map[string]time.Time{}
+)
+
+// parseRobots parses a robots.txt. Lines it doesn't understand are ignored.
+func parseRobots(r io.Reader) *robotsRules {
+	rules := &robotsRules{}
+	var group *robotsGroup
+	// inAgents is set while reading the User-agent lines that start a
+	// group; a rule line ends them.
+	inAgents := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				group = &robotsGroup{}
+				rules.groups = append(rules.groups, group)
+				inAgents = true
+			}
+			group.agents = append(group.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inAgents = false
+			// An empty Disallow allows everything.
+			if group != nil && value != "" {
+				group.rules = append(group.rules, robotsRule{allow: key == "allow", path: value})
+			}
+		case "crawl-delay":
+			inAgents = false
+			if group != nil {
+				if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+					group.crawlDelay = time.Duration(secs * float64(time.Second))
+				}
+			}
+		}
+	}
+	return rules
+}
+
+// group returns the group applying to userAgent: the one naming the longest
+// product token found in it, or else the "*" group. It returns nil if none
+// applies.
+func (rr *robotsRules) group(userAgent string) *robotsGroup {
+	userAgent = strings.ToLower(userAgent)
+	var best, star *robotsGroup
+	bestLen := 0
+	for _, g := range rr.groups {
+		for _, a := range g.agents {
+			if a == "*" {
+				if star == nil {
+					star = g
+				}
+			} else if a != "" && strings.Contains(userAgent, a) && len(a) > bestLen {
+				best, bestLen = g, len(a)
+			}
+		}
+	}
+	if best != nil {
+		return best
+	}
+	return star
+}
+
+// allowed reports whether path (including any query) may be fetched by g.
+// The longest matching rule wins, and Allow wins a tie.
+func (g *robotsGroup) allowed(path string) bool {
+	allow, longest := true, -1
+	for _, r := range g.rules {
+		if !robotsPathMatch(r.path, path) {
+			continue
+		}
+		if len(r.path) > longest || (len(r.path) == longest && r.allow) {
+			allow, longest = r.allow, len(r.path)
+		}
+	}
+	return allow
+}
+
+// robotsPathMatch reports whether path matches a robots.txt rule pattern,
+// in which * matches any characters and a trailing $ anchors the end.
+func robotsPathMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for _, part := range parts[1:] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	if !anchored {
+		return true
+	}
+	if len(parts) > 1 && parts[len(parts)-1] == "" {
+		return true
+	}
+	return rest == ""
+}
+
+// robotsFor returns the cached robots.txt rules for the host of u, fetching
+// them with p if they are missing or stale.
+func robotsFor(ctx context.Context, p FetchProfile, u *url.URL) *robotsRules {
+	key := u.Scheme + "://" + u.Host
+	robotsMu.Lock()
+	rr := robotsCache[key]
+	robotsMu.Unlock()
+	if rr != nil && time.Since(rr.fetched) < rr.ttl {
+		return rr
+	}
+
+	rr = fetchRobots(ctx, p, key+"/robots.txt")
+	robotsMu.Lock()
+	robotsCache[key] = rr
+	robotsMu.Unlock()
+	return rr
+}
+
+// fetchRobots fetches and parses a robots.txt. A missing file allows
+// everything; one that can't be fetched is treated the same for a while.
+func fetchRobots(ctx context.Context, p FetchProfile, robotsURL string) *robotsRules {
+	failed := &robotsRules{fetched: time.Now(), ttl: robotsErrorTTL}
+	if !acquireFetchSlot(ctx) {
+		return failed
+	}
+	defer releaseFetchSlot()
+	p.NoRedirects = false
+	resp, err := fetchURL(ctx, p, robotsURL)
+	if err != nil {
+		slog.Warn("Error fetching robots.txt; allowing all URLs for now", "event", "robots", "url", robotsURL, "error", err)
+		return failed
+	}
+	defer closeBody(resp)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return &robotsRules{fetched: time.Now(), ttl: robotsTTL}
+	}
+	if resp.StatusCode != http.StatusOK {
+		slog.Warn("Fetching robots.txt failed; allowing all URLs for now", "event", "robots", "url", robotsURL, "status", resp.StatusCode)
+		return failed
+	}
+	rr := parseRobots(io.LimitReader(resp.Body, maxRobotsBytes))
+	rr.fetched, rr.ttl = time.Now(), robotsTTL
+	return rr
+}
+
+// checkRobots returns a *robotsDisallowedError if robots.txt disallows
+// fetching rawURL with p, and otherwise waits out the host's Crawl-delay.
+// It does nothing unless respectRobots is set.
+func checkRobots(ctx context.Context, p FetchProfile, rawURL string) error {
+	if !respectRobots {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil
+	}
+	g := robotsFor(ctx, p, u).group(p.userAgent())
+	if g == nil {
+		return nil
+	}
+	if !g.allowed(u.RequestURI()) {
+		return &robotsDisallowedError{URL: rawURL}
+	}
+	if g.crawlDelay <= 0 {
+		return nil
+	}
+
+	// Reserve the next start time for this host, then wait for it.
+	key := u.Scheme + "://" + u.Host
+	robotsMu.Lock()
+	start := time.Now()
+	if next := nextHostFetch[key]; next.After(start) {
+		start = next
+	}
+	nextHostFetch[key] = start.Add(g.crawlDelay)
+	robotsMu.Unlock()
+	if wait := time.Until(start); wait > 0 && !sleepCtx(ctx, wait) {
+		return ctx.Err()
+	}
+	return nil
+}
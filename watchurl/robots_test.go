@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRobots = `# Everyone else
+User-agent: *
+Disallow: /private/
+Allow: /private/public/
+Disallow: /*.pdf$
+Crawl-delay: 2
+
+# A group may name several agents.
+User-agent: SpecialBot
+user-agent: OtherBot
+Disallow: /
+Allow: /$
+Allow: /open   # trailing comments are ignored
+
+User-agent: SpecialBot-News
+Disallow:
+Crawl-delay: 0.5
+`
+
+func TestRobotsGroups(t *testing.T) {
+	rr := parseRobots(strings.NewReader(testRobots))
+	if len(rr.groups) != 3 {
+		t.Fatalf("parsed %d groups, want 3", len(rr.groups))
+	}
+	star, special, news := rr.groups[0], rr.groups[1], rr.groups[2]
+	for _, tc := range []struct {
+		userAgent string
+		want      *robotsGroup
+	}{
+		{"Mozilla/5.0 (compatible; SpecialBot/2.1)", special},
+		{"otherbot", special},
+		{"SPECIALBOT", special},
+		// The longest product token found in the user agent wins.
+		{"SpecialBot-News/1.0", news},
+		{"Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0", star},
+		{"", star},
+	} {
+		if got := rr.group(tc.userAgent); got != tc.want {
+			t.Errorf("group(%q) has agents %v, want %v", tc.userAgent, agentsOf(got), agentsOf(tc.want))
+		}
+	}
+
+	if star.crawlDelay != 2*time.Second || special.crawlDelay != 0 || news.crawlDelay != 500*time.Millisecond {
+		t.Errorf("crawl delays are %v, %v and %v, want 2s, 0s and 500ms", star.crawlDelay, special.crawlDelay, news.crawlDelay)
+	}
+
+	// Without a "*" group, other agents are unrestricted.
+	rr = parseRobots(strings.NewReader("User-agent: SpecialBot\nDisallow: /\n"))
+	if g := rr.group("OtherBot"); g != nil {
+		t.Errorf("group(OtherBot) has agents %v, want none", g.agents)
+	}
+}
+
+func agentsOf(g *robotsGroup) []string {
+	if g == nil {
+		return nil
+	}
+	return g.agents
+}
+
+func TestRobotsAllowed(t *testing.T) {
+	rr := parseRobots(strings.NewReader(testRobots))
+	star, special, news := rr.groups[0], rr.groups[1], rr.groups[2]
+	for _, tc := range []struct {
+		g    *robotsGroup
+		path string
+		want bool
+	}{
+		{star, "/", true},
+		{star, "/private", true},
+		{star, "/private/", false},
+		{star, "/private/notes.html", false},
+		// The longer Allow overrides the Disallow it is nested in.
+		{star, "/private/public/index.html", true},
+		{star, "/reports/q1.pdf", false},
+		{star, "/reports/q1.pdf?download=1", true},
+		{special, "/", true},
+		{special, "/index.html", false},
+		{special, "/open", true},
+		{special, "/opening/hours", true},
+		{special, "/closed", false},
+		// An empty Disallow allows everything.
+		{news, "/private/notes.html", true},
+	} {
+		if got := tc.g.allowed(tc.path); got != tc.want {
+			t.Errorf("agents %v: allowed(%q) = %v, want %v", tc.g.agents, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestRobotsPrecedence(t *testing.T) {
+	for _, tc := range []struct {
+		robots string
+		path   string
+		want   bool
+	}{
+		// The longest matching rule wins, whatever the order of the lines.
+		{"Allow: /a\nDisallow: /a/b", "/a/b/c", false},
+		{"Disallow: /a/b\nAllow: /a", "/a/b/c", false},
+		{"Allow: /a\nDisallow: /a/b", "/a/c", true},
+		{"Disallow: /a\nAllow: /a/b", "/a/b/c", true},
+		// Allow wins a tie.
+		{"Disallow: /page\nAllow: /page", "/page", true},
+		{"Allow: /page\nDisallow: /page", "/page", true},
+		// Rules are case-sensitive.
+		{"Disallow: /Private", "/private", true},
+		{"", "/anything", true},
+	} {
+		rr := parseRobots(strings.NewReader("User-agent: *\n" + tc.robots))
+		if got := rr.group("watchurl").allowed(tc.path); got != tc.want {
+			t.Errorf("%q: allowed(%q) = %v, want %v", tc.robots, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestRobotsPathMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/fish", "/fish", true},
+		{"/fish", "/fish.html", true},
+		{"/fish", "/Fish", false},
+		{"/fish", "/catfish", false},
+		{"/fish*", "/fish/salmon", true},
+		{"/fish$", "/fish", true},
+		{"/fish$", "/fish.html", false},
+		{"/*.php", "/index.php", true},
+		{"/*.php", "/folder/index.php?x=1", true},
+		{"/*.php", "/index.PHP", false},
+		{"/*.php$", "/index.php", true},
+		{"/*.php$", "/index.php?x=1", false},
+		{"/a*b*c", "/axxbyyc", true},
+		{"/a*b*c", "/acb", false},
+		{"/*?", "/search?q=x", true},
+		{"/*?", "/search", false},
+		{"/*$", "/anything", true},
+		{"*", "/anything", true},
+	} {
+		if got := robotsPathMatch(tc.pattern, tc.path); got != tc.want {
+			t.Errorf("robotsPathMatch(%q, %q) = %v, want %v", tc.pattern, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestCheckRobots(t *testing.T) {
+	robotsStatus := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/robots.txt" {
+			fmt.Fprint(w, "page")
+			return
+		}
+		w.WriteHeader(robotsStatus)
+		fmt.Fprint(w, "User-agent: watchurl-test\nDisallow: /private\n\nUser-agent: *\nDisallow: /\n")
+	}))
+	t.Cleanup(srv.Close)
+
+	respectRobots = true
+	t.Cleanup(func() {
+		respectRobots = false
+		robotsMu.Lock()
+		robotsCache = map[string]*robotsRules{}
+		nextHostFetch = map[string]time.Time{}
+		robotsMu.Unlock()
+	})
+	ctx := context.Background()
+	p := FetchProfile{UserAgent: "watchurl-test/1.0"}
+
+	var disallowed *robotsDisallowedError
+	if err := checkRobots(ctx, p, srv.URL+"/private/page"); !errors.As(err, &disallowed) {
+		t.Errorf("checking a disallowed URL returned %v, want a robotsDisallowedError", err)
+	}
+	if err := checkRobots(ctx, p, srv.URL+"/public/page"); err != nil {
+		t.Errorf("checking an allowed URL returned %v", err)
+	}
+
+	// A missing robots.txt allows everything.
+	robotsMu.Lock()
+	robotsCache = map[string]*robotsRules{}
+	robotsMu.Unlock()
+	robotsStatus = http.StatusNotFound
+	if err := checkRobots(ctx, p, srv.URL+"/private/page"); err != nil {
+		t.Errorf("checking a URL without robots.txt returned %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Screenshots are taken by running a Chrome or Chromium binary headless:
+// CHROME_PATH if set, otherwise the first of chromeNames found on the PATH.
+var chromeNames = []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "chrome"}
+
+const (
+	// screenshotWidth and screenshotHeight are the size of the browser
+	// window; content below screenshotHeight is not captured.
+	screenshotWidth   = 1280
+	screenshotHeight  = 4000
+	screenshotTimeout = time.Minute
+)
+
+var (
+	chromeOnce sync.Once
+	chromePath string
+)
+
+// findChrome returns the browser used for screenshots, or "" if there is
+// none, which is logged once.
+func findChrome() string {
+	chromeOnce.Do(func() {
+		if p := os.Getenv("CHROME_PATH"); p != "" {
+			chromePath = p
+			return
+		}
+		for _, name := range chromeNames {
+			if p, err := exec.LookPath(name); err == nil {
+				chromePath = p
+				return
+			}
+		}
+		slog.Warn("No Chrome or Chromium found; skipping screenshots (set CHROME_PATH to enable them)")
+	})
+	return chromePath
+}
+
+// captureScreenshot renders url in headless Chrome and returns a PNG of it.
+// The page is loaded without the URL's fetch profile or headers.
+func captureScreenshot(ctx context.Context, url string) ([]byte, error) {
+	chrome := findChrome()
+	if chrome == "" {
+		return nil, nil
+	}
+	dir, err := os.MkdirTemp("", "watchurl-screenshot")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithTimeout(ctx, screenshotTimeout)
+	defer cancel()
+	out := filepath.Join(dir, "screenshot.png")
+	cmd := exec.CommandContext(ctx, chrome,
+		"--headless",
+		"--disable-gpu",
+		"--hide-scrollbars",
+		"--no-first-run",
+		"--user-data-dir="+filepath.Join(dir, "profile"),
+		fmt.Sprintf("--window-size=%d,%d", screenshotWidth, screenshotHeight),
+		"--screenshot="+out,
+		url)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, output)
+	}
+	return os.ReadFile(out)
+}
+
+// saveScreenshot captures a screenshot of m and stores it with snapshot
+// snapshotID. Failures are logged; the snapshot is kept without one.
+func saveScreenshot(ctx context.Context, m MonitoredURL, snapshotID int64) {
+	png, err := captureScreenshot(ctx, m.URL)
+	if err != nil {
+		m.logger().Warn("Error taking screenshot", "event", "screenshot", "error", err)
+		return
+	}
+	if png == nil {
+		return
+	}
+	if _, err := writeDB.Exec("UPDATE url_snapshots SET screenshot = ? WHERE id = ?", png, snapshotID); err != nil {
+		m.logger().Error("Error saving screenshot", "event", "screenshot", "snapshot_id", snapshotID, "error", err)
+	}
+}
+
+// screenshotHandler serves the screenshot stored with a snapshot.
+func screenshotHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	var png []byte
+	err = db.QueryRow("SELECT screenshot FROM url_snapshots WHERE id = ? AND screenshot IS NOT NULL", id).Scan(&png)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Screenshot not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
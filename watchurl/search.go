@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxSearchResults bounds the number of snapshots listed by /search.
+const maxSearchResults = 200
+
+// execer is satisfied by *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// queryExecer is satisfied by *sql.DB and *sql.Tx.
+type queryExecer interface {
+	execer
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// indexSnapshot adds a snapshot's content to the snapshot_fts full-text
+// index, replacing any earlier entry for it.
+func indexSnapshot(e execer, id int64, content string) error {
+	if _, err := e.Exec("DELETE FROM snapshot_fts WHERE rowid = ?", id); err != nil {
+		return err
+	}
+	_, err := e.Exec("INSERT INTO snapshot_fts (rowid, content) VALUES (?, ?)", id, content)
+	return err
+}
+
+// unindexURLSnapshots drops the full-text index entries of every snapshot of
+// a URL. Like invalidateURLDiffs, it must be called before the snapshots
+// themselves are deleted, in the same transaction.
+func unindexURLSnapshots(tx *sql.Tx, urlID int) error {
+	_, err := tx.Exec("DELETE FROM snapshot_fts WHERE rowid IN (SELECT id FROM url_snapshots WHERE url_id = ?)", urlID)
+	return err
+}
+
+// ftsPhrase quotes q as an FTS5 phrase, so that it is matched as written
+// rather than parsed as a query expression.
+func ftsPhrase(q string) string {
+	return `"` + strings.ReplaceAll(q, `"`, `""`) + `"`
+}
+
+// SearchMatch is a snapshot whose content matches a search.
+type SearchMatch struct {
+	ID        int
+	Timestamp string
+	Region    string
+	// PrevID is the snapshot before this one in the same history, or 0.
+	PrevID int
+}
+
+// SearchGroup holds the matching snapshots of one URL, oldest first.
+type SearchGroup struct {
+	URLID   int
+	URL     string
+	Matches []SearchMatch
+}
+
+// SearchView is the data for the search page.
+type SearchView struct {
+	Query  string
+	Groups []SearchGroup
+	// Truncated is set when there were more than maxSearchResults matches.
+	Truncated bool
+}
+
+// searchHandler lists the snapshots whose content contains the phrase ?q=,
+// grouped by URL, so that the snapshot that introduced a phrase can be found.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	view := SearchView{Query: strings.TrimSpace(r.URL.Query().Get("q"))}
+	if view.Query != "" {
+		rows, err := db.Query(`
+            SELECT s.id, s.url_id, mu.url, s.region, s.timestamp,
+                   (SELECT p.id FROM url_snapshots p
+                    WHERE p.url_id = s.url_id AND p.region = s.region AND p.timestamp < s.timestamp
+                    ORDER BY p.timestamp DESC LIMIT 1)
+            FROM snapshot_fts f
+            JOIN url_snapshots s ON s.id = f.rowid
+            JOIN monitored_urls mu ON mu.id = s.url_id
+            WHERE snapshot_fts MATCH ?
+            ORDER BY mu.id, s.timestamp
+            LIMIT ?`, ftsPhrase(view.Query), maxSearchResults+1)
+		if err != nil {
+			slog.Error("Error searching snapshots", "query", view.Query, "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		n := 0
+		for rows.Next() {
+			if n++; n > maxSearchResults {
+				view.Truncated = true
+				break
+			}
+			var m SearchMatch
+			var urlID int
+			var url string
+			var ts time.Time
+			var prevID sql.NullInt64
+			if err := rows.Scan(&m.ID, &urlID, &url, &m.Region, &ts, &prevID); err != nil {
+				slog.Error("Error scanning search result", "error", err)
+				continue
+			}
+			m.Timestamp = ts.Local().Format(time.RFC1123)
+			m.PrevID = int(prevID.Int64)
+			if len(view.Groups) == 0 || view.Groups[len(view.Groups)-1].URLID != urlID {
+				view.Groups = append(view.Groups, SearchGroup{URLID: urlID, URL: url})
+			}
+			g := &view.Groups[len(view.Groups)-1]
+			g.Matches = append(g.Matches, m)
+		}
+	}
+
+	if err := searchTmpl.Execute(w, view); err != nil {
+		slog.Error("Template execution error", "path", r.URL.Path, "error", err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// extractSelector returns the rendered HTML of the elements in input matched
+// by the CSS selector, one per line, with non-visible tags stripped as in
+// extractBody. It reports false if the selector is invalid or matches
+// nothing.
+func extractSelector(input, selector string) (string, bool) {
+	sel, err := cascadia.Compile(selector)
+	if err != nil {
+		return "", false
+	}
+	doc, err := html.Parse(strings.NewReader(input))
+	if err != nil {
+		return "", false
+	}
+	nodes := cascadia.QueryAll(doc, sel)
+	if len(nodes) == 0 {
+		return "", false
+	}
+
+	var buf bytes.Buffer
+	for i, n := range nodes {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		stripNodes(n)
+		sortAttributes(n)
+		if err := html.Render(&buf, n); err != nil {
+			return "", false
+		}
+	}
+	return buf.String(), true
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// SlackPayload is the body of a Slack incoming webhook request.
+type SlackPayload struct {
+	Text string `json:"text"`
+}
+
+// slackEscaper escapes the characters Slack treats as markup in message
+// text.
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// sendSlackNotification posts a change notification to SLACK_WEBHOOK_URL.
+// If BASE_URL is set to the address of this server's web UI, the message
+// links to the diff that introduced snapshot snapshotID.
+func sendSlackNotification(monitoredURL, message string, urlID int, snapshotID int64) error {
+	text := slackEscaper.Replace(message)
+	if base := os.Getenv("BASE_URL"); base != "" {
+		text += "\n<" + strings.TrimRight(base, "/") + snapshotDiffPath(urlID, snapshotID) + "|View the diff>"
+	}
+	return sendSlackMessage(text)
+}
+
+// sendSlackTitled posts a message with the given title about monitoredURL
+// to SLACK_WEBHOOK_URL.
+func sendSlackTitled(title, message, monitoredURL string) error {
+	return sendSlackMessage("*" + slackEscaper.Replace(title) + "*\n" + slackEscaper.Replace(message) + "\n" + slackEscaper.Replace(monitoredURL))
+}
+
+// sendSlackMessage posts text, which may use Slack's markup, to
+// SLACK_WEBHOOK_URL.
+func sendSlackMessage(text string) error {
+	webhookURL := os.Getenv("SLACK_WEBHOOK_URL")
+	if webhookURL == "" {
+		return errors.New("missing Slack webhook URL")
+	}
+
+	body, err := json.Marshal(SlackPayload{Text: text})
+	if err != nil {
+		return fmt.Errorf("encoding Slack payload: %w", err)
+	}
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Slack returned %s: %s", resp.Status, msg)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// APISnapshot is the JSON representation of a stored snapshot.
+type APISnapshot struct {
+	ID          int       `json:"id"`
+	URLID       int       `json:"url_id"`
+	Timestamp   time.Time `json:"timestamp"`
+	ContentType string    `json:"content_type"`
+	Content     string    `json:"content"`
+}
+
+// loadAPISnapshot reads the snapshot with the given id. It returns
+// sql.ErrNoRows for an unknown id and a *bodyTooLargeError for content
+// larger than maxBodyBytes, which is never sent in full.
+func loadAPISnapshot(id int) (APISnapshot, error) {
+	s := APISnapshot{ID: id}
+	var content, path sql.NullString
+	var compressed bool
+	err := db.QueryRow("SELECT url_id, timestamp, content_type, content, content_path, compressed FROM url_snapshots WHERE id = ?", id).
+		Scan(&s.URLID, &s.Timestamp, &s.ContentType, &content, &path, &compressed)
+	if err != nil {
+		return s, err
+	}
+	if s.ContentType == "" {
+		// Older snapshots, stored before content types were, are HTML.
+		s.ContentType = "text/html; charset=utf-8"
+	}
+	if s.Content, err = snapshotContent(content, path, compressed); err != nil {
+		return s, err
+	}
+	if maxBodyBytes > 0 && int64(len(s.Content)) > maxBodyBytes {
+		return s, &bodyTooLargeError{Limit: maxBodyBytes}
+	}
+	return s, nil
+}
+
+// snapshotRequest reads the snapshot named by a request's id parameter. It
+// writes the error response, using writeError, and returns false if there
+// is none to serve.
+func snapshotRequest(w http.ResponseWriter, r *http.Request, writeError func(http.ResponseWriter, string, int)) (APISnapshot, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		writeError(w, "Invalid id", http.StatusBadRequest)
+		return APISnapshot{}, false
+	}
+	s, err := loadAPISnapshot(id)
+	var tooLarge *bodyTooLargeError
+	switch {
+	case err == sql.ErrNoRows:
+		writeError(w, "Snapshot not found", http.StatusNotFound)
+		return s, false
+	case errors.As(err, &tooLarge):
+		writeError(w, fmt.Sprintf("Snapshot is larger than the %s limit (see -max-body-bytes)", humanize.IBytes(uint64(tooLarge.Limit))), http.StatusRequestEntityTooLarge)
+		return s, false
+	case err != nil:
+		slog.Error("Error reading snapshot", "snapshot_id", id, "error", err)
+		writeError(w, "Database error", http.StatusInternalServerError)
+		return s, false
+	}
+	return s, true
+}
+
+// snapshotHandler serves the stored content of one snapshot as it is, with
+// its content type. As for the replay frame, the Content-Security-Policy
+// sandbox keeps scripts in a stored page from running. With download=1 it
+// is served as an attachment named by snapshotFilename.
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := snapshotRequest(w, r, http.Error)
+	if !ok {
+		return
+	}
+	if r.URL.Query().Get("download") == "1" {
+		var pageURL string
+		if err := db.QueryRow("SELECT url FROM monitored_urls WHERE id = ?", s.URLID).Scan(&pageURL); err != nil {
+			slog.Warn("Error reading snapshot URL", "snapshot_id", s.ID, "error", err)
+		}
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": snapshotFilename(pageURL, s.Timestamp, s.ContentType),
+		}))
+	}
+	w.Header().Set("Content-Type", s.ContentType)
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := io.WriteString(w, s.Content); err != nil {
+		slog.Error("Error writing snapshot", "snapshot_id", s.ID, "error", err)
+	}
+}
+
+// snapshotFilename returns the name a snapshot of pageURL taken at ts is
+// downloaded as, such as example.com-20240131-120000.html. Anything but
+// letters, digits, dots, hyphens and underscores is replaced, so the name
+// can't reach outside a download directory or break out of a header.
+func snapshotFilename(pageURL string, ts time.Time, contentType string) string {
+	host := "snapshot"
+	if u, err := url.Parse(pageURL); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, host+"-"+ts.UTC().Format("20060102-150405")+snapshotExtension(contentType))
+	return strings.TrimLeft(name, ".")
+}
+
+// snapshotExtension returns the file name extension for content of the
+// given type, or "" if there is no known one.
+func snapshotExtension(contentType string) string {
+	switch mt := mediaType(contentType); mt {
+	case "text/html":
+		return ".html"
+	case "text/plain":
+		return ".txt"
+	case "application/json", "application/ld+json":
+		return ".json"
+	default:
+		if exts, err := mime.ExtensionsByType(mt); err == nil && len(exts) > 0 {
+			return exts[0]
+		}
+		return ""
+	}
+}
+
+// apiSnapshotHandler serves one snapshot, with its content, as JSON.
+func apiSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := snapshotRequest(w, r, func(w http.ResponseWriter, message string, status int) {
+		writeJSONError(w, status, message)
+	})
+	if ok {
+		writeJSON(w, http.StatusOK, s)
+	}
+}
+
+// deleteSnapshot deletes one snapshot and its content file. Nothing else
+// needs updating: each check looks up the latest snapshot again (see
+// latestSnapshot.refresh), so deleting the latest one makes the next check
+// compare against the one before it, and stored validators only apply to
+// the snapshot they were saved with.
+func deleteSnapshot(id int) error {
+	var path sql.NullString
+	tx, err := writeDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := tx.QueryRow("SELECT content_path FROM url_snapshots WHERE id = ?", id).Scan(&path); err != nil {
+		return err
+	}
+	if err := deleteSnapshotRows(tx, id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if path.Valid && path.String != "" {
+		removeSnapshotFiles([]string{path.String})
+	}
+	return nil
+}
+
+// deleteSnapshotHandler deletes one snapshot, such as one captured during an
+// outage, and returns to the history it was in.
+func deleteSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	var urlID int
+	var region string
+	err = db.QueryRow("SELECT url_id, region FROM url_snapshots WHERE id = ?", id).Scan(&urlID, &region)
+	if err == nil {
+		err = deleteSnapshot(id)
+	}
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return
+	case err != nil:
+		slog.Error("Error deleting snapshot", "snapshot_id", id, "error", err)
+		http.Error(w, "Could not delete the snapshot", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Deleted snapshot", "event", "delete_snapshot", "url_id", urlID, "snapshot_id", id)
+	http.Redirect(w, r, historyURL(urlID, region, "", 1, defaultHistoryPageSize), http.StatusSeeOther)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// States of a monitorURL goroutine, as the status page shows them. URLs
+// without a goroutine are shown as paused or, if they should have one, as
+// not running.
+const (
+	monitorStarting   = "starting"
+	monitorWaiting    = "waiting"
+	monitorChecking   = "checking"
+	monitorStopped    = "stopped"
+	monitorPaused     = "paused"
+	monitorNotRunning = "not running"
+)
+
+// monitorState is the runtime state of one monitorURL goroutine, which
+// keeps it up to date for the status page.
+type monitorState struct {
+	mu         sync.Mutex
+	state      string
+	since      time.Time
+	lastCheck  time.Time
+	nextCheck  time.Time
+	lastStatus int
+	lastError  string
+}
+
+func newMonitorState() *monitorState {
+	return &monitorState{state: monitorStarting, since: time.Now()}
+}
+
+// set records that the goroutine entered state.
+func (s *monitorState) set(state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state, s.since = state, time.Now()
+}
+
+// waiting records that the goroutine is waiting for its next check, due at
+// next.
+func (s *monitorState) waiting(next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state, s.since, s.nextCheck = monitorWaiting, time.Now(), next
+}
+
+// checked records the outcome of a fetch: the HTTP status it got, or 0 if
+// it got none, and its error, if any.
+func (s *monitorState) checked(at time.Time, status int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastCheck, s.lastStatus, s.lastError = at, status, ""
+	if err != nil {
+		s.lastError = err.Error()
+	}
+}
+
+// MonitorStatus is one URL's entry on the status page and in /api/status.
+type MonitorStatus struct {
+	ID    int    `json:"id"`
+	URL   string `json:"url"`
+	State string `json:"state"`
+	// Since is when the goroutine entered State; it is nil for URLs
+	// without a goroutine.
+	Since               *time.Time `json:"since,omitempty"`
+	LastCheck           *time.Time `json:"last_check,omitempty"`
+	NextCheck           *time.Time `json:"next_check,omitempty"`
+	LastStatus          int        `json:"last_status,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+// When describes t relative to now for the status page.
+func (MonitorStatus) When(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return humanize.Time(*t)
+}
+
+// optionalTime returns a pointer to t, or nil if t is zero.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// loadMonitorStatuses returns the status of every monitored URL, combining
+// what the database says about it with the state of its goroutine.
+func loadMonitorStatuses() ([]MonitorStatus, error) {
+	rows, err := db.Query("SELECT mu.id, mu.url, mu.paused, mu.consecutive_failures, lc.last_check FROM monitored_urls mu LEFT JOIN url_last_check lc ON mu.id = lc.url_id ORDER BY mu.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	monitorsMu.Lock()
+	states := make(map[int]*monitorState, len(monitors))
+	for id, h := range monitors {
+		states[id] = h.state
+	}
+	monitorsMu.Unlock()
+
+	var statuses []MonitorStatus
+	for rows.Next() {
+		var s MonitorStatus
+		var paused bool
+		var lastCheck sql.NullTime
+		if err := rows.Scan(&s.ID, &s.URL, &paused, &s.ConsecutiveFailures, &lastCheck); err != nil {
+			return nil, err
+		}
+		if lastCheck.Valid {
+			s.LastCheck = &lastCheck.Time
+		}
+		st, running := states[s.ID]
+		switch {
+		case running:
+			st.mu.Lock()
+			s.State = st.state
+			s.Since = optionalTime(st.since)
+			if st.state == monitorWaiting {
+				s.NextCheck = optionalTime(st.nextCheck)
+			}
+			if !st.lastCheck.IsZero() {
+				s.LastCheck = optionalTime(st.lastCheck)
+				s.LastStatus, s.LastError = st.lastStatus, st.lastError
+			}
+			st.mu.Unlock()
+		case paused:
+			s.State = monitorPaused
+		default:
+			s.State = monitorNotRunning
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, rows.Err()
+}
+
+// statusHandler lists the state of each URL's monitoring goroutine, to
+// diagnose URLs that aren't being checked.
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	statuses, err := loadMonitorStatuses()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := statusTmpl.Execute(w, statuses); err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+	}
+}
+
+// apiStatusHandler serves the status page as JSON.
+func apiStatusHandler(w http.ResponseWriter, r *http.Request) {
+	statuses, err := loadMonitorStatuses()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// snapshotDir, when non-empty, is the directory in which snapshot content is
+// stored as files instead of inline in the url_snapshots table. The row then
+// holds only the metadata and the path to the file in content_path.
+var snapshotDir string
+
+// writeSnapshotFile stores content under snapshotDir and returns its path.
+func writeSnapshotFile(urlID int, ts time.Time, content string) (string, error) {
+	dir := filepath.Join(snapshotDir, strconv.Itoa(urlID))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, strconv.FormatInt(ts.UnixNano(), 10)+".html")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// contentHash returns the hex SHA-256 of content, as stored in
+// url_snapshots.content_hash.
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// compressSnapshots, set with -compress-snapshots, stores the content of new
+// inline snapshots gzip-compressed. Rows record whether they are compressed
+// in url_snapshots.compressed, so either kind reads back the same.
+var compressSnapshots bool
+
+// compressContent gzips content.
+func compressContent(content string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(content)); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressContent reverses compressContent.
+func decompressContent(data []byte) (string, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// inlineContent returns the value stored in url_snapshots.content for
+// content, and whether it is compressed. Content that compression wouldn't
+// shrink is stored as it is.
+func inlineContent(content string) (interface{}, bool, error) {
+	if !compressSnapshots {
+		return content, false, nil
+	}
+	data, err := compressContent(content)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) >= len(content) {
+		return content, false, nil
+	}
+	return data, true, nil
+}
+
+// snapshotContent returns the content of a snapshot row, reading it from disk
+// if the row references a file rather than holding the content inline, and
+// decompressing it if compressed is set.
+func snapshotContent(content, path sql.NullString, compressed bool) (string, error) {
+	if path.Valid && path.String != "" {
+		b, err := os.ReadFile(path.String)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	if compressed && content.Valid {
+		return decompressContent([]byte(content.String))
+	}
+	return content.String, nil
+}
+
+// loadSnapshotContent fetches the content of the snapshot with the given id.
+func loadSnapshotContent(id int) (string, error) {
+	var content, path sql.NullString
+	var compressed bool
+	err := db.QueryRow("SELECT content, content_path, compressed FROM url_snapshots WHERE id = ?", id).Scan(&content, &path, &compressed)
+	if err != nil {
+		return "", err
+	}
+	return snapshotContent(content, path, compressed)
+}
+
+// snapshotFiles lists the content files of all snapshots of a URL. It must
+// be called before the snapshot rows themselves are deleted, and the files
+// removed with removeSnapshotFiles once they have been.
+func snapshotFiles(urlID int) ([]string, error) {
+	rows, err := db.Query("SELECT content_path FROM url_snapshots WHERE url_id = ? AND content_path IS NOT NULL", urlID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+// removeSnapshotFiles deletes snapshot content files.
+func removeSnapshotFiles(paths []string) {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			slog.Error("Error removing snapshot file", "path", path, "error", err)
+		}
+	}
+}
+
+// migrateSnapshotsToFiles moves all inline snapshot content into files under
+// snapshotDir, clearing the inline copy once the file has been written.
+func migrateSnapshotsToFiles() error {
+	if snapshotDir == "" {
+		return fmt.Errorf("no snapshot directory configured")
+	}
+
+	type inlineSnapshot struct {
+		id, urlID  int
+		ts         time.Time
+		content    string
+		compressed bool
+	}
+	rows, err := db.Query("SELECT id, url_id, timestamp, content, compressed FROM url_snapshots WHERE content_path IS NULL AND content IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	var pending []inlineSnapshot
+	for rows.Next() {
+		var s inlineSnapshot
+		if err := rows.Scan(&s.id, &s.urlID, &s.ts, &s.content, &s.compressed); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range pending {
+		content := s.content
+		if s.compressed {
+			if content, err = decompressContent([]byte(s.content)); err != nil {
+				return fmt.Errorf("reading snapshot %d: %w", s.id, err)
+			}
+		}
+		path, err := writeSnapshotFile(s.urlID, s.ts, content)
+		if err != nil {
+			return fmt.Errorf("writing snapshot %d: %w", s.id, err)
+		}
+		_, err = writeDB.Exec("UPDATE url_snapshots SET content = NULL, content_path = ?, compressed = 0 WHERE id = ?", path, s.id)
+		if err != nil {
+			return fmt.Errorf("updating snapshot %d: %w", s.id, err)
+		}
+	}
+	slog.Info("Moved inline snapshots to files", "event", "migrate_snapshots", "snapshots", len(pending), "dir", snapshotDir)
+	return nil
+}
+
+// compressExistingSnapshots compresses the inline content of all snapshots
+// stored uncompressed, except where that wouldn't make it smaller, then
+// vacuums the database so that the space is given back, and logs how much
+// was saved.
+func compressExistingSnapshots() error {
+	rows, err := db.Query("SELECT id FROM url_snapshots WHERE content_path IS NULL AND content IS NOT NULL AND compressed = 0")
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var compressed int
+	var before, after int64
+	for _, id := range ids {
+		var content string
+		if err := db.QueryRow("SELECT content FROM url_snapshots WHERE id = ?", id).Scan(&content); err != nil {
+			return fmt.Errorf("reading snapshot %d: %w", id, err)
+		}
+		data, err := compressContent(content)
+		if err != nil {
+			return fmt.Errorf("compressing snapshot %d: %w", id, err)
+		}
+		if len(data) >= len(content) {
+			continue
+		}
+		if _, err := writeDB.Exec("UPDATE url_snapshots SET content = ?, compressed = 1 WHERE id = ? AND compressed = 0", data, id); err != nil {
+			return fmt.Errorf("updating snapshot %d: %w", id, err)
+		}
+		compressed++
+		before += int64(len(content))
+		after += int64(len(data))
+	}
+	if compressed == 0 {
+		slog.Info("No snapshots to compress", "event", "compress")
+		return nil
+	}
+	if _, err := writeDB.Exec("VACUUM"); err != nil {
+		slog.Error("Error vacuuming the database after compressing snapshots", "event", "compress", "error", err)
+	}
+	slog.Info("Compressed snapshots", "event", "compress", "snapshots", compressed,
+		"before", humanize.Bytes(uint64(before)), "after", humanize.Bytes(uint64(after)), "saved", humanize.Bytes(uint64(before-after)), "saved_percent", 100*(before-after)/before)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// defaultStripTags lists the elements removed from pages before comparison.
+// Their content is invisible and often changes on every request (nonces,
+// CSRF tokens, cache-busting hashes), which would otherwise show up as
+// changes.
+var defaultStripTags = []string{"meta", "script", "style"}
+
+// stripTags holds the element names removed by stripNodes: the defaults
+// plus any added with -strip-tags.
+var stripTags = newStripTags(defaultStripTags)
+
+func newStripTags(names []string) map[string]bool {
+	tags := make(map[string]bool, len(names))
+	for _, name := range names {
+		tags[name] = true
+	}
+	return tags
+}
+
+// addStripTags adds the comma-separated element names in list to stripTags.
+func addStripTags(list string) {
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			stripTags[name] = true
+		}
+	}
+}
+
+// stripNodes traverses the node tree under n and removes comments, hidden
+// <input> elements and any element named in stripTags.
+func stripNodes(n *html.Node) {
+	if n == nil {
+		return
+	}
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if shouldStrip(c) {
+			n.RemoveChild(c)
+		} else {
+			stripNodes(c)
+		}
+		c = next
+	}
+}
+
+func shouldStrip(n *html.Node) bool {
+	switch n.Type {
+	case html.CommentNode:
+		return true
+	case html.ElementNode:
+		if stripTags[n.Data] {
+			return true
+		}
+		if n.Data == "input" {
+			for _, a := range n.Attr {
+				if strings.ToLower(a.Key) == "type" && strings.ToLower(a.Val) == "hidden" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
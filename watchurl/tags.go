@@ -0,0 +1,36 @@
+package main
+
+import "strings"
+
+// parseTags splits a comma-separated list of tags. Tags are trimmed and
+// lowercased, and empty or repeated tags are dropped.
+func parseTags(s string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, t := range strings.Split(s, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	return tags
+}
+
+// formatTags joins tags in the form stored in monitored_urls.tags and
+// accepted by parseTags.
+func formatTags(tags []string) string {
+	return strings.Join(tags, ",")
+}
+
+// hasTag reports whether tags contains tag, ignoring case.
+func hasTag(tags []string, tag string) bool {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const telegramAPIEndpoint = "https://api.telegram.org/bot%s/sendMessage"
+
+// sendTelegramNotification sends a change notification to the Telegram chat.
+// If BASE_URL is set to the address of this server's web UI, the message
+// links to the URL's latest diff.
+func sendTelegramNotification(message string, urlID int) error {
+	text := notificationTitle + "\n" + message
+	if base := os.Getenv("BASE_URL"); base != "" {
+		text += fmt.Sprintf("\n\nDiff: %s/latestDiff?id=%d", strings.TrimRight(base, "/"), urlID)
+	}
+	return sendTelegramMessage(text)
+}
+
+// sendTelegramMessage sends text to TELEGRAM_CHAT_ID through the bot
+// identified by TELEGRAM_BOT_TOKEN.
+func sendTelegramMessage(text string) error {
+	token := os.Getenv("TELEGRAM_BOT_TOKEN")
+	chatID := os.Getenv("TELEGRAM_CHAT_ID")
+	if token == "" || chatID == "" {
+		return errors.New("missing Telegram bot token or chat id")
+	}
+
+	data := url.Values{}
+	data.Set("chat_id", chatID)
+	data.Set("text", text)
+
+	resp, err := http.PostForm(fmt.Sprintf(telegramAPIEndpoint, token), data)
+	if err != nil {
+		// The request URL contains the token; don't log it.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Telegram returned %s: %s", resp.Status, body)
+	}
+	return nil
+}
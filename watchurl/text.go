@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// textBlockTags lists the elements that start a new line of extracted text,
+// so that paragraphs, list items and table rows don't run together.
+var textBlockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"br": true, "dd": true, "div": true, "dl": true, "dt": true,
+	"fieldset": true, "figcaption": true, "figure": true, "footer": true,
+	"form": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "header": true, "hr": true, "li": true, "main": true,
+	"nav": true, "ol": true, "p": true, "pre": true, "section": true,
+	"table": true, "td": true, "th": true, "tr": true, "ul": true,
+}
+
+// textSkipTags lists the elements whose text is never visible.
+var textSkipTags = map[string]bool{
+	"head": true, "noscript": true, "script": true, "style": true, "template": true,
+}
+
+// extractText returns the visible text of the input HTML, with the same
+// elements stripped as in extractBody. Block-level elements start new lines,
+// runs of whitespace within a line collapse to a single space and blank
+// lines are dropped, so the result diffs as prose rather than markup. If
+// the input isn't valid HTML, it is returned as it is.
+func extractText(input string) string {
+	doc, err := html.Parse(strings.NewReader(input))
+	if err != nil {
+		return input
+	}
+	stripNodes(doc)
+
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			b.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			if textSkipTags[n.Data] {
+				return
+			}
+		}
+		block := n.Type == html.ElementNode && textBlockTags[n.Data]
+		if block {
+			b.WriteByte('\n')
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if block {
+			b.WriteByte('\n')
+		}
+	}
+	walk(doc)
+
+	var lines []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimestampsRoundTrip(t *testing.T) {
+	openTestDB(t)
+	m := addTestURL(t, MonitoredURL{URL: "https://example.com/", Paused: true})
+	// Stored times must not depend on the zone they are given in.
+	taken := time.Now().Add(-3 * time.Hour).In(time.FixedZone("UTC-7", -7*60*60)).Round(time.Millisecond)
+	id, err := saveSnapshot(NewSnapshot{URLID: m.ID, Content: "<p>x</p>", ContentType: "text/html", Timestamp: taken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored string
+	if err := db.QueryRow("SELECT CAST(timestamp AS TEXT) FROM url_snapshots WHERE id = ?", id).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if want := taken.UTC().Format(timestampLayout); stored != want {
+		t.Errorf("stored timestamp %q, want %q in UTC", stored, want)
+	}
+	parsed, err := parseStoredTime(stored)
+	if err != nil {
+		t.Fatalf("parseStoredTime(%q): %v", stored, err)
+	}
+	if !parsed.Equal(taken) {
+		t.Errorf("timestamp round-tripped to %v, want %v", parsed, taken)
+	}
+	var scanned time.Time
+	if err := db.QueryRow("SELECT timestamp FROM url_snapshots WHERE id = ?", id).Scan(&scanned); err != nil {
+		t.Fatal(err)
+	}
+	if !scanned.Equal(taken) {
+		t.Errorf("timestamp scanned as %v, want %v", scanned, taken)
+	}
+
+	w := httptest.NewRecorder()
+	indexHandler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("index: got %d", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "Last updated: 3 hours ago") {
+		t.Errorf("index doesn't show the snapshot as 3 hours old:\n%s", body)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// extractTitle returns the text of an HTML page's <title> element with its
+// whitespace collapsed, or "" if it has none.
+func extractTitle(input string) string {
+	doc, err := html.Parse(strings.NewReader(input))
+	if err != nil {
+		return ""
+	}
+	var title string
+	var find func(*html.Node) bool
+	find = func(n *html.Node) bool {
+		if n.Type == html.ElementNode && n.Data == "title" {
+			var b strings.Builder
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == html.TextNode {
+					b.WriteString(c.Data)
+				}
+			}
+			title = strings.Join(strings.Fields(b.String()), " ")
+			return true
+		}
+		// An SVG image's <title> labels the image, not the page.
+		if n.Type == html.ElementNode && n.Data == "svg" {
+			return false
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if find(c) {
+				return true
+			}
+		}
+		return false
+	}
+	find(doc)
+	return title
+}
+
+// pageTitle returns the title of a fetched page of the given content type,
+// or "" if it isn't HTML.
+func pageTitle(body, contentType string) string {
+	if !isHTMLType(contentType) {
+		return ""
+	}
+	return extractTitle(body)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// Connection pool settings of the transports used for fetches, set by the
+// -max-idle-conns, -max-idle-conns-per-host and -idle-conn-timeout flags.
+var (
+	maxIdleConns        = 100
+	maxIdleConnsPerHost = 10
+	idleConnTimeout     = 90 * time.Second
+)
+
+// newTransport returns a transport for fetches with the connection pool
+// settings above. The default transport keeps only two idle connections per
+// host, so monitoring many URLs on one site would keep opening new ones.
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = maxIdleConns
+	t.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	t.IdleConnTimeout = idleConnTimeout
+	t.ForceAttemptHTTP2 = true
+	return t
+}
+
+// maxDrainBytes bounds how much of an unread response body closeBody reads.
+// Beyond that, closing the connection is cheaper than reading on.
+const maxDrainBytes = 64 << 10
+
+// closeBody reads what is left of resp's body, up to maxDrainBytes, and
+// closes it. The transport only reuses a connection once the previous
+// response on it has been read to the end, which doesn't happen when a fetch
+// gives up on an error status or a body that is too large.
+func closeBody(resp *http.Response) {
+	io.CopyN(io.Discard, resp.Body, maxDrainBytes)
+	resp.Body.Close()
+}
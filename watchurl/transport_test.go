@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// countingServer starts a server with the given handler that counts the
+// connections opened to it.
+func countingServer(tb testing.TB, h http.Handler) (*httptest.Server, *int64) {
+	var conns int64
+	srv := httptest.NewUnstartedServer(h)
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	srv.Start()
+	tb.Cleanup(srv.Close)
+	return srv, &conns
+}
+
+var testPage = strings.Repeat("<p>Some page content</p>\n", 500)
+
+func servePage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	io.WriteString(w, testPage)
+}
+
+// TestFetchReusesConnectionAfterErrorStatus checks that a response given
+// up on for its status is drained, so the next fetch reuses its connection.
+func TestFetchReusesConnectionAfterErrorStatus(t *testing.T) {
+	oldRetries := fetchRetries
+	fetchRetries = 0
+	t.Cleanup(func() { fetchRetries = oldRetries })
+
+	srv, conns := countingServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, testPage)
+	}))
+	for i := 0; i < 5; i++ {
+		if _, _, err := fetchBody(context.Background(), FetchProfile{}, srv.URL); err == nil {
+			t.Fatal("fetchBody succeeded on a 500")
+		}
+	}
+	if n := atomic.LoadInt64(conns); n != 1 {
+		t.Errorf("5 sequential fetches opened %d connections, want 1", n)
+	}
+}
+
+// fetchInBursts runs b.N fetches of url with fetch in bursts of
+// maxIdleConnsPerHost at once, waiting for each burst to finish before the next, as the monitors
+// of many URLs on one site due at the same time would.
+func fetchInBursts(b *testing.B, url string, fetch func(string) error) {
+	burst := maxIdleConnsPerHost
+	for done := 0; done < b.N; done += burst {
+		var wg sync.WaitGroup
+		for i := done; i < done+burst && i < b.N; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := fetch(url); err != nil {
+					b.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// BenchmarkFetchConnectionReuse compares how many connections bursts of
+// fetches of one host open through the default transport, which keeps only
+// two idle connections per host between bursts, and through the tuned
+// shared one. The conns/op metric is new connections per fetch.
+func BenchmarkFetchConnectionReuse(b *testing.B) {
+	b.Run("default transport", func(b *testing.B) {
+		srv, conns := countingServer(b, http.HandlerFunc(servePage))
+		client := &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+		b.ResetTimer()
+		fetchInBursts(b, srv.URL, func(url string) error {
+			resp, err := client.Get(url)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			return err
+		})
+		b.ReportMetric(float64(atomic.LoadInt64(conns))/float64(b.N), "conns/op")
+	})
+	b.Run("shared transport", func(b *testing.B) {
+		srv, conns := countingServer(b, http.HandlerFunc(servePage))
+		b.ResetTimer()
+		fetchInBursts(b, srv.URL, func(url string) error {
+			_, _, err := fetchBody(context.Background(), FetchProfile{}, url)
+			return err
+		})
+		b.ReportMetric(float64(atomic.LoadInt64(conns))/float64(b.N), "conns/op")
+	})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// WebhookPayload is the JSON body POSTed to WEBHOOK_URL when a change is
+// detected. SnapshotID is the row id of the new snapshot, which can be
+// passed to /diff along with the previous one.
+type WebhookPayload struct {
+	URL        string    `json:"url"`
+	ChangedAt  time.Time `json:"changed_at"`
+	SnapshotID int64     `json:"snapshot_id"`
+}
+
+// sendWebhookNotification POSTs a WebhookPayload to WEBHOOK_URL.
+func sendWebhookNotification(monitoredURL string, changeTime time.Time, snapshotID int64) error {
+	webhookURL := os.Getenv("WEBHOOK_URL")
+	if webhookURL == "" {
+		return errors.New("missing webhook URL")
+	}
+
+	body, err := json.Marshal(WebhookPayload{URL: monitoredURL, ChangedAt: changeTime, SnapshotID: snapshotID})
+	if err != nil {
+		return fmt.Errorf("encoding webhook payload: %w", err)
+	}
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned non-OK HTTP status: %s", resp.Status)
+	}
+	slog.Debug("Webhook notification sent", "status", resp.Status)
+	return nil
+}